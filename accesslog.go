@@ -0,0 +1,91 @@
+package CertificateService
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+/*
+accessLog logs every http request at level once it has been answered: its method, path,
+client IP, status and how long it took. Only the path is logged, never the query string, so
+nothing a client puts there ends up in the logs. A nil accessLog logs nothing.
+*/
+type accessLog struct {
+	logger *slog.Logger
+	level  slog.Level
+	// finds the client IP a request came from
+	ips *clientIPs
+}
+
+// newAccessLog returns the access log for cfg, logging client IPs as ips finds them, or nil if it is disabled.
+func newAccessLog(cfg Config, ips *clientIPs) *accessLog {
+	if cfg.DisableAccessLog {
+		return nil
+	}
+	return &accessLog{logger: cfg.Logger, level: cfg.AccessLogLevel, ips: ips}
+}
+
+/*
+wrap returns next with every request logged. A request whose handler panics, such as an
+aborted export, is logged before the panic carries on up to the server.
+*/
+func (a *accessLog) wrap(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func(start time.Time) {
+			a.logger.LogAttrs(context.Background(), a.level, "http request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("client_ip", a.ips.of(r)),
+				slog.Int("status", rec.statusCode()),
+				slog.Duration("duration", time.Since(start)),
+			)
+		}(time.Now())
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder is a ResponseWriter that remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through, so streamed responses such as /export still stream.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// statusCode is the status written, 200 if the handler wrote nothing, as the server sends then.
+func (s *statusRecorder) statusCode() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
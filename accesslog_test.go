@@ -0,0 +1,76 @@
+package CertificateService
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAccessLog checks every request is logged with its method, path, client IP and status, without the query.
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	svc, err := NewCertificateServiceWithConfig(Config{
+		Storage: newFakeStorage(newFakeRedis(), HashLayout),
+		Logger:  slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		// only the requests are counted
+		AuditLogger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := svc.(*dbConn)
+
+	r := httptest.NewRequest("POST", "/certcreate/fanatics.com?token=secret", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	db.httpHandler(httptest.NewRecorder(), r)
+	db.httpHandler(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/cert/missing.com", nil))
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 requests logged, got %q", logs.String())
+	}
+	for i, expected := range [][]string{
+		{"level=INFO", "method=POST", "path=/certcreate/fanatics.com ", "client_ip=192.0.2.1", "status=201", "duration="},
+		{"method=HEAD", "path=/cert/missing.com", "status=404"},
+	} {
+		for _, field := range expected {
+			if !strings.Contains(lines[i], field) {
+				t.Errorf("expected %q in %q", field, lines[i])
+			}
+		}
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("the query string was logged: %s", logs.String())
+	}
+
+	// below the handler's level nothing is logged, and disabled the access log is skipped entirely
+	logs.Reset()
+	db = newFakeDBWithConfig(newFakeRedis(), Config{Logger: slog.New(slog.NewTextHandler(&logs, nil)), AccessLogLevel: slog.LevelDebug})
+	db.httpHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/count", nil))
+	if logs.Len() != 0 {
+		t.Errorf("expected nothing logged, got %s", logs.String())
+	}
+	if newAccessLog(Config{DisableAccessLog: true}, nil) != nil {
+		t.Error("expected a disabled access log to be nil")
+	}
+}
+
+// TestStatusRecorder checks the recorded status is the first written, or 200 when only a body is.
+func TestStatusRecorder(t *testing.T) {
+	rec := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	if rec.statusCode() != http.StatusOK {
+		t.Errorf("expected 200 before anything is written, got %d", rec.statusCode())
+	}
+	rec.WriteHeader(http.StatusTeapot)
+	rec.WriteHeader(http.StatusOK)
+	if rec.statusCode() != http.StatusTeapot {
+		t.Errorf("expected the first status to be kept, got %d", rec.statusCode())
+	}
+	if _, ok := http.ResponseWriter(rec).(http.Flusher); !ok {
+		t.Error("expected the recorder to pass flushes through")
+	}
+}
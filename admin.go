@@ -0,0 +1,103 @@
+package CertificateService
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// lifetimeSettings is the JSON body of the /admin/lifetime endpoint.
+type lifetimeSettings struct {
+	TTL         string `json:"ttl"`
+	RenewBuffer string `json:"renew_buffer"`
+}
+
+// authorizedAdmin reports whether r carries the admin bearer token, compared in constant time.
+func (db *dbConn) authorizedAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return db.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(db.adminToken)) == 1
+}
+
+/*
+admin reports whether r may use an admin endpoint. If not it has been answered: 404 Not Found
+while the admin endpoints are disabled, otherwise 401 Unauthorized.
+*/
+func (db *dbConn) admin(w http.ResponseWriter, r *http.Request) bool {
+	if db.adminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	if !db.authorizedAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+/*
+lifetimeHandler serves /admin/lifetime. GET reports the current certificate lifetime and
+renewal buffer, PUT or POST replaces them with the durations in the JSON body, for example
+{"ttl":"24h","renew_buffer":"1h"}. Either field may be omitted to keep its current value.
+The change affects future creations and renewals only.
+*/
+func (db *dbConn) lifetimeHandler(w http.ResponseWriter, r *http.Request) {
+	if !db.admin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var body lifetimeSettings
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl, renewBuffer := db.lifetime()
+		var err error
+		if body.TTL != "" {
+			if ttl, err = time.ParseDuration(body.TTL); err != nil {
+				http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if body.RenewBuffer != "" {
+			if renewBuffer, err = time.ParseDuration(body.RenewBuffer); err != nil {
+				http.Error(w, "invalid renew_buffer: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err = db.setLifetime(ttl, renewBuffer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttl, renewBuffer := db.lifetime()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lifetimeSettings{TTL: ttl.String(), RenewBuffer: renewBuffer.String()})
+}
+
+/*
+flushHandler serves /flush, which deletes every stored cert and answers with how many were
+removed, {"removed":42}. It is an admin endpoint, as there is no undoing it.
+*/
+func (db *dbConn) flushHandler(w http.ResponseWriter, r *http.Request) {
+	if !db.admin(w, r) {
+		return
+	}
+	removed, err := db.flush(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
@@ -0,0 +1,110 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAdminLifetime changes the lifetime through /admin/lifetime and checks new certs use it.
+func TestAdminLifetime(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{AdminToken: "secret"})
+
+	send := func(token string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/admin/lifetime", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return w
+	}
+
+	if w := send("", `{"ttl":"24h"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	if w := send("wrong", `{"ttl":"24h"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with the wrong token, got %d", w.Code)
+	}
+	if w := send("secret", `{"ttl":"1s"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a TTL too short to renew, got %d", w.Code)
+	}
+	if ttl, _ := db.lifetime(); ttl != defaultTTL {
+		t.Fatalf("rejected requests changed the TTL to %v", ttl)
+	}
+
+	w := send("secret", `{"ttl":"24h","renew_buffer":"1h"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"ttl":"24h0m0s"`) {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if db.renewInterval() != time.Hour*23 {
+		t.Errorf("expected renewal after 23 hours, got %v", db.renewInterval())
+	}
+
+	cert, err := db.createCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(cert.NotAfter); d < time.Hour*24-time.Minute || d > time.Hour*24 {
+		t.Errorf("expected a cert created after the change to last 24 hours, it lasts %v", d)
+	}
+}
+
+// TestAdminDisabled checks the admin endpoints don't exist without an admin token.
+func TestAdminDisabled(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	r := httptest.NewRequest("GET", "/admin/lifetime", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	db.httpHandler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 with no admin token configured, got %d", w.Code)
+	}
+}
+
+// TestFlush flushes a namespace through /flush and checks only its certs are removed.
+func TestFlush(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDBWithConfig(fake, Config{AdminToken: "secret", Namespace: "tenantA"})
+	other := newFakeDBWithConfig(fake, Config{Namespace: "tenantB"})
+	for _, domain := range []string{"a.com", "b.com"} {
+		if _, err := db.createCert(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.createCert(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.set("Unrelated", "field", []byte("value"))
+
+	flush := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/flush", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return w
+	}
+	if w := flush("wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong token to be refused, got %d", w.Code)
+	}
+	w := flush("secret")
+	var result map[string]int
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result["removed"] != 2 {
+		t.Fatalf("expected 2 certs removed, got %d %s", w.Code, w.Body)
+	}
+	if n, err := db.Count(); n != 0 || err != nil {
+		t.Errorf("expected the namespace to be empty, got %d %v", n, err)
+	}
+	if n, err := other.Count(); n != 2 || err != nil {
+		t.Errorf("expected the other namespace to keep its certs, got %d %v", n, err)
+	}
+	for _, key := range []string{"Unrelated", "tenantB:Domain", "tenantB:Certificate", "tenantB:PrivateKey"} {
+		if !strings.Contains(strings.Join(fake.keys(), " "), key) {
+			t.Errorf("expected %s to be left alone, keys are %v", key, fake.keys())
+		}
+	}
+}
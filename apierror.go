@@ -0,0 +1,96 @@
+package CertificateService
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+/*
+ErrorCode is the stable, machine readable code of an error answered in JSON, so clients can
+branch on it rather than on the message beside it, which is for people and may change. Only
+ErrorCodes are compared; a code is never removed or given another meaning.
+*/
+type ErrorCode string
+
+const (
+	// CodeInvalidDomain is a domain, or subject alternative name, the service doesn't accept.
+	CodeInvalidDomain ErrorCode = "invalid_domain"
+
+	// CodeInvalidRequest is a request that can't be served as sent, such as an unparsable ttl or body.
+	CodeInvalidRequest ErrorCode = "invalid_request"
+
+	// CodeNotFound is a domain without a cert, nor a wildcard covering it, or without a history.
+	CodeNotFound ErrorCode = "not_found"
+
+	// CodeExpired is a cert found but expired.
+	CodeExpired ErrorCode = "expired"
+
+	// CodeRevoked is a cert found but revoked.
+	CodeRevoked ErrorCode = "revoked"
+
+	// CodeBackendUnavailable is a store too busy to answer, ErrBackendBusy, or a service already closed.
+	CodeBackendUnavailable ErrorCode = "backend_unavailable"
+
+	// CodeAlreadyExists is a strict create of a domain that already has a cert, ErrDomainExists.
+	CodeAlreadyExists ErrorCode = "already_exists"
+
+	// CodeStoreFull is a new domain without room under Config.MaxDomains, ErrStoreFull.
+	CodeStoreFull ErrorCode = "store_full"
+
+	// CodeTooManyDomains is a batch larger than Config.MaxBatchSize.
+	CodeTooManyDomains ErrorCode = "too_many_domains"
+
+	// CodeInternal is any other failure, such as a failed store call.
+	CodeInternal ErrorCode = "internal_error"
+)
+
+// errorCode returns the code of err, a failure of the store or a create.
+func errorCode(err error) ErrorCode {
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return CodeInvalidDomain
+	case errors.Is(err, ErrDomainNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrDomainExists):
+		return CodeAlreadyExists
+	case errors.Is(err, ErrStoreFull):
+		return CodeStoreFull
+	case errors.Is(err, ErrBackendBusy), errors.Is(err, ErrServiceClosed):
+		return CodeBackendUnavailable
+	}
+	return CodeInternal
+}
+
+// apiError is the JSON body of an error, Reasons listing every reason for CodeInvalidDomain.
+type apiError struct {
+	Code    ErrorCode `json:"code"`
+	Error   string    `json:"error"`
+	Reasons []string  `json:"reasons,omitempty"`
+}
+
+// writeJSONError answers with status and body.
+func writeJSONError(w http.ResponseWriter, status int, body apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+/*
+httpError answers with status and msg, as http.Error does, or as an apiError carrying code
+when asJSON, for a request in JSON mode.
+*/
+func httpError(w http.ResponseWriter, asJSON bool, status int, code ErrorCode, msg string) {
+	if asJSON {
+		writeJSONError(w, status, apiError{Code: code, Error: msg})
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// jsonMode reports whether r asks for its response, errors included, as JSON with ?format=json.
+func jsonMode(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json"
+}
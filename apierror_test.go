@@ -0,0 +1,112 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestErrorCodes checks every error answered as JSON carries the code of its scenario.
+func TestErrorCodes(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDBWithConfig(fake, Config{MaxDomains: 2, MaxBatchSize: 2})
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	for _, domain := range []string{"fanatics.com", "revoked.com"} {
+		if _, err := db.createCert(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.httpHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/revoke/revoked.com", nil))
+
+	for _, tc := range []struct {
+		name, method, path string
+		status             int
+		code               ErrorCode
+	}{
+		{"retrieve invalid", "GET", "/cert/bad_domain?format=json", http.StatusBadRequest, CodeInvalidDomain},
+		{"retrieve missing", "GET", "/cert/missing.com?format=json", http.StatusNotFound, CodeNotFound},
+		{"retrieve revoked", "GET", "/cert/revoked.com?format=json", http.StatusOK, CodeRevoked},
+		{"retrieve too long", "GET", "/cert/" + strings.Repeat("a", 300) + ".com?format=json", http.StatusBadRequest, CodeInvalidDomain},
+		{"create invalid", "POST", "/certcreate/bad_domain?format=json", http.StatusBadRequest, CodeInvalidDomain},
+		{"create ip", "POST", "/certcreate/10.0.0.1?format=json", http.StatusBadRequest, CodeInvalidDomain},
+		{"create bad ttl", "POST", "/certcreate/fanatics.com?format=json&ttl=soon", http.StatusBadRequest, CodeInvalidRequest},
+		{"create invalid san", "POST", "/certcreate/fanatics.com?format=json&san=bad_name.com", http.StatusBadRequest, CodeInvalidDomain},
+		{"create duplicate san", "POST", "/certcreate/fanatics.com?format=json&san=fanatics.com", http.StatusBadRequest, CodeInvalidRequest},
+		{"create full", "POST", "/certcreate/new.com?format=json", http.StatusInsufficientStorage, CodeStoreFull},
+		{"revoke invalid", "POST", "/revoke/bad_domain", http.StatusBadRequest, CodeInvalidDomain},
+		{"revoke missing", "POST", "/revoke/missing.com", http.StatusNotFound, CodeNotFound},
+		{"history missing", "GET", "/certhistory/missing.com", http.StatusNotFound, CodeNotFound},
+		{"validate invalid", "GET", "/validate/bad_domain", http.StatusBadRequest, CodeInvalidDomain},
+		{"batch too large", "GET", "/cert?domains=a.com,b.com,c.com", http.StatusRequestEntityTooLarge, CodeTooManyDomains},
+		{"batch not JSON", "POST", "/certcreate", http.StatusBadRequest, CodeInvalidRequest},
+	} {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader("{")))
+		var body struct{ Code ErrorCode }
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != tc.status || body.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %s", tc.name, tc.status, tc.code, w.Code, w.Body)
+		}
+	}
+
+	now = now.Add(defaultTTL + time.Second)
+	w := httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert?domains=fanatics.com,bad_domain"))
+	var results []batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || len(results) != 2 || results[0].Code != CodeExpired || results[1].Code != CodeInvalidDomain {
+		t.Errorf("expected an expired and an invalid domain, got %s", w.Body)
+	}
+
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	w = httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert/fanatics.com?format=json"))
+	if !strings.Contains(w.Body.String(), `"code":"internal_error"`) || w.Code != http.StatusInternalServerError {
+		t.Errorf("expected a failed store to be an internal error, got %d %s", w.Code, w.Body)
+	}
+}
+
+// TestErrorCode checks the internal errors map to their codes, however they are wrapped.
+func TestErrorCode(t *testing.T) {
+	for err, code := range map[error]ErrorCode{
+		ErrDomainNotFound:                           CodeNotFound,
+		fmt.Errorf("storing: %w", ErrBackendBusy):   CodeBackendUnavailable,
+		ErrServiceClosed:                            CodeBackendUnavailable,
+		fmt.Errorf("creating: %w", ErrStoreFull):    CodeStoreFull,
+		ValidateDomain("bad_domain"):                CodeInvalidDomain,
+		errors.New("ERR wrong number of arguments"): CodeInternal,
+	} {
+		if got := errorCode(err); got != code {
+			t.Errorf("%v: expected %s, got %s", err, code, got)
+		}
+	}
+}
+
+// TestCreateJSON checks a create with ?format=json answers with the cert it issued.
+func TestCreateJSON(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	create := func() (int, createResult) {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest("/certcreate/Fanatics.com?format=json&san=www.fanatics.com"))
+		var result createResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%v: %s", err, w.Body)
+		}
+		return w.Code, result
+	}
+	if code, result := create(); code != http.StatusCreated || result.Status != "created" || result.Domain != "fanatics.com" || len(result.SANs) != 2 {
+		t.Errorf("expected the domain to be created, got %d %+v", code, result)
+	}
+	cert, err := db.getCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, result := create(); code != http.StatusOK || result.Status != "renewed" || result.Serial == serialNumber(cert) {
+		t.Errorf("expected the domain to be renewed with a new cert, got %d %+v", code, result)
+	}
+}
@@ -0,0 +1,104 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+/*
+actor is who made a request, as the audit log records it: where it came from, over http or
+gRPC, the API key it was made with when keys are required, and its client cert under mTLS.
+Work the service does on its own, such as renewing its cert or sweeping, has no actor.
+*/
+type actor struct {
+	source   string
+	clientIP string
+	keyID    string
+	// the subject of the client's verified cert, under Config.ClientCAs
+	clientCert string
+}
+
+type actorKey struct{}
+
+// actorOf returns the actor that made the request ctx belongs to, if any.
+func actorOf(ctx context.Context) (actor, bool) {
+	a, ok := ctx.Value(actorKey{}).(actor)
+	return a, ok
+}
+
+/*
+keyID identifies key in the audit log without revealing it: the first 8 bytes of its SHA-256,
+in hex, enough to tell the keys apart but not to guess one.
+*/
+func keyID(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:8])
+}
+
+/*
+identify returns next with the actor of every request in its context, for the audit log. The
+key is only recorded when creates require one, as every audited action is a create, delete or
+revoke, so it has been checked by the time the action succeeds.
+*/
+func (db *dbConn) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := actor{source: "http", clientIP: db.clientIPs.of(r), clientCert: clientCertSubject(r.TLS)}
+		if db.createKeys != nil {
+			if key := presentedKey(r.Header.Get("Authorization"), r.Header.Get("X-API-Key")); key != "" {
+				a.keyID = keyID(key)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, a)))
+	})
+}
+
+// identifyRPC is identify for gRPC calls, the client being the peer and the key in the metadata.
+func (db *dbConn) identifyRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	a := actor{source: "grpc", clientCert: rpcClientCertSubject(ctx)}
+	if p, ok := peer.FromContext(ctx); ok {
+		a.clientIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(a.clientIP); err == nil {
+			a.clientIP = host
+		}
+	}
+	if db.createKeys != nil {
+		first := func(key string) string {
+			if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}
+		if key := presentedKey(first("authorization"), first("x-api-key")); key != "" {
+			a.keyID = keyID(key)
+		}
+	}
+	return handler(context.WithValue(ctx, actorKey{}, a), req)
+}
+
+/*
+audit records that action succeeded on domain through Config.AuditLogger, with the actor of
+ctx, or as done by the service itself, when it happened by the service's clock, and attrs such
+as the serial of the cert. Failed actions aren't audited, the operational log has them.
+*/
+func (db *dbConn) audit(ctx context.Context, action string, domain string, attrs ...any) {
+	event := []any{"action", action, "domain", domain, "at", db.now()}
+	if a, ok := actorOf(ctx); ok {
+		event = append(event, "source", a.source, "client_ip", a.clientIP)
+		if a.keyID != "" {
+			event = append(event, "api_key", a.keyID)
+		}
+		if a.clientCert != "" {
+			event = append(event, "client_cert", a.clientCert)
+		}
+	} else {
+		event = append(event, "source", "service")
+	}
+	db.auditLogger.Info("audit", append(event, attrs...)...)
+}
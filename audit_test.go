@@ -0,0 +1,81 @@
+package CertificateService
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TestAudit checks creates, revokes and deletes are audited with their actor when they succeed, and only then.
+func TestAudit(t *testing.T) {
+	var logs, audits bytes.Buffer
+	fake := newFakeRedis()
+	db := newFakeDBWithConfig(fake, Config{
+		Logger:      slog.New(slog.NewTextHandler(&logs, nil)),
+		AuditLogger: slog.New(slog.NewTextHandler(&audits, nil)),
+		APIKeys:     []string{"secret"},
+	})
+	send := func(method, path string) {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		r.RemoteAddr = "192.0.2.7:1234"
+		db.httpHandler(httptest.NewRecorder(), r)
+	}
+
+	send("POST", "/certcreate/fanatics.com")
+	send("POST", "/revoke/fanatics.com")
+	cert, _ := db.getCert(context.Background(), "fanatics.com")
+	lines := strings.Split(strings.TrimSpace(audits.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a create and a revoke audited, got %q", audits.String())
+	}
+	for i, action := range []string{"create", "revoke"} {
+		for _, attr := range []string{"msg=audit action=" + action + " domain=fanatics.com", "source=http client_ip=192.0.2.7 api_key=" + keyID("secret"), "serial=" + serialNumber(cert)} {
+			if !strings.Contains(lines[i], attr) {
+				t.Errorf("expected the %s audited with %s, got %q", action, attr, lines[i])
+			}
+		}
+	}
+	if strings.Contains(audits.String(), "secret") || strings.Contains(logs.String(), "msg=audit") {
+		t.Errorf("expected the key kept out of the audit, and the audit out of the main log, got %q", audits.String())
+	}
+
+	// failures aren't audited
+	audits.Reset()
+	send("POST", "/revoke/missing.com")
+	send("POST", "/certcreate/-invalid")
+	if audits.Len() != 0 {
+		t.Errorf("expected nothing audited for failures, got %q", audits.String())
+	}
+
+	// a delete over gRPC, with the key in the metadata
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "secret"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/certpb.CertificateService/DeleteCert"}
+	db.identifyRPC(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return db.deleteCert(ctx, "fanatics.com")
+	})
+	if !strings.Contains(audits.String(), "action=delete domain=fanatics.com") || !strings.Contains(audits.String(), "source=grpc") || !strings.Contains(audits.String(), "api_key="+keyID("secret")) {
+		t.Errorf("expected the gRPC delete audited, got %q", audits.String())
+	}
+
+	// the service's own actions have no client
+	audits.Reset()
+	db.createCert(context.Background(), "fanatics.com")
+	if !strings.Contains(audits.String(), "action=create domain=fanatics.com") || !strings.Contains(audits.String(), "source=service") || strings.Contains(audits.String(), "client_ip") {
+		t.Errorf("expected the create audited as the service's, got %q", audits.String())
+	}
+
+	// without a separate sink the audit goes to the main log
+	logs.Reset()
+	db = newFakeDBWithConfig(newFakeRedis(), Config{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	db.createCert(context.Background(), "fanatics.com")
+	if !strings.Contains(logs.String(), "msg=audit action=create") {
+		t.Errorf("expected the audit in the main log by default, got %q", logs.String())
+	}
+}
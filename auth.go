@@ -0,0 +1,74 @@
+package CertificateService
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+/*
+apiKeys is the set of keys that may call a protected route, kept as SHA-256 digests so every
+comparison takes the same time whatever the length of the key presented. A nil apiKeys
+protects nothing.
+*/
+type apiKeys [][sha256.Size]byte
+
+// newAPIKeys returns the set of keys, or nil if there are none.
+func newAPIKeys(keys []string) apiKeys {
+	if len(keys) == 0 {
+		return nil
+	}
+	digests := make(apiKeys, len(keys))
+	for i, key := range keys {
+		digests[i] = sha256.Sum256([]byte(key))
+	}
+	return digests
+}
+
+/*
+require wraps next so a request without a valid key, in an Authorization: Bearer or an
+X-API-Key header, is answered 401 Unauthorized.
+*/
+func (k apiKeys) require(next http.HandlerFunc) http.HandlerFunc {
+	if k == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !k.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// authorized reports whether r carries one of the keys.
+func (k apiKeys) authorized(r *http.Request) bool {
+	return k.valid(presentedKey(r.Header.Get("Authorization"), r.Header.Get("X-API-Key")))
+}
+
+// presentedKey is the key sent as authorization, "Bearer {key}", or else as apiKey.
+func presentedKey(authorization string, apiKey string) string {
+	if bearer, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return bearer
+	}
+	return apiKey
+}
+
+/*
+valid reports whether key is one of the keys. Every key is compared, in constant time, so how
+long it takes doesn't tell which key, or how much of one, was matched.
+*/
+func (k apiKeys) valid(key string) bool {
+	if key == "" {
+		return false
+	}
+	presented := sha256.Sum256([]byte(key))
+	match := 0
+	for _, digest := range k {
+		match |= subtle.ConstantTimeCompare(presented[:], digest[:])
+	}
+	return match == 1
+}
@@ -0,0 +1,66 @@
+package CertificateService
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAPIKeys checks creating requires a valid key in either header, while retrieval stays public.
+func TestAPIKeys(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"first", "second"}})
+	send := func(path string, header string, value string) int {
+		r := newRequest(path)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, r)
+		return rec.Code
+	}
+
+	requests := []struct {
+		path, header, value string
+		code                int
+	}{
+		{"/certcreate/fanatics.com", "", "", http.StatusUnauthorized},
+		{"/certcreate/fanatics.com", "Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"/certcreate/fanatics.com", "Authorization", "Bearer ", http.StatusUnauthorized},
+		{"/certcreate/fanatics.com", "Authorization", "first", http.StatusUnauthorized},
+		{"/certcreate/fanatics.com", "X-API-Key", "firs", http.StatusUnauthorized},
+		{"/certcreate", "", "", http.StatusUnauthorized},
+		{"/certcreate/fanatics.com", "Authorization", "Bearer first", http.StatusCreated},
+		{"/certcreate/fanatics.com", "X-API-Key", "second", http.StatusOK},
+		{"/cert/fanatics.com", "", "", http.StatusOK},
+		{"/certs", "", "", http.StatusOK},
+		{"/count", "", "", http.StatusOK},
+	}
+	for _, req := range requests {
+		if code := send(req.path, req.header, req.value); code != req.code {
+			t.Errorf("%s with %s %q: expected %d, got %d", req.path, req.header, req.value, req.code, code)
+		}
+	}
+}
+
+// TestAPIKeysLocked checks retrieval and health checks can be locked behind the keys too.
+func TestAPIKeysLocked(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"key"}, APIKeyRetrieve: true, APIKeyHealth: true})
+	for _, path := range []string{"/cert/fanatics.com", "/cert?domains=fanatics.com", "/certs", "/count", "/healthz"} {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, newRequest(path))
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: expected 401 without a key, got %d", path, rec.Code)
+		}
+		r := newRequest(path)
+		r.Header.Set("X-API-Key", "key")
+		rec = httptest.NewRecorder()
+		db.httpHandler(rec, r)
+		if rec.Code == http.StatusUnauthorized {
+			t.Errorf("%s: expected the key to be accepted", path)
+		}
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{APIKeyHealth: true}); err == nil {
+		t.Error("expected locking health checks without any keys to be rejected")
+	}
+}
@@ -0,0 +1,364 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	//imported package, run go get go.opentelemetry.io/otel
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// batchResult is the outcome of creating one domain of a batch.
+type batchResult struct {
+	Domain string `json:"domain"`
+	// Status is what a single create or retrieve of the domain would have responded.
+	Status string `json:"status"`
+	// Code is the ErrorCode of a domain that failed, or whose cert can't be trusted.
+	Code ErrorCode `json:"code,omitempty"`
+	/*
+		ExpiresAt is when the cert expires and ExpiresIn the seconds until then, negative once it
+		has expired. Only set when retrieving a domain that has a cert.
+	*/
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn *int64     `json:"expires_in_seconds,omitempty"`
+	// Serial is the serial number of the cert, in hex, and IssuedAt when it was issued. Set alongside ExpiresAt.
+	Serial   string     `json:"serial,omitempty"`
+	IssuedAt *time.Time `json:"issued_at,omitempty"`
+	// KeyAlgorithm, "ECDSA" or "RSA", and KeySize, in bits, describe the cert's key. Set alongside ExpiresAt.
+	KeyAlgorithm string `json:"key_algorithm,omitempty"`
+	KeySize      int    `json:"key_size,omitempty"`
+	// SANs are every name the cert covers, the domain it was issued for first. Set alongside ExpiresAt.
+	SANs []string `json:"sans,omitempty"`
+}
+
+/*
+batchCreateHandler creates every domain in the JSON array POSTed to /certcreate and responds
+with the status of each, in order, so partial failures are visible. Batches larger than the
+configured maximum are rejected with 413. Given an Idempotency-Key every domain is created as
+a single create with that key is, see createBatchOnce, so each is only created once however
+it is sent again.
+*/
+func (db *dbConn) batchCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var domains []string
+	if err := json.NewDecoder(r.Body).Decode(&domains); err != nil {
+		writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidRequest, Error: "expected a JSON array of domain names: " + err.Error()})
+		return
+	}
+	if len(domains) > db.maxBatchSize {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, apiError{Code: CodeTooManyDomains, Error: "at most " + strconv.Itoa(db.maxBatchSize) + " domains may be created at once"})
+		return
+	}
+
+	var results []batchResult
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		results = db.createBatchOnce(r.Context(), domains, key)
+	} else {
+		results = db.createBatch(r.Context(), domains)
+	}
+	body, _ := json.Marshal(results)
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, string(body))
+}
+
+/*
+createBatchOnce is createBatch for a batch sent with an idempotency key. Each domain is
+created through createOnce in the scope of a single create of it, so a domain sent with the
+same key runs once whether it comes in a batch or on its own, and its result is replayed
+either way. The domains are written one at a time rather than in a single round trip.
+*/
+func (db *dbConn) createBatchOnce(ctx context.Context, domains []string, key string) []batchResult {
+	mode := db.createMode()
+	results := make([]batchResult, len(domains))
+	done := make(map[string]batchResult)
+	for i, domain := range domains {
+		domain, ok := db.checkDomain(domain)
+		if !ok {
+			results[i] = batchResult{Domain: domain, Status: "Invalid domain name: " + domain, Code: CodeInvalidDomain}
+			continue
+		}
+		if result, ok := done[domain]; ok {
+			// a domain listed twice is only created once
+			results[i] = result
+			continue
+		}
+		results[i].Domain = domain
+		resp, err := db.createOnce(ctx, modeScope(mode)+domain, domain, key, 0, nil, nil, mode, db.createdText(domain))
+		if err != nil {
+			results[i].Status, results[i].Code = err.Error(), errorCode(err)
+		} else {
+			// the status a batch reports, carrying the availability of the create replayed
+			results[i].Status = "OK"
+			if _, after, ok := strings.Cut(resp.Body, ", available after "); ok {
+				results[i].Status += ", available after " + after
+			}
+		}
+		done[domain] = results[i]
+	}
+	return results
+}
+
+/*
+createBatch validates and creates every domain in domains, writing all of the certs to the
+store in a single call, and returns the status of each. Under Config.MaxDomains the new
+domains that don't fit fail with ErrStoreFull, the last listed first, and under
+Config.StrictCreate the domains that have a cert fail with ErrDomainExists. The domains are
+written holding their slots in db.creates, so none races a single create or renewal of it, and
+a create of one waiting on the batch shares its cert.
+*/
+func (db *dbConn) createBatch(ctx context.Context, domains []string) []batchResult {
+	ctx, span := db.startSpan(ctx, "createCerts", attribute.Int("domains", len(domains)), attribute.String("operation", "create"))
+	defer span.End()
+	ttl, _ := db.lifetime()
+	now := db.now()
+	notAfter := now.Add(ttl)
+
+	results := make([]batchResult, len(domains))
+	recs := make(map[string]Record)
+	certs := make(map[string]*x509.Certificate)
+	// the domains of recs in the order listed
+	var order []string
+	for i, domain := range domains {
+		domain, ok := db.checkDomain(domain)
+		results[i].Domain = domain
+		if !ok {
+			results[i].Status, results[i].Code = "Invalid domain name: "+domain, CodeInvalidDomain
+			continue
+		}
+		if _, ok := recs[domain]; ok {
+			// a domain listed twice is only created once
+			continue
+		}
+		cert, certPEM, keyPEM, err := generateCert(domain, nil, now, notAfter, db.keyType)
+		if err != nil {
+			results[i].Status, results[i].Code = err.Error(), CodeInternal
+			continue
+		}
+		recs[domain] = Record{Expires: notAfter, IssuedAt: now, CertPEM: certPEM, KeyPEM: keyPEM}
+		certs[domain] = cert
+		order = append(order, domain)
+	}
+
+	var created map[string]bool
+	errs := make(map[string]error)
+	var calls map[string]*createCall
+	if len(recs) > 0 {
+		calls = db.creates.acquire(order, db.createMode())
+		defer db.creates.release(calls)
+	}
+	if db.strictCreate && len(recs) > 0 {
+		order = db.dropStored(ctx, order, recs, errs)
+	}
+	if len(recs) > 0 {
+		release, full, err := db.reserveMany(ctx, order)
+		if err != nil {
+			full = make(map[string]bool)
+			for domain := range recs {
+				full[domain] = true
+			}
+		} else {
+			defer release()
+			err = ErrStoreFull
+		}
+		for domain := range full {
+			errs[domain] = err
+			delete(recs, domain)
+			db.metrics.creates.WithLabelValues("error").Inc()
+		}
+	}
+	if len(recs) > 0 {
+		ctx, cancel := db.withTimeout(ctx)
+		defer cancel()
+		start := time.Now()
+		var setErrs map[string]error
+		created, setErrs = db.store.SetMany(ctx, recs)
+		// the failures are counted per domain below
+		db.observeRedis("set", start, nil, "domains", len(recs))
+		for domain, err := range setErrs {
+			errs[domain] = err
+		}
+		for domain := range recs {
+			if err := errs[domain]; err != nil {
+				db.metrics.redisErrors.WithLabelValues("set").Inc()
+				db.metrics.creates.WithLabelValues("error").Inc()
+				db.logger.Error("storing a cert in redis failed", "domain", domain, "err", err)
+			} else {
+				db.metrics.created.Inc()
+				db.audit(ctx, auditAction(created[domain]), domain, "serial", serialNumber(certs[domain]))
+				db.recordIssuance(ctx, domain, certs[domain])
+				db.renewed(domain, recs[domain].Expires)
+				db.metrics.creates.WithLabelValues("ok").Inc()
+			}
+		}
+	}
+	for domain, call := range calls {
+		if call.err = errs[domain]; call.err == nil {
+			call.cert, call.created = certs[domain], created[domain]
+		}
+	}
+
+	for i := range results {
+		if results[i].Status != "" {
+			continue
+		}
+		if err := errs[results[i].Domain]; err != nil {
+			results[i].Status, results[i].Code = err.Error(), errorCode(err)
+		} else {
+			results[i].Status = db.createdResponse(certs[results[i].Domain], created[results[i].Domain])
+		}
+	}
+	return results
+}
+
+/*
+dropStored fails the domains of a strict batch create that already have a cert with
+ErrDomainExists, or all of them if that can't be looked up, removing them from recs and
+returning the rest of order. It looks them up on the master, and runs holding their slots in
+db.creates, as checkMode does.
+*/
+func (db *dbConn) dropStored(ctx context.Context, order []string, recs map[string]Record, errs map[string]error) []string {
+	ctx, cancel := db.withTimeout(readMaster(ctx))
+	defer cancel()
+	start := time.Now()
+	stored, err := db.store.GetMany(ctx, order)
+	db.observeRedis("get", start, err, "domains", len(order))
+	var rest []string
+	for _, domain := range order {
+		_, exists := stored[domain]
+		switch {
+		case err != nil:
+			errs[domain] = err
+		case exists:
+			errs[domain] = ErrDomainExists
+		default:
+			rest = append(rest, domain)
+			continue
+		}
+		delete(recs, domain)
+		db.metrics.creates.WithLabelValues("error").Inc()
+	}
+	return rest
+}
+
+/*
+batchRetrieveHandler looks up every domain listed in the domains query parameter of a GET to
+/cert, either comma separated or as a JSON array, and responds with the status of each, in
+order. Batches larger than the configured maximum are rejected with 413.
+*/
+func (db *dbConn) batchRetrieveHandler(w http.ResponseWriter, r *http.Request) {
+	list := strings.TrimSpace(r.URL.Query().Get("domains"))
+	var domains []string
+	if strings.HasPrefix(list, "[") {
+		if err := json.Unmarshal([]byte(list), &domains); err != nil {
+			writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidRequest, Error: "invalid JSON list of domains: " + err.Error()})
+			return
+		}
+	} else if list != "" {
+		for _, domain := range strings.Split(list, ",") {
+			domains = append(domains, strings.TrimSpace(domain))
+		}
+	}
+	if len(domains) == 0 {
+		writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidRequest, Error: "expected a list of domains in the domains query parameter"})
+		return
+	}
+	if len(domains) > db.maxBatchSize {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, apiError{Code: CodeTooManyDomains, Error: "at most " + strconv.Itoa(db.maxBatchSize) + " domains may be retrieved at once"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(db.retrieveBatch(r.Context(), domains))
+}
+
+/*
+retrieveBatch looks up every domain in domains, with the same wildcard fallback as a single
+retrieve, and returns the status of each. The exact matches are read in one round trip, the
+wildcards covering the missing domains in a second and whether the certs found were revoked
+in a third.
+*/
+func (db *dbConn) retrieveBatch(ctx context.Context, domains []string) []batchResult {
+	results := make([]batchResult, len(domains))
+	var valid []string
+	for i, domain := range domains {
+		domain, ok := db.checkDomain(domain)
+		results[i].Domain = domain
+		if !ok {
+			results[i].Status, results[i].Code = "Invalid domain name: "+domain, CodeInvalidDomain
+			continue
+		}
+		valid = append(valid, domain)
+	}
+	if len(valid) == 0 {
+		return results
+	}
+
+	certs, err := db.getCerts(ctx, valid)
+	var wildcardCerts map[string]*x509.Certificate
+	if err == nil {
+		var wildcards []string
+		for _, domain := range valid {
+			if _, ok := certs[domain]; !ok {
+				if wildcard, ok := wildcardFor(domain); ok {
+					wildcards = append(wildcards, wildcard)
+				}
+			}
+		}
+		if len(wildcards) > 0 {
+			wildcardCerts, err = db.getCerts(ctx, wildcards)
+		}
+	}
+
+	// the revocations of every cert found, exact or wildcard, are read in one more round trip
+	var revoked map[string]bool
+	if err == nil && len(certs)+len(wildcardCerts) > 0 {
+		found := make([]*x509.Certificate, 0, len(certs)+len(wildcardCerts))
+		for _, cert := range certs {
+			found = append(found, cert)
+		}
+		for _, cert := range wildcardCerts {
+			found = append(found, cert)
+		}
+		revoked, err = db.revokedSerials(ctx, found)
+	}
+
+	for i := range results {
+		if results[i].Status != "" {
+			continue
+		}
+		domain := results[i].Domain
+		cert, ok := certs[domain]
+		coveredBy := ""
+		if !ok {
+			if wildcard, isSub := wildcardFor(domain); isSub {
+				if cert, ok = wildcardCerts[wildcard]; ok {
+					coveredBy = " covered by " + wildcard
+				}
+			}
+		}
+		lookupErr := err
+		if lookupErr == nil && !ok {
+			lookupErr = ErrDomainNotFound
+		}
+		var isRevoked bool
+		if ok {
+			isRevoked = revoked[serialNumber(cert)]
+		}
+		results[i].Status, _ = db.retrieveResponse(domain, coveredBy, cert, isRevoked, lookupErr)
+		if lookupErr != nil {
+			results[i].Code = errorCode(lookupErr)
+		} else {
+			results[i].Code = statusCodes[db.certStatus(cert, isRevoked)]
+			expiresIn := int64(cert.NotAfter.Sub(db.now()) / time.Second)
+			results[i].ExpiresAt, results[i].ExpiresIn = &cert.NotAfter, &expiresIn
+			results[i].Serial, results[i].IssuedAt = serialNumber(cert), &cert.NotBefore
+			results[i].KeyAlgorithm, results[i].KeySize = keyInfo(cert)
+			results[i].SANs = cert.DNSNames
+		}
+	}
+	return results
+}
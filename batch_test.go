@@ -0,0 +1,200 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBatchCreate checks a batch is written in one round trip and reports the status of every domain.
+func TestBatchCreate(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDBWithConfig(fake, Config{MaxBatchSize: 4})
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest("POST", "/certcreate", strings.NewReader(body)))
+		return rec
+	}
+
+	// the second transaction of the pipeline fails
+	execs := 0
+	fake.fail = func(cmd string) error {
+		if cmd == "EXEC" {
+			if execs++; execs == 2 {
+				return errors.New("OOM command not allowed")
+			}
+		}
+		return nil
+	}
+	rec := post(`["Fanatics.com", "-invalid", "example.net"]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d %s", rec.Code, rec.Body.String())
+	}
+	var results []batchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Domain != "fanatics.com" || results[1].Status != "Invalid domain name: -invalid" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if ok := []bool{results[0].Status == "OK", results[2].Status == "OK"}; ok[0] == ok[1] {
+		t.Errorf("expected exactly one of the valid domains to fail, got %+v", results)
+	}
+	if n := fake.count("(flush)"); n != 1 {
+		t.Errorf("expected a single round trip, got %d", n)
+	}
+
+	if rec := post(`["a.com", "b.com", "c.com", "d.com", "e.com"]`); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected an oversized batch to be rejected, got %d", rec.Code)
+	}
+	if rec := post(`"a.com"`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a non array body to be rejected, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("GET", "/certcreate", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected, got %d", rec.Code)
+	}
+}
+
+/*
+TestBatchCreateLocked checks a batch waits for a create of one of its domains that is running,
+and a create of one of them arriving while the batch is written shares its cert.
+*/
+func TestBatchCreateLocked(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	batch := func() chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			db.httpHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/certcreate", strings.NewReader(`["fanatics.com", "example.com"]`)))
+		}()
+		return done
+	}
+
+	running, release := make(chan struct{}), make(chan struct{})
+	go db.creates.do("fanatics.com", 0, nil, nil, createOrRenew, func() (*x509.Certificate, bool, error) {
+		close(running)
+		<-release
+		return nil, false, errors.New("cancelled")
+	})
+	<-running
+	done := batch()
+	time.Sleep(20 * time.Millisecond)
+	if n := fake.count("EXEC"); n != 0 {
+		t.Fatalf("expected the batch to wait for the running create, got %d writes", n)
+	}
+	close(release)
+	<-done
+	if n := fake.count("EXEC"); n != 2 {
+		t.Fatalf("expected the batch to be written, got %d writes", n)
+	}
+
+	// hold the batch's write while a create of one of its domains arrives
+	writing, write := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	fake.fail = func(cmd string) error {
+		if cmd == "EXEC" {
+			once.Do(func() {
+				close(writing)
+				<-write
+			})
+		}
+		return nil
+	}
+	done = batch()
+	<-writing
+	created := make(chan *x509.Certificate)
+	go func() {
+		cert, err := db.createCert(context.Background(), "fanatics.com")
+		if err != nil {
+			t.Error(err)
+		}
+		created <- cert
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(write)
+	<-done
+	cert := <-created
+	if stored, err := db.getCert(context.Background(), "fanatics.com"); err != nil || cert == nil || serialNumber(stored) != serialNumber(cert) {
+		t.Errorf("expected the create to share the batch's cert, got %v", err)
+	}
+	if n := fake.count("EXEC"); n != 4 {
+		t.Errorf("expected only the batch to write, got %d writes", n)
+	}
+}
+
+// TestBatchRetrieve checks a list of domains is looked up in one round trip, with the wildcard fallback.
+func TestBatchRetrieve(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	db.now = func() time.Time { return now }
+	for _, domain := range []string{"fanatics.com", "*.example.com"} {
+		if _, err := db.createCert(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.storeCert(context.Background(), "expired.com", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		"fanatics.com,www.example.com,missing.com,expired.com,-invalid",
+		`["fanatics.com","www.example.com","missing.com","expired.com","-invalid"]`,
+	} {
+		flushes := fake.count("(flush)")
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest("GET", "/cert?domains="+url.QueryEscape(query), nil))
+		var results []batchResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+			t.Fatalf("%v: %s", err, rec.Body.String())
+		}
+		expected := []string{
+			"foo{fanatics.com} valid for 10m0s until 2024-01-01T12:10:00Z, issued 2024-01-01T12:00:00Z",
+			"foo{www.example.com} covered by *.example.com valid for 10m0s until 2024-01-01T12:10:00Z, issued 2024-01-01T12:00:00Z",
+			"This domain doesn't exist: missing.com. Submit a cert request to /certcreate/{domain}",
+			"foo{expired.com} expired 1m0s ago, not trusted",
+			"Invalid domain name: -invalid",
+		}
+		if len(results) != len(expected) {
+			t.Fatalf("unexpected results %+v", results)
+		}
+		for i, status := range expected {
+			if results[i].Serial != "" {
+				status += ", serial " + results[i].Serial
+			}
+			if results[i].Status != status {
+				t.Errorf("expected %s, got %s", status, results[i].Status)
+			}
+		}
+		if results[0].ExpiresIn == nil || *results[0].ExpiresIn != 600 || !results[0].ExpiresAt.Equal(now.Add(defaultTTL)) || !results[0].IssuedAt.Equal(now) {
+			t.Errorf("expected fanatics.com to expire in 600 seconds, got %+v", results[0])
+		}
+		if results[3].ExpiresIn == nil || *results[3].ExpiresIn != -60 || results[2].ExpiresAt != nil {
+			t.Errorf("expected expired.com to have expired 60 seconds ago and missing.com no expiry, got %+v", results)
+		}
+		// the exact matches, the wildcards of the missing domains, then the revocations of the certs found
+		if n := fake.count("(flush)") - flushes; n != 3 {
+			t.Errorf("expected 3 round trips, got %d", n)
+		}
+		if cert, err := db.getCert(context.Background(), "fanatics.com"); err != nil || results[0].Serial != serialNumber(cert) {
+			t.Errorf("expected the serial of the stored cert, got %+v", results[0])
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("GET", "/cert", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a missing list to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package CertificateService
+
+import (
+	"time"
+)
+
+/*
+TTLBucket is one bar of the certificate expiry histogram returned by ListByTTLBucket.
+It counts the certificates whose remaining lifetime is at least Min and less than Max.
+A Max of 0 means the bucket is open ended.
+*/
+type TTLBucket struct {
+	Label    string
+	Min, Max time.Duration
+	Count    int
+}
+
+/*
+ListByTTLBucket counts the stored certificates grouped by their remaining lifetime, using
+the boundaries from Config.TTLBuckets. Certificates that have already expired are counted
+in a leading "expired" bucket, so the result gives an at-a-glance expiry distribution.
+*/
+func (db *dbConn) ListByTTLBucket() ([]TTLBucket, error) {
+	certs, err := db.ListCerts()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []TTLBucket{{Label: "expired"}}
+	var min time.Duration
+	for _, max := range db.ttlBuckets {
+		label := "<" + max.String()
+		if min > 0 {
+			label = min.String() + "-" + max.String()
+		}
+		buckets = append(buckets, TTLBucket{Label: label, Min: min, Max: max})
+		min = max
+	}
+	buckets = append(buckets, TTLBucket{Label: ">=" + min.String(), Min: min})
+
+	now := db.now()
+	for _, expires := range certs {
+		remaining := expires.Sub(now)
+		if remaining <= 0 {
+			buckets[0].Count++
+			continue
+		}
+		// the buckets after "expired" are in ascending order, the first that fits wins
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i].Max == 0 || remaining < buckets[i].Max {
+				buckets[i].Count++
+				break
+			}
+		}
+	}
+	return buckets, nil
+}
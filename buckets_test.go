@@ -0,0 +1,48 @@
+package CertificateService
+
+import (
+	"testing"
+	"time"
+)
+
+// TestListByTTLBucket stores domains with varied remaining lifetimes and checks the bucket counts.
+func TestListByTTLBucket(t *testing.T) {
+	fake := newFakeRedis()
+	now := time.Now()
+	remaining := map[string]time.Duration{
+		"expired.com":  -time.Minute,
+		"seconds.com":  time.Second * 30,
+		"minute.com":   time.Second * 50,
+		"twomins.com":  time.Minute * 2,
+		"fourmins.com": time.Minute * 4,
+		"later.com":    time.Minute * 9,
+	}
+	for domain, ttl := range remaining {
+		fake.set("Domain", domain, encode(now.Add(ttl), time.Time{}, nil))
+	}
+
+	buckets, err := newFakeDB(fake).ListByTTLBucket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		label string
+		count int
+	}{{"expired", 1}, {"<1m0s", 2}, {"1m0s-5m0s", 2}, {">=5m0s", 1}}
+	if len(buckets) != len(expected) {
+		t.Fatalf("expected %d buckets, got %v", len(expected), buckets)
+	}
+	for i, want := range expected {
+		if buckets[i].Label != want.label || buckets[i].Count != want.count {
+			t.Errorf("bucket %d: expected %s=%d, got %s=%d", i, want.label, want.count, buckets[i].Label, buckets[i].Count)
+		}
+	}
+
+	custom := newFakeDBWithConfig(fake, Config{TTLBuckets: []time.Duration{time.Minute * 3}})
+	if buckets, err = custom.ListByTTLBucket(); err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 3 || buckets[1].Count != 3 || buckets[2].Count != 2 {
+		t.Errorf("unexpected counts for a single 3 minute boundary: %v", buckets)
+	}
+}
@@ -0,0 +1,172 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrStoreFull is returned when a new domain is created while Config.MaxDomains are stored, and the policy is to reject it.
+var ErrStoreFull = errors.New("the store is full")
+
+// FullPolicy is what happens to a create of a new domain while Config.MaxDomains are stored.
+type FullPolicy int
+
+const (
+	// RejectWhenFull fails the create with ErrStoreFull, answered 507 Insufficient Storage over http.
+	RejectWhenFull FullPolicy = iota
+
+	// EvictWhenFull deletes the stored cert that expires soonest to make room, then creates the domain.
+	EvictWhenFull
+)
+
+/*
+capacity caps how many domains are stored at max, applying policy to a create of a new
+domain once they are. Renewing a stored domain is never held back by the cap, nor is the
+service's own cert, which is never evicted either. A nil capacity is no cap.
+
+Creates of different domains are checked and written one at a time under mu, as the
+per-domain create lock only orders creates of the same domain, so two creates can't both
+take the last place. That holds within one service; services sharing a store can still
+overshoot the cap by a create or two each.
+*/
+type capacity struct {
+	max    int
+	policy FullPolicy
+	mu     sync.Mutex
+}
+
+// newCapacity returns the cap configured by cfg, or nil if there is none.
+func newCapacity(cfg Config) *capacity {
+	if cfg.MaxDomains == 0 {
+		return nil
+	}
+	return &capacity{max: cfg.MaxDomains, policy: cfg.FullPolicy}
+}
+
+/*
+reserve makes room for a cert of domainName before it is written, returning the func to call
+once it has been, or an error matching ErrStoreFull if there is no room.
+*/
+func (db *dbConn) reserve(ctx context.Context, domainName string) (release func(), err error) {
+	release, full, err := db.reserveMany(ctx, []string{domainName})
+	if err != nil {
+		return nil, err
+	}
+	if full[domainName] {
+		release()
+		return nil, ErrStoreFull
+	}
+	return release, nil
+}
+
+/*
+reserveMany makes room for the certs of domains before they are written, evicting as many
+certs as the new domains need under EvictWhenFull. It returns the domains left without room,
+the last new ones listed, which mustn't be written, and the func to call once the rest have
+been.
+*/
+func (db *dbConn) reserveMany(ctx context.Context, domains []string) (release func(), full map[string]bool, err error) {
+	c := db.capacity
+	if c == nil {
+		return func() {}, nil, nil
+	}
+	c.mu.Lock()
+	defer func() {
+		if err != nil {
+			c.mu.Unlock()
+		}
+	}()
+
+	newDomains, n, err := db.countNew(ctx, domains)
+	if err != nil {
+		return nil, nil, err
+	}
+	over := n + len(newDomains) - c.max
+	if over <= 0 {
+		return c.mu.Unlock, nil, nil
+	}
+	if c.policy == EvictWhenFull {
+		evict, err := db.soonestExpiring(ctx, over, domains)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, domain := range evict {
+			if _, err := db.deleteCert(ctx, domain); err != nil {
+				return nil, nil, err
+			}
+			db.logger.Info("evicted a cert to make room", "evicted", domain)
+		}
+		over -= len(evict)
+	}
+	full = make(map[string]bool)
+	for _, domain := range newDomains[max(len(newDomains)-over, 0):] {
+		full[domain] = true
+	}
+	return c.mu.Unlock, full, nil
+}
+
+/*
+countNew returns which of domains aren't stored yet, in order and without the service's own,
+and how many domains are stored. A renewal takes no more room. Both are read from the master,
+a replica could miss the domains just created and let the store grow past the cap.
+*/
+func (db *dbConn) countNew(ctx context.Context, domains []string) ([]string, int, error) {
+	ctx, cancel := db.withTimeout(readMaster(ctx))
+	defer cancel()
+	stored, err := db.store.GetMany(ctx, domains)
+	if err != nil {
+		return nil, 0, err
+	}
+	var newDomains []string
+	seen := make(map[string]bool)
+	for _, domain := range domains {
+		if _, ok := stored[domain]; !ok && !seen[domain] && domain != db.serverDomain {
+			newDomains = append(newDomains, domain)
+		}
+		seen[domain] = true
+	}
+	if len(newDomains) == 0 {
+		return nil, 0, nil
+	}
+	n, err := db.store.Count(ctx)
+	return newDomains, n, err
+}
+
+/*
+soonestExpiring returns the n stored domains whose certs expire first, other than the
+service's own and those in keep, walking every page of the store. There may be fewer.
+*/
+func (db *dbConn) soonestExpiring(ctx context.Context, n int, keep []string) ([]string, error) {
+	skip := map[string]bool{db.serverDomain: true}
+	for _, domain := range keep {
+		skip[domain] = true
+	}
+	var soonest []CertInfo
+	cursor := 0
+	for {
+		var page []CertInfo
+		var err error
+		if cursor, page, err = db.scanPage(ctx, cursor); err != nil {
+			return nil, err
+		}
+		for _, cert := range page {
+			if !skip[cert.Domain] {
+				soonest = append(soonest, cert)
+			}
+		}
+		// only the n soonest so far are kept, so a huge store isn't held in memory
+		sort.Slice(soonest, func(i, j int) bool { return soonest[i].Expires.Before(soonest[j].Expires) })
+		if len(soonest) > n {
+			soonest = soonest[:n]
+		}
+		if cursor == 0 {
+			domains := make([]string, len(soonest))
+			for i, cert := range soonest {
+				domains[i] = cert.Domain
+			}
+			return domains, nil
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMaxDomainsReject checks a new domain over the cap is answered 507, while renewals still go through.
+func TestMaxDomainsReject(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{MaxDomains: 2})
+	create := func(domain string) int {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest("/certcreate/"+domain))
+		return w.Code
+	}
+	if create("a.com") != http.StatusCreated || create("b.com") != http.StatusCreated {
+		t.Fatal("expected the first two domains created")
+	}
+	if code := create("c.com"); code != http.StatusInsufficientStorage {
+		t.Errorf("expected 507 for a third domain, got %d", code)
+	}
+	if code := create("a.com"); code != http.StatusOK {
+		t.Errorf("expected a renewal at the cap to go through, got %d", code)
+	}
+	if _, err := db.CreateCert("c.com"); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("expected ErrStoreFull, got %v", err)
+	}
+	// the service's own cert doesn't count
+	if _, err := db.createCert(context.Background(), db.serverDomain); err != nil {
+		t.Errorf("expected the server cert created at the cap, got %v", err)
+	}
+}
+
+// TestMaxDomainsEvict checks a new domain over the cap evicts the cert expiring soonest.
+func TestMaxDomainsEvict(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{MaxDomains: 3, FullPolicy: EvictWhenFull})
+	now := time.Now()
+	for domain, expires := range map[string]time.Time{"a.com": now.Add(time.Hour), "b.com": now.Add(time.Minute), "c.com": now.Add(time.Minute * 30)} {
+		if _, err := db.storeCert(context.Background(), domain, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.CreateCert("d.com"); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := db.ListCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, evicted := certs["b.com"]; evicted || len(certs) != 3 {
+		t.Errorf("expected b.com evicted for d.com, got %v", certs)
+	}
+}
+
+// TestMaxDomainsConcurrent checks concurrent creates of different domains don't overshoot the cap.
+func TestMaxDomainsConcurrent(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{MaxDomains: 5})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db.CreateCert("site" + strconv.Itoa(i) + ".com")
+		}(i)
+	}
+	wg.Wait()
+	if n, err := db.Count(); n != 5 || err != nil {
+		t.Errorf("expected 5 domains stored, got %d %v", n, err)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), FullPolicy: 7}); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+// TestMaxDomainsBatch checks a batch create only creates the new domains that fit, evicting for them if configured.
+func TestMaxDomainsBatch(t *testing.T) {
+	full := ErrStoreFull.Error()
+	for policy, expected := range map[FullPolicy][]string{
+		RejectWhenFull: {"OK", "OK", full, full},
+		// old.com is evicted for c.com, a.com is being renewed so it is kept, leaving nothing for d.com
+		EvictWhenFull: {"OK", "OK", "OK", full},
+	} {
+		db := newFakeDBWithConfig(newFakeRedis(), Config{MaxDomains: 3, FullPolicy: policy})
+		for _, domain := range []string{"old.com", "a.com"} {
+			if _, err := db.storeCert(context.Background(), domain, time.Now().Add(time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var statuses []string
+		for _, result := range db.createBatch(context.Background(), []string{"a.com", "b.com", "c.com", "d.com"}) {
+			statuses = append(statuses, result.Status)
+		}
+		if !reflect.DeepEqual(statuses, expected) {
+			t.Errorf("policy %d: expected %q, got %q", policy, expected, statuses)
+		}
+		if n, err := db.Count(); n != 3 || err != nil {
+			t.Errorf("policy %d: expected 3 domains stored, got %d %v", policy, n, err)
+		}
+	}
+}
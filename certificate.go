@@ -6,7 +6,7 @@ Start an http server on the localhost port 8080.
 Create and maintain a pooled connection to a redis server.
 Ping the redis server to see if its alive.
 
-Create domain certificates with a 10 minute expiration date.
+Create domain certificates with a configurable expiration date (10 minutes by default).
 Retrieve a domain for validation purposes,
 Provide an http handler to receive and process these 'Create' and 'Retrieve' requests
 
@@ -15,17 +15,29 @@ Provide an http handler to receive and process these 'Create' and 'Retrieve' req
 package CertificateService
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
-
-	"regexp"
-	//imported pagckage, run go get github.com/gomodule/redigo/redis
-	"github.com/gomodule/redigo/redis"
 	"io"
-	"log"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	//imported package, run go get go.opentelemetry.io/otel
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -34,295 +46,1583 @@ all of this packages functions and the redis database connection.
 */
 
 type CertificateService interface {
-	OpenHTTPServer()
-	PingRedis() bool
+	OpenHTTPServer() error
+	OpenGRPCServer(addr string) error
+	Handler() http.Handler
+	PingRedis(ctx context.Context) bool
+	WaitForRedis(ctx context.Context) error
+	GetCert(domain string) (time.Time, bool, error)
+	CreateCert(domain string) (time.Time, error)
+	Renew(domain string) (time.Time, error)
 	GetAll() []string
+	ListCerts() (map[string]time.Time, error)
+	ListCertsPage(ctx context.Context, cursor int, count int) (int, []CertInfo, error)
+	Count() (int, error)
+	ListByTTLBucket() ([]TTLBucket, error)
+	StreamCertificates(ctx context.Context) (<-chan CertInfo, <-chan error)
+	MigrateToKeyLayout() (int, error)
+	Migrate(opts MigrateOptions) (MigrateReport, error)
+	Flush() (int, error)
+	Close() error
 }
 
-//Holds a pointer to the redis database cache
+// healthTimeout bounds the checks of /ready, so a hung redis can't hang it.
+const healthTimeout = time.Second
+
+// defaultServerDomain is the domain of the certificate the service maintains for its own http server.
+const defaultServerDomain = "CERTSERVER.FAN"
+
+//Holds the store of certs, the redis database cache unless another Storage is configured
 type dbConn struct {
-	myPool *redis.Pool
+	store *closableStorage
+	/*
+		lifetime of every certificate created by this service, and how long before expiry the
+		server certificate is renewed. Both can be changed at runtime, so use db.lifetime().
+	*/
+	lifetimeMu       sync.RWMutex
+	ttl, renewBuffer time.Duration
+	// first and longest delay between retries of a failed server certificate renewal
+	retryBase, retryMax time.Duration
+	// the most a renewal is moved by, as a fraction of the renewal interval
+	renewJitter float64
+	// whether a create of a domain with a cert fails rather than renewing it
+	strictCreate bool
+	// the CAs client certs are verified against, nil when they aren't asked for, and when they are
+	clientCAs  *x509.CertPool
+	clientAuth tls.ClientAuthType
+	// optional delay before a newly created cert may be used, 0 for none
+	issueDelay time.Duration
+	// the shortest and longest lifetime a create may ask for
+	minRequestTTL, maxRequestTTL time.Duration
+	// ascending upper bounds of the ListByTTLBucket buckets
+	ttlBuckets []time.Duration
+	// results of create requests made with an idempotency key
+	idempotency *idempotencyStore
+	// the creates of each domain currently running
+	creates *createGroup
+	// whether retrieve responses carry a Cache-Control header
+	cacheControl bool
+	// bearer token required by the admin endpoints, empty disables them
+	adminToken string
+	// the address OpenHTTPServer listens on
+	listenAddr string
+	// the path prefix every http route is served below, empty for none
+	basePath string
+	// what "/" is answered with instead of the usage page, see rootHandler
+	rootPage        string
+	rootRedirect    string
+	disableRootPage bool
+	// longest a single call to the store may take before it is abandoned
+	redisTimeout time.Duration
+	// how long a call to the store may take before it is logged as slow, zero logs none
+	slowRedis time.Duration
+	// the first and longest delay between the pings of WaitForRedis
+	pollInterval, pollMax time.Duration
+	// how long opening a server waits for redis, 0 for not at all
+	startupTimeout time.Duration
+	// canonical domain of the certificate the service maintains for itself, empty if it maintains none
+	serverDomain string
+	// certs asked of the store per page of a listing
+	scanCount int
+	// issuances kept in each domain's history, 0 keeps none
+	historyDepth int
+	// serve the http API over TLS with serverCert, the latest server certificate
+	https      bool
+	serverCert atomic.Pointer[tls.Certificate]
+	// prometheus metrics served at /metrics
+	metrics *metrics
+	// where errors and notable events are logged
+	logger *slog.Logger
+	// where the creates, deletes and revokes that succeed are audited
+	auditLogger *slog.Logger
+	// most domains a batch create may carry
+	maxBatchSize int
+	// the routes of the http API, behind the built in and configured middleware
+	mux http.Handler
+	// traces requests and the store calls made for them, continuing the trace propagator reads
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	// the current time, time.Now unless a test needs to control it
+	now func() time.Time
+	// how often certs expired longer than sweepGrace are deleted, 0 for never
+	sweepInterval, sweepGrace time.Duration
+	// how soon a cert must expire for a sweep to count it as expiring
+	expiringWindow time.Duration
+	// called after every successful create or renewal, nil for none
+	onRenew func(domain string, expires time.Time)
+	// notified of certs about to expire every webhookInterval, nil for none
+	webhook         *expiryWebhook
+	webhookInterval time.Duration
+	// starts the server certificate renewals, sweeper and notifier once
+	background sync.Once
+	// guards the pending renewal and the funcs Close calls to stop the server and background work
+	closeMu    sync.Mutex
+	renewTimer *time.Timer
+	closers    []func()
+	// caps how many domains are stored, nil for no cap
+	capacity *capacity
+	// the key generated for each cert
+	keyType KeyType
+	// finds the client IP of a request, for the rate limits and access log
+	clientIPs *clientIPs
+	// per client IP limits on creating and retrieving certs, nil for none
+	createLimit, retrieveLimit *rateLimiter
+	// the API keys required to create, retrieve and check health, nil where none is required
+	createKeys, retrieveKeys, healthKeys apiKeys
 }
 
-// Instantiate the redis database and return the interface.
+// Instantiate the redis database with the default configuration and return the interface.
 func NewCertificateService() CertificateService {
-	temp := new(dbConn)
-	temp.myPool = newPool()
+	// the zero Config always validates, so the error can be ignored
+	temp, _ := NewCertificateServiceWithConfig(Config{})
 	return temp
 }
 
 /*
-The newPool' function is used to maintain a system of connections to a redis server.
-
-'newPool' uses the imported redigo package to talk to the redis database. Make sure
-this package is imported before using.
-
-Redis must be started before using any functions in this package. If you have docker, redis is simple
-to use:
-
-docker run --name some-redis -d -p 6379:6379 redis redis-server --appendonly yes
-
-This docker command will ensure that redis start on port 6379 (-p 6379:6379) and will persisit data between sessions.
-
+NewCertificateServiceWithConfig instantiates the redis database using the settings in cfg.
+Unset fields fall back to the package defaults. An error is returned if the configuration
+can't be used safely, for example a TTL too short to renew the server certificate in time.
 */
-
-func newPool() *redis.Pool {
-	return &redis.Pool{
-		MaxIdle:   80,
-		MaxActive: 12000, // max number of connections
-		Dial: func() (redis.Conn, error) {
-			// by default, redis starts on port 6379. If you have it started on a diff 192.168.99.100
-			c, err := redis.Dial("tcp", "localhost:6379")
-			if err != nil {
-				fmt.Println(err.Error())
-			}
-			return c, err
-		},
+func NewCertificateServiceWithConfig(cfg Config) (CertificateService, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	temp := new(dbConn)
+	store := cfg.Storage
+	if store == nil {
+		store = newConfiguredStorage(cfg)
+	}
+	temp.store = &closableStorage{Storage: store}
+	temp.ttl = cfg.TTL
+	temp.renewBuffer = cfg.RenewBuffer
+	temp.retryBase = cfg.RenewRetryBase
+	temp.strictCreate = cfg.StrictCreate
+	temp.clientCAs = cfg.ClientCAs
+	temp.clientAuth = cfg.ClientAuth
+	if !cfg.DisableRenewJitter {
+		temp.renewJitter = cfg.RenewJitter
+	}
+	temp.retryMax = cfg.RenewRetryMax
+	temp.issueDelay = cfg.IssueDelay
+	temp.ttlBuckets = cfg.TTLBuckets
+	temp.minRequestTTL = cfg.MinRequestTTL
+	temp.maxRequestTTL = cfg.MaxRequestTTL
+	temp.idempotency = newIdempotencyStore(cfg, temp.store)
+	temp.creates = newCreateGroup()
+	temp.cacheControl = cfg.CacheControl
+	temp.adminToken = cfg.AdminToken
+	temp.listenAddr = cfg.ListenAddr
+	temp.basePath = cfg.BasePath
+	temp.rootPage = cfg.RootPage
+	temp.rootRedirect = cfg.RootRedirect
+	temp.disableRootPage = cfg.DisableRootPage
+	temp.redisTimeout = cfg.RedisTimeout
+	temp.slowRedis = cfg.SlowRedisThreshold
+	temp.pollInterval = cfg.RedisPollInterval
+	temp.pollMax = cfg.RedisPollMax
+	temp.startupTimeout = cfg.StartupTimeout
+	temp.scanCount = cfg.ScanCount
+	if !cfg.DisableServerCert {
+		temp.serverDomain = canonicalDomain(cfg.ServerDomain)
 	}
+	temp.historyDepth = cfg.HistoryDepth
+	temp.https = cfg.HTTPS
+	temp.metrics = newMetrics()
+	temp.logger = cfg.Logger
+	temp.auditLogger = cfg.AuditLogger
+	temp.maxBatchSize = cfg.MaxBatchSize
+	temp.now = time.Now
+	temp.tracer = cfg.TracerProvider.Tracer(tracerName)
+	temp.propagator = cfg.Propagator
+	temp.capacity = newCapacity(cfg)
+	temp.clientIPs = newClientIPs(cfg)
+	temp.createLimit = newRateLimiter(cfg.CreateRateLimit, cfg.CreateRateBurst, temp.clientIPs)
+	temp.retrieveLimit = newRateLimiter(cfg.RetrieveRateLimit, cfg.RetrieveRateBurst, temp.clientIPs)
+	temp.createKeys = newAPIKeys(cfg.APIKeys)
+	if cfg.APIKeyRetrieve {
+		temp.retrieveKeys = temp.createKeys
+	}
+	if cfg.APIKeyHealth {
+		temp.healthKeys = temp.createKeys
+	}
+	temp.sweepInterval = cfg.SweepInterval
+	temp.sweepGrace = cfg.SweepGrace
+	temp.expiringWindow = cfg.ExpiringWindow
+	temp.keyType = cfg.KeyType
+	temp.onRenew = cfg.OnRenew
+	temp.webhook = newExpiryWebhook(cfg)
+	temp.webhookInterval = cfg.ExpiryWebhookInterval
+	temp.mux = chain(temp.routes(), temp.middleware(cfg)...)
+	return temp, nil
 }
 
 //Make sure the http servers certificate has been created and is up to date
 func (db *dbConn) newCertServer() {
+	db.renewCertServer(0)
+}
+
+/*
+renewCertServer creates or renews the server certificate. failures is the number of
+attempts in a row that have already failed; while Redis is unreachable the next attempt
+is retried with an exponential backoff instead of waiting a full renewal interval.
+*/
+func (db *dbConn) renewCertServer(failures int) {
+	if db.store.closed.Load() {
+		return
+	}
 	//this next line creates OR renews a certificate
-	_, err := db.createCert("CERTSERVER.FAN")
+	_, err := db.createCert(context.Background(), db.serverDomain)
+	if err == nil && db.https {
+		// swap the renewed cert in for new TLS connections
+		err = db.loadServerCert()
+	}
 	if err != nil {
-		log.Fatal(err)
+		retry := db.renewBackoff(failures)
+		db.logger.Error("renewing the server certificate failed", "retry_in", retry, "err", err)
+		db.renewAfter(retry, func() { db.renewCertServer(failures + 1) })
+		return
 	}
 	/*
-		Each certificate is created with a 10 minute expiration date. Make sure
-		the server is renewed ever 9 minutes
+		Each certificate is created with an expiration date one lifetime in the future. Make sure
+		the server is renewed before that happens.
 	*/
-	time.AfterFunc(time.Minute*9, db.newCertServer)
+	db.renewAfter(db.renewDelay(), db.newCertServer)
+}
+
+/*
+renewDelay is how long until the server certificate is next renewed: renewInterval, moved
+earlier or later at random by up to the renewal jitter, but never later by more than half the
+renewal buffer.
+*/
+func (db *dbConn) renewDelay() time.Duration {
+	interval := db.renewInterval()
+	_, renewBuffer := db.lifetime()
+	jitter := time.Duration(db.renewJitter * float64(interval) * (2*rand.Float64() - 1))
+	return interval + min(jitter, renewBuffer/2)
+}
+
+// renewInterval is how often the server certificate is renewed: its lifetime less the renewal buffer.
+func (db *dbConn) renewInterval() time.Duration {
+	ttl, renewBuffer := db.lifetime()
+	return ttl - renewBuffer
+}
+
+// lifetime returns the current certificate lifetime and renewal buffer.
+func (db *dbConn) lifetime() (time.Duration, time.Duration) {
+	db.lifetimeMu.RLock()
+	defer db.lifetimeMu.RUnlock()
+	return db.ttl, db.renewBuffer
+}
+
+/*
+setLifetime changes the certificate lifetime and renewal buffer used by future creations and
+renewals. Existing certificates keep their expiration date.
+*/
+func (db *dbConn) setLifetime(ttl time.Duration, renewBuffer time.Duration) error {
+	if err := validateLifetime(ttl, renewBuffer); err != nil {
+		return err
+	}
+	db.lifetimeMu.Lock()
+	defer db.lifetimeMu.Unlock()
+	db.ttl, db.renewBuffer = ttl, renewBuffer
+	return nil
+}
+
+/*
+renewBackoff is the delay before retrying a failed renewal. It starts at the configured
+base delay and doubles with every failure, capped at the configured maximum.
+*/
+func (db *dbConn) renewBackoff(failures int) time.Duration {
+	delay := db.retryBase
+	for i := 0; i < failures && delay < db.retryMax; i++ {
+		delay *= 2
+	}
+	if delay > db.retryMax {
+		delay = db.retryMax
+	}
+	return delay
+}
+
+/*
+withTimeout bounds ctx by the configured redis timeout, so a hung redis can't wedge a
+request forever. The returned cancel func must be called once the operation is done.
+*/
+func (db *dbConn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.redisTimeout)
 }
 
 /*
 OpenHTTPServer provides:
 
-An http server.
+An http server listening on Config.ListenAddr, :8080 by default, over TLS with the server certificate when configured.
+The server certificate, issued for Config.ServerDomain and renewed before it expires, unless disabled.
 An http handler for routing http requests.
+A sweeper deleting long expired certs, when configured.
+A notifier POSTing certs about to expire to a webhook, when configured.
+
+The renewals, sweeper and notifier are shared with OpenGRPCServer and run until Close. It blocks while the server runs and returns the error that stopped it, http.ErrServerClosed
+once Close shuts it down. With Config.StartupTimeout it first waits for redis to answer.
+*/
+func (db *dbConn) OpenHTTPServer() error {
+	if err := db.waitAtStartup(); err != nil {
+		return err
+	}
+	server := &http.Server{Addr: db.listenAddr, Handler: db.Handler()}
+	if !db.onClose(func() { server.Close() }) {
+		return ErrServiceClosed
+	}
+	db.startBackground()
+	var err error
+	if db.https {
+		server.TLSConfig = db.serverTLSConfig()
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	db.logger.Error("the http server stopped", "err", err)
+	return err
+}
 
+/*
+startBackground starts the work the service does while it serves: renewing the server
+certificate unless it is disabled, and sweeping expired certs and notifying the expiry
+webhook when configured. It only starts once, for whichever of the http and gRPC servers is
+opened first, and runs until the service is closed.
 */
-func (db *dbConn) OpenHTTPServer() {
-	db.newCertServer()
-	http.HandleFunc("/", db.httpHandler)
-	log.Fatal(http.ListenAndServe(":8080", nil))
+func (db *dbConn) startBackground() {
+	db.background.Do(func() {
+		if db.serverDomain != "" {
+			db.newCertServer()
+		}
+		if db.sweepInterval > 0 {
+			db.onClose(db.startSweeper(db.sweepInterval, db.sweepGrace))
+		}
+		if db.webhook != nil {
+			db.onClose(db.startNotifier(db.webhookInterval))
+		}
+	})
 }
 
 /*
 createCert serves two purposes:
 1: to create a cert if it doesn't exist
 2: renew a cert if it exists, but has expired
+
+Either way a new self-signed X.509 certificate and private key are generated for the domain,
+valid for the current certificate lifetime from now.
+
+Only one create of a domain runs at a time. Concurrent creates of the same domain, say a
+user's and the server's own renewal, wait for the running one and share its cert, so a
+waiting create fails too if the running one's ctx is cancelled.
 */
-func (db *dbConn) createCert(domainName string) (string, error) {
-	/*
-		Use a pooled connection to redis and close the
-		connection when the function exits.
-	*/
-	conn := db.myPool.Get()
-	defer conn.Close()
+func (db *dbConn) createCert(ctx context.Context, domainName string) (*x509.Certificate, error) {
+	cert, _, err := db.issue(ctx, domainName, 0, nil, nil, createOrRenew)
+	return cert, err
+}
 
-	// set or renew the expiration date/time for the cert
-	expires := time.Now().Add(time.Minute * 10)
+/*
+issue is createCert, issuing a cert valid for ttl rather than the certificate lifetime unless
+ttl is 0, naming sans alongside the domain, storing meta with it, only if mode allows it, and
+also reporting whether the domain had no cert before, rather than being renewed. The sans must
+have been checked by checkSANs, and meta by checkMetadata.
+*/
+func (db *dbConn) issue(ctx context.Context, domainName string, ttl time.Duration, sans []string, meta map[string]string, mode issueMode) (cert *x509.Certificate, created bool, err error) {
+	ctx, span := db.startSpan(ctx, "createCert", attribute.String("domain", domainName), attribute.String("operation", "create"))
+	defer func() { endSpan(span, err) }()
+	return db.creates.do(domainName, ttl, sans, meta, mode, func() (*x509.Certificate, bool, error) {
+		if err := db.checkMode(ctx, domainName, mode); err != nil {
+			return nil, false, err
+		}
+		// set or renew the expiration date/time for the cert
+		if ttl == 0 {
+			ttl, _ = db.lifetime()
+		}
+		return db.issueCert(ctx, domainName, sans, meta, db.now().Add(ttl))
+	})
+}
 
-	/*
-		connect and store the cert and the expiration date
-		the expiration date time string are rather large. We're encoding it here as byte slice
-		to help protect against parsing errors or modifying the time in unwanted ways.
-	*/
-	resp, err := redis.String(conn.Do("HMSET", "Domain", domainName, encode(expires)))
+/*
+storeCert generates a certificate for domainName that expires at notAfter and stores it,
+replacing any previous certificate for the domain, whose issuance stays in the history.
+*/
+func (db *dbConn) storeCert(ctx context.Context, domainName string, notAfter time.Time) (*x509.Certificate, error) {
+	cert, _, err := db.issueCert(ctx, domainName, nil, nil, notAfter)
+	return cert, err
+}
+
+/*
+issueCert is storeCert, naming sans alongside the domain and storing meta with it, and also
+reporting whether the domain had no cert before. A new domain must first find room under
+Config.MaxDomains.
+*/
+func (db *dbConn) issueCert(ctx context.Context, domainName string, sans []string, meta map[string]string, notAfter time.Time) (*x509.Certificate, bool, error) {
+	issuedAt := db.now()
+	cert, certPEM, keyPEM, err := generateCert(domainName, sans, issuedAt, notAfter, db.keyType)
 	if err != nil {
-		log.Fatal(err)
+		return nil, false, err
 	}
+	release, err := db.reserve(ctx, domainName)
+	if err != nil {
+		return nil, false, err
+	}
+	defer release()
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	created, err := db.store.Set(ctx, domainName, Record{Expires: cert.NotAfter, IssuedAt: issuedAt, CertPEM: certPEM, KeyPEM: keyPEM, Metadata: meta})
+	db.observeRedis("set", start, err, "domain", domainName)
+	if err != nil {
+		db.logger.Error("storing a cert in redis failed", "domain", domainName, "err", err)
+		return nil, false, err
+	}
+	db.metrics.created.Inc()
+	db.audit(ctx, auditAction(created), domainName, "serial", serialNumber(cert))
+	db.recordIssuance(ctx, domainName, cert)
+	db.renewed(domainName, cert.NotAfter)
+	return cert, created, nil
+}
 
-	return resp, err
-
+/*
+renewed calls the OnRenew hook, if one is configured, for a cert just created or renewed. The
+hook runs in a goroutine of its own so a slow hook never holds up a request or the renewal
+of the server certificate.
+*/
+func (db *dbConn) renewed(domainName string, expires time.Time) {
+	if db.onRenew != nil {
+		go db.onRenew(domainName, expires)
+	}
 }
 
 /*
-getCert queries the redis cache for a domain name and expiration date. The user
-will send a domain name and retrieve an expiration time if the domain exists, otherwise,
-and error is thrown (usually something like "REDIGO: NIL RETURNED") or a connection error.
+getCert queries the redis cache for a domain name and returns its certificate. The user
+will send a domain name and retrieve the certificate, whose NotAfter is the expiration time,
+if the domain exists, otherwise,
+a nil certificate and an error are returned: ErrDomainNotFound, checked with errors.Is, or a
+connection error.
 
 A good use for this is, say a client web browser trying to validate a domain certificate
 to establish a trusted connection.
-*/
 
-func (db *dbConn) getCert(domainName string) (time.Time, error) {
+In the per-domain key layout redis evicts a cert when it expires, so the error is the same
+for an expired cert as for a domain that never existed.
+*/
 
-	/*
-		Use a pooled connection to redis and close the
-		connection when the function exits.
-	*/
-	conn := db.myPool.Get()
-	defer conn.Close()
+func (db *dbConn) getCert(ctx context.Context, domainName string) (*x509.Certificate, error) {
+	cert, _, err := db.getRecord(ctx, domainName)
+	return cert, err
+}
 
-	//retrieve the expiration and any errors
-	expires, err := redis.Bytes(conn.Do("HGET", "Domain", domainName))
+// getRecord is getCert, also returning the metadata stored with the cert.
+func (db *dbConn) getRecord(ctx context.Context, domainName string) (cert *x509.Certificate, meta map[string]string, err error) {
+	ctx, span := db.startSpan(ctx, "getCert", attribute.String("domain", domainName), attribute.String("operation", "retrieve"))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	//retrieve the certificate and any errors
+	start := time.Now()
+	rec, err := db.store.Get(ctx, domainName)
+	db.observeRedis("get", start, err, "domain", domainName)
+	span.SetAttributes(cacheResult(err))
+	if err != nil && !errors.Is(err, ErrDomainNotFound) {
+		db.logger.Error("reading a cert from redis failed", "domain", domainName, "err", err)
+	}
 	if err != nil {
-		return time.Now(), err
+		return nil, nil, err
 	}
+	cert, err = parseCertPEM(rec.CertPEM)
+	return cert, rec.Metadata, err
+}
 
-	/*
-			Return the expiration data and any errors.
-		    decode translates the expiration, stores as a Byte slice, to a string
-	*/
-	return decode(expires), err
+/*
+getCerts looks up the certificate of every domain in domains with a single pipelined round
+trip. Domains without a cert are left out of the map rather than failing the batch.
+*/
+func (db *dbConn) getCerts(ctx context.Context, domains []string) (certs map[string]*x509.Certificate, err error) {
+	ctx, span := db.startSpan(ctx, "getCerts", attribute.Int("domains", len(domains)), attribute.String("operation", "retrieve"))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	recs, err := db.store.GetMany(ctx, domains)
+	db.observeRedis("get", start, err, "domains", len(domains))
+	if err != nil {
+		db.logger.Error("reading certs from redis failed", "domains", len(domains), "err", err)
+		return nil, err
+	}
+	certs = make(map[string]*x509.Certificate, len(recs))
+	for domain, rec := range recs {
+		if certs[domain], err = parseCertPEM(rec.CertPEM); err != nil {
+			return nil, err
+		}
+	}
+	return certs, nil
 }
 
 /*
-'httpHandler takes routes a request through a tree of possible options
-should be able to handle all scenarios and edge cases.........
+'httpHandler routes a request to the handler registered for its path on the service's ServeMux.
+Routes are matched case insensitively, so the path is lowercased first. Domains are
+canonicalized to lower case anyway, so nothing the client sent is lost. Browser requests
+are checked against Config.CORSOrigins before they are routed.
 */
 
 func (db *dbConn) httpHandler(w http.ResponseWriter, r *http.Request) {
+	db.mux.ServeHTTP(w, foldPath(r))
+}
+
+/*
+Handler returns the http API as OpenHTTPServer serves it, every route behind the built in and
+configured middleware, to mount in a server of your own or drive from tests with httptest
+without binding a port. Serving it doesn't start the server certificate renewals, sweeper or
+notifier; OpenHTTPServer and OpenGRPCServer do.
+*/
+func (db *dbConn) Handler() http.Handler {
+	return http.HandlerFunc(db.httpHandler)
+}
 
-	// force the request URI to uppercase for easy comparison tests
-	temp := strings.ToUpper(r.RequestURI)
+/*
+routes registers every route of the http API, below Config.BasePath if one is set. /cert/ and
+/certcreate/ match any path below them, the rest of the path being the domain, while /cert
+and /certcreate only match themselves. Anything unregistered below the base path falls
+through to "/", which explains the API, and anything outside it is 404 Not Found.
+*/
+func (db *dbConn) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	/*
+		handle registers fn for pattern below the base path, timing every request under the
+		route name and tracing it in a span continuing the trace the request's headers carry,
+		if any
+	*/
+	handle := func(pattern string, route string, fn http.HandlerFunc) {
+		pattern = db.basePath + pattern
+		mux.Handle(pattern, chain(fn, db.metrics.timeRoute(route), db.traceRoute(pattern, route)))
+	}
+	// requests are rate limited before their keys are checked, so keys can't be guessed at speed
+	create := func(fn http.HandlerFunc) http.HandlerFunc {
+		return allow(db.createLimit.limit(db.createKeys.require(db.requireClientCert(fn))), http.MethodPost)
+	}
+	retrieve := func(fn http.HandlerFunc) http.HandlerFunc {
+		return allow(db.retrieveLimit.limit(db.retrieveKeys.require(fn)), http.MethodGet, http.MethodHead)
+	}
+	handle("/certcreate/", "create", create(db.domainHandler(db.basePath+"/certcreate/", "CREATE")))
+	handle("/certrenew/", "renew", create(db.domainHandler(db.basePath+"/certrenew/", "RENEW")))
+	handle("/cert/", "retrieve", retrieve(db.domainHandler(db.basePath+"/cert/", "RETRIEVE")))
+	handle("/certcreate", "create_batch", create(db.batchCreateHandler))
+	handle("/cert", "retrieve_batch", retrieve(db.batchRetrieveHandler))
+	handle("/certs", "list", allow(db.retrieveKeys.require(db.listHandler), http.MethodGet, http.MethodHead))
+	handle("/count", "count", allow(db.retrieveKeys.require(db.countHandler), http.MethodGet, http.MethodHead))
+	handle("/export", "export", allow(db.createKeys.require(db.exportHandler), http.MethodGet, http.MethodHead))
+	handle("/import", "import", create(db.importHandler))
+	handle("/revoke/", "revoke", create(db.revokeHandler))
+	handle("/isrevoked/", "isrevoked", retrieve(db.isRevokedHandler))
+	handle("/certhistory/", "history", retrieve(db.historyHandler))
+	handle("/status/", "status", retrieve(db.statusHandler))
+	handle("/validate/", "validate", allow(db.validateHandler, http.MethodGet, http.MethodHead))
+	handle("/healthz", "healthz", allow(db.healthKeys.require(db.healthHandler), http.MethodGet, http.MethodHead))
+	handle("/ready", "ready", allow(db.healthKeys.require(db.readyHandler), http.MethodGet, http.MethodHead))
+	handle("/version", "version", allow(versionHandler, http.MethodGet, http.MethodHead))
+	handle("/metrics", "metrics", allow(db.metrics.handler().ServeHTTP, http.MethodGet, http.MethodHead))
+	handle("/admin/lifetime", "admin_lifetime", db.requireClientCert(db.lifetimeHandler))
+	handle("/flush", "flush", allow(db.requireClientCert(db.flushHandler), http.MethodPost))
+	handle("/", "other", db.rootHandler)
+	return mux
+}
 
-	// final step after results of the decision tree below
-	finalStep := func(full string, prefix string, getorset string) {
-		//trim the /CERT/ OR /CERTCREATE/ prefix from the decision tree below
-		DomainName := strings.TrimPrefix(full, prefix)
+/*
+domainHandler creates, renews or retrieves the domain in the rest of the path after prefix,
+URL-decoded, and writes the result. A badly encoded domain, or one longer than DNS allows,
+is rejected with 400 Bad Request. A HEAD retrieve is answered by existsHandler instead, and a
+retrieve or create with ?format=json by retrieveJSONHandler or createJSONHandler, which answer
+errors as JSON too. A create of a new domain is 201 Created, with a Location header to retrieve it from, and a
+renewal of an existing one 200 OK. A renew is a create that fails for a domain without a
+cert, 404 Not Found, and under Config.StrictCreate a create fails for a domain with one, 409
+Conflict. A create or renew may ask for a lifetime of its own, see requestTTL,
+for names the cert covers besides the domain, see requestSANs, and attach metadata to the
+cert, see requestMetadata. Every retrieve that finds a
+cert, HEAD and JSON included, carries its ETag, see certETag, and is answered 304 Not Modified
+when If-None-Match already lists it.
+*/
+func (db *dbConn) domainHandler(prefix string, getorset string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain, ok := pathDomain(w, r, prefix, jsonMode(r))
+		if !ok {
+			return
+		}
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("domain", domain), attribute.String("operation", strings.ToLower(getorset)))
+		if getorset == "RETRIEVE" && r.Method == http.MethodHead {
+			db.existsHandler(w, r, domain)
+			return
+		}
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q, expected json", format), http.StatusBadRequest)
+			return
+		}
+		if getorset == "RETRIEVE" && jsonMode(r) {
+			db.retrieveJSONHandler(w, r, domain)
+			return
+		}
+		var ttl time.Duration
+		var sans []string
+		var meta map[string]string
+		if getorset != "RETRIEVE" {
+			if ttl, ok = db.requestTTL(w, r); !ok {
+				return
+			}
+			body, ok := readCreateBody(w, r)
+			if !ok {
+				return
+			}
+			if sans, ok = db.requestSANs(w, r, domain, body.SANs); !ok {
+				return
+			}
+			if meta, ok = requestMetadata(w, r, body.Metadata); !ok {
+				return
+			}
+			if jsonMode(r) {
+				db.createJSONHandler(w, r, domain, ttl, sans, meta, db.modeOf(getorset))
+				return
+			}
+		}
+		// the redis calls are abandoned if the client goes away
+		resp, status, trustedUntil, etag := db.redisResponse(r.Context(), domain, getorset, r.Header.Get("Idempotency-Key"), ttl, sans, meta)
+		if getorset == "RETRIEVE" && db.cacheControl {
+			db.setCacheControl(w, trustedUntil)
+		}
+		if notModified(w, r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if status == http.StatusCreated {
+			// where the new cert can be retrieved, to check it straight away
+			w.Header().Set("Location", db.basePath+"/cert/"+url.PathEscape(canonicalDomain(domain)))
+		}
+		w.WriteHeader(status)
 		// writes the final response string after a request to create or retrieve a domain
-		io.WriteString(w, "<h1>"+db.redisResponse(DomainName, getorset)+"</h1>")
+		io.WriteString(w, "<h1>"+resp+"</h1>")
 	}
+}
 
-	//decision tree routing
-	if strings.Contains(temp, "/CERTCREATE/") {
-		finalStep(temp, "/CERTCREATE/", "CREATE")
-	} else if strings.Contains(temp, "/CERT/") {
-		finalStep(temp, "/CERT/", "RETRIEVE")
-	} else {
-		io.WriteString(w, "<h1> server is live, Send a valid certification request  to localhost:8080/cert/{domain} or localhost:8080/certcreate/{domain} </h1>")
+/*
+existsHandler answers a HEAD retrieve of domainName with its status alone, for clients that
+only need to know whether it has a cert they can use: 200 when it does, 404 when there is
+none and 400 for an invalid domain. A cert that has expired or been revoked is still 200,
+marked with an X-Cert-Expired or X-Cert-Revoked header of true. Whenever a cert is found
+its expiry is sent in X-Cert-Expires, in RFC3339, along with the ETag of a plain GET.
+*/
+func (db *dbConn) existsHandler(w http.ResponseWriter, r *http.Request, domainName string) {
+	domainName, ok := db.checkDomain(domainName)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
+	// the lookup is the same as a GET's, only the body isn't built
+	cert, _, _, revoked, err := db.lookup(r.Context(), domainName)
+	var trustedUntil time.Time
+	code := http.StatusOK
+	switch {
+	case errors.Is(err, ErrDomainNotFound):
+		db.metrics.retrieves.WithLabelValues("not_found").Inc()
+		code = http.StatusNotFound
+	case err != nil:
+		db.metrics.retrieves.WithLabelValues("error").Inc()
+		code = errorStatus(err)
+	default:
+		w.Header().Set("X-Cert-Expires", cert.NotAfter.UTC().Format(time.RFC3339))
+		status := db.certStatus(cert, revoked)
+		db.metrics.retrieves.WithLabelValues(status).Inc()
+		switch status {
+		case certExpired:
+			w.Header().Set("X-Cert-Expired", "true")
+		case certRevoked:
+			w.Header().Set("X-Cert-Revoked", "true")
+		default:
+			trustedUntil = cert.NotAfter
+		}
+		// the ETag of the GET this HEAD stands in for
+		if notModified(w, r, certETag("text", domainName, cert, status)) {
+			code = http.StatusNotModified
+		}
+	}
+	if db.cacheControl {
+		db.setCacheControl(w, trustedUntil)
+	}
+	w.WriteHeader(code)
 }
 
+// The statuses of a retrieve, as reported by ?format=json and counted by the retrieve metric.
+const (
+	certTrusted  = "trusted"
+	certExpired  = "expired"
+	certRevoked  = "revoked"
+	certNotFound = "not_found"
+	certInvalid  = "invalid"
+	certError    = "error"
+)
+
 /*
-Similar to and working in conjunction with the decision tree from httpHandler above.
-this function sends and receives responses from the redis cache.
+certStatus is whether cert can be trusted: expired once it is past its expiry, whether or not
+it was also revoked, else revoked if revoked, else trusted.
 */
-func (db *dbConn) redisResponse(domainName string, createOrRetrieve string) string {
+func (db *dbConn) certStatus(cert *x509.Certificate, revoked bool) string {
+	switch {
+	case cert.NotAfter.Before(db.now()):
+		return certExpired
+	case revoked:
+		return certRevoked
+	default:
+		return certTrusted
+	}
+}
+
+// statusCodes are the ErrorCodes of the statuses of a cert that was found but can't be trusted.
+var statusCodes = map[string]ErrorCode{certExpired: CodeExpired, certRevoked: CodeRevoked}
+
+// retrieveResult is the response to a retrieve with ?format=json.
+type retrieveResult struct {
+	Domain string `json:"domain"`
+	Status string `json:"status"`
+	// CoveredBy is the wildcard the cert was found under, if it wasn't the domain's own.
+	CoveredBy string `json:"covered_by,omitempty"`
 	/*
-		Valid domains include any alphanumeric combination of 1-62 character, followed
-		by a '.' and finally by another alphanumeric combination of 2-62 characters.
-		Examples:
-		Valid: Fanatics.com
-		Invalid:  Fanatics (no extension)
-		Invalid Fanatics.co.uk (too many extensions).
+		ExpiresAt, IssuedAt and Serial describe the cert, and ExpiresIn is the seconds until it
+		expires, negative once it has. Set whenever one was found.
 	*/
-	validate, _ := regexp.Compile("^[a-zA-Z0-9|-]{0,61}[a-zA-Z0-9]\\.[a-zA-Z]{2,62}$")
-	if !validate.MatchString(domainName) {
-		return ("Invalid domain name: " + domainName)
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn *int64     `json:"expires_in_seconds,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	Serial    string     `json:"serial,omitempty"`
+	// KeyAlgorithm, "ECDSA" or "RSA", and KeySize, in bits, describe the cert's key.
+	KeyAlgorithm string `json:"key_algorithm,omitempty"`
+	KeySize      int    `json:"key_size,omitempty"`
+	// SANs are every name the cert covers, the domain or wildcard it was issued for first.
+	SANs []string `json:"sans,omitempty"`
+	// Metadata is what the create that issued the cert attached to it, if anything.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Code is the ErrorCode of every status but trusted, so clients can branch on it.
+	Code ErrorCode `json:"code,omitempty"`
+	// Error is why the lookup failed, for the invalid and error statuses.
+	Error string `json:"error,omitempty"`
+	// Reasons lists every reason the domain is invalid, for the invalid status.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+/*
+retrieveJSONHandler answers a retrieve with ?format=json, whose status field and http status
+tell clients outright whether to trust the cert, rather than leaving them to read it out of the
+text of a plain retrieve, which is always 200:
+
+	trusted    200  the cert is valid and hasn't been revoked
+	expired    200  the cert exists but has expired, don't trust it
+	revoked    200  the cert exists and hasn't expired but was revoked, don't trust it
+	not_found  404  there is no cert for the domain, nor a wildcard covering it
+	invalid    400  the domain isn't one the service accepts
+	error      500  the store failed, or 503 when it was too busy to answer
+
+An expired cert that was also revoked is reported as expired. Every status but trusted carries
+an ErrorCode as well: CodeExpired, CodeRevoked, CodeNotFound, CodeInvalidDomain, or errorCode's
+for the error status.
+*/
+func (db *dbConn) retrieveJSONHandler(w http.ResponseWriter, r *http.Request, domainName string) {
+	domainName, ok := db.checkDomain(domainName)
+	result := retrieveResult{Domain: domainName}
+	var trustedUntil time.Time
+	var etag string
+	code := http.StatusOK
+	if !ok {
+		result.Status, result.Error, code = certInvalid, "invalid domain name: "+domainName, http.StatusBadRequest
+		result.Code, result.Reasons = CodeInvalidDomain, invalidReasons(strings.TrimPrefix(domainName, wildcardPrefix))
+	} else if cert, meta, wildcard, revoked, err := db.lookup(r.Context(), domainName); errors.Is(err, ErrDomainNotFound) {
+		result.Status, result.Code, code = certNotFound, CodeNotFound, http.StatusNotFound
+	} else if err != nil {
+		result.Status, result.Error, code = certError, err.Error(), errorStatus(err)
+		result.Code = errorCode(err)
+	} else {
+		result.Status, result.CoveredBy = db.certStatus(cert, revoked), wildcard
+		result.Code = statusCodes[result.Status]
+		expiresIn := int64(cert.NotAfter.Sub(db.now()) / time.Second)
+		result.ExpiresAt, result.ExpiresIn = &cert.NotAfter, &expiresIn
+		result.IssuedAt, result.Serial = &cert.NotBefore, serialNumber(cert)
+		result.KeyAlgorithm, result.KeySize = keyInfo(cert)
+		result.SANs, result.Metadata = cert.DNSNames, meta
+		if result.Status == certTrusted {
+			trustedUntil = cert.NotAfter
+		}
+		etag = certETag("json", domainName, cert, result.Status)
+	}
+	if ok {
+		db.metrics.retrieves.WithLabelValues(result.Status).Inc()
+	}
+	if db.cacheControl {
+		db.setCacheControl(w, trustedUntil)
+	}
+	if notModified(w, r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(result)
+}
+
+/*
+pathDomain returns the URL-decoded domain in the rest of r's path after prefix. A badly
+encoded domain, or one longer than DNS allows, is answered 400 Bad Request, as JSON when
+asJSON, and ok is false.
+*/
+func pathDomain(w http.ResponseWriter, r *http.Request, prefix string, asJSON bool) (domain string, ok bool) {
+	domain, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+	if err != nil {
+		httpError(w, asJSON, http.StatusBadRequest, CodeInvalidRequest, "invalid domain encoding")
+		return "", false
+	}
+	// a name longer than DNS allows is turned away before it reaches the validator or redis
+	if len(domain) > maxDomainLength {
+		httpError(w, asJSON, http.StatusBadRequest, CodeInvalidDomain, "domain name too long")
+		return "", false
+	}
+	return domain, true
+}
+
+/*
+requestTTL returns the lifetime a create asks for with its ttl query parameter, such as
+?ttl=30m, or 0 for the certificate lifetime if it has none. A ttl that can't be parsed, or
+outside Config.MinRequestTTL to MaxRequestTTL, is answered 400 Bad Request and ok is false.
+*/
+func (db *dbConn) requestTTL(w http.ResponseWriter, r *http.Request) (ttl time.Duration, ok bool) {
+	param := r.URL.Query().Get("ttl")
+	if param == "" {
+		return 0, true
+	}
+	ttl, err := time.ParseDuration(param)
+	if err != nil {
+		httpError(w, jsonMode(r), http.StatusBadRequest, CodeInvalidRequest, "invalid ttl: "+err.Error())
+		return 0, false
+	}
+	if ttl < db.minRequestTTL || ttl > db.maxRequestTTL {
+		httpError(w, jsonMode(r), http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("ttl %v is out of range, expected %v to %v", ttl, db.minRequestTTL, db.maxRequestTTL))
+		return 0, false
+	}
+	return ttl, true
+}
+
+/*
+allow wraps fn so it only serves requests using one of methods. Others get 405 Method Not
+Allowed, with an Allow header listing methods.
+*/
+func allow(fn http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				fn(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// foldPath returns r with its path lowercased, so routes match regardless of case.
+func foldPath(r *http.Request) *http.Request {
+	path, rawPath := strings.ToLower(r.URL.Path), strings.ToLower(r.URL.RawPath)
+	if path == r.URL.Path && rawPath == r.URL.RawPath {
+		return r
+	}
+	folded := *r.URL
+	folded.Path, folded.RawPath = path, rawPath
+	r = r.Clone(r.Context())
+	r.URL = &folded
+	return r
+}
+
+// maxPageSize is the most certs asked of the store for a page of ListCertsPage.
+const maxPageSize = 1000
+
+/*
+listHandler writes every stored domain and its expiration date as a JSON object. Given a cursor
+or count query parameter, /certs?cursor=0&count=100, it writes a single listPage instead, for
+clients paging through a large store. Either way only the certs with the metadata of
+metadataFilter are listed, /certs?meta.owner=team-a, when it is given.
+*/
+func (db *dbConn) listHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("cursor") || query.Has("count") {
+		db.listPageHandler(w, r)
+		return
+	}
+	certs, err := db.listMatching(r.Context(), metadataFilter(r))
+	if err != nil {
+		writeJSONError(w, errorStatus(err), apiError{Code: errorCode(err), Error: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certs)
+}
+
+/*
+listPage is a page of /certs: the certs read and the cursor to ask for the next page with, 0
+once there are no more.
+*/
+type listPage struct {
+	Cursor int         `json:"cursor"`
+	Certs  []listEntry `json:"certs"`
+}
+
+/*
+listEntry is a cert of a listPage, IssuedAt omitted if it was stored before that was kept and
+Metadata if the cert has none.
+*/
+type listEntry struct {
+	Domain    string            `json:"domain"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	IssuedAt  *time.Time        `json:"issued_at,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+/*
+listPageHandler writes the page of certs from the cursor query parameter, 0 if it is absent, of
+about count certs, Config.ScanCount if it is absent. A cursor or count that isn't a whole
+number, or is negative, is answered 400 Bad Request.
+*/
+func (db *dbConn) listPageHandler(w http.ResponseWriter, r *http.Request) {
+	var params [2]int
+	for i, name := range []string{"cursor", "count"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidRequest, Error: fmt.Sprintf("invalid %s %q, expected a whole number", name, value)})
+			return
+		}
+		params[i] = n
+	}
+	cursor, certs, err := db.ListCertsPage(r.Context(), params[0], params[1])
+	if err != nil {
+		writeJSONError(w, errorStatus(err), apiError{Code: errorCode(err), Error: err.Error()})
+		return
+	}
+	// a filtered page may hold fewer certs than were read, or none, before the walk is complete
+	filter := metadataFilter(r)
+	page := listPage{Cursor: cursor, Certs: make([]listEntry, 0, len(certs))}
+	for i, cert := range certs {
+		if !matchesMetadata(cert.Metadata, filter) {
+			continue
+		}
+		entry := listEntry{Domain: cert.Domain, ExpiresAt: cert.Expires, Metadata: cert.Metadata}
+		if !cert.IssuedAt.IsZero() {
+			entry.IssuedAt = &certs[i].IssuedAt
+		}
+		page.Certs = append(page.Certs, entry)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// countHandler writes the number of stored domains as JSON, {"count":42}.
+func (db *dbConn) countHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := db.Count()
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
+
+/*
+errorStatus is the http status a failed call to the store is answered with: 503 Service
+Unavailable when it failed because the backend is busy, so the client knows to retry later,
+507 Insufficient Storage when a new domain didn't fit, 404 Not Found for a renew of a domain
+without a cert, 409 Conflict for a strict create of one with a cert, and 500 Internal Server
+Error for anything else.
+*/
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrDomainNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDomainExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrBackendBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrStoreFull):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+/*
+healthHandler is a liveness check, answering 200 {"status":"ok"} for as long as the process
+serves http at all. It touches nothing, so a redis outage can't get a live instance restarted;
+/ready is what reports whether it can serve certs.
+*/
+func (db *dbConn) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+/*
+readyHandler is a readiness check, so a load balancer only routes to an instance that can
+serve certs: redis must answer and, unless the server cert is disabled, the service's own cert
+must be stored and unexpired. It answers 200 {"status":"ready"}, or 503 {"status":"unready"}
+with the reason, within healthTimeout however long redis takes.
+*/
+func (db *dbConn) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+	w.Header().Set("Content-Type", "application/json")
+	if reason := db.unready(ctx); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unready", "reason": reason})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
+// unready is why the service can't serve certs, empty if it can.
+func (db *dbConn) unready(ctx context.Context) string {
+	if err := db.ping(ctx); errors.Is(err, ErrReplicaUnreachable) {
+		return "the redis read replica can't be reached"
+	} else if err != nil {
+		return "redis can't be reached"
+	}
+	if db.serverDomain == "" {
+		return ""
+	}
+	cert, err := db.getCert(ctx, db.serverDomain)
+	switch {
+	case errors.Is(err, ErrDomainNotFound):
+		return "the server certificate hasn't been issued"
+	case err != nil:
+		return "the server certificate can't be read"
+	case !cert.NotAfter.After(db.now()):
+		return "the server certificate has expired"
+	}
+	return ""
+}
+
+/*
+setCacheControl lets clients cache a validation result until the cert expires. Anything other
+than a trusted cert, signalled by a zero trustedUntil, must not be cached at all.
+*/
+func (db *dbConn) setCacheControl(w http.ResponseWriter, trustedUntil time.Time) {
+	maxAge := int(trustedUntil.Sub(db.now()) / time.Second)
+	if maxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(maxAge))
+}
+
+/*
+Similar to and working in conjunction with the routes registered by httpHandler above.
+this function sends and receives responses from the redis cache, with the http status to
+send them with. When a retrieved cert is trusted, its expiration date is returned alongside
+the response, and whenever a cert is retrieved its ETag.
+*/
+func (db *dbConn) redisResponse(ctx context.Context, domainName string, createOrRetrieve string, idempotencyKey string, ttl time.Duration, sans []string, meta map[string]string) (string, int, time.Time, string) {
+	domainName, ok := db.checkDomain(domainName)
+	if !ok && isIPAddress(domainName) {
+		return errIPAddress + ": " + domainName, http.StatusOK, time.Time{}, ""
+	} else if !ok {
+		return ("Invalid domain name: " + domainName), http.StatusOK, time.Time{}, ""
 	}
 
 	if createOrRetrieve == "RETRIEVE" {
-		return db.retrieve(domainName)
-	} else { // CREATE is selected, create the domain
-		return db.create(domainName)
+		return db.retrieve(ctx, domainName)
+	} else { // CREATE or RENEW is selected, create or renew the domain
+		resp, status := db.create(ctx, domainName, idempotencyKey, ttl, sans, meta, db.modeOf(createOrRetrieve))
+		return resp, status, time.Time{}, ""
 	}
 
 }
 
 /*
-'retrieve' is part of the redisResponse decision tree above
+checkDomain returns the canonical form of domainName, which every lookup and write uses so
+case variants find the same cert, and whether the service accepts it. A wildcard cert,
+*.example.com, is accepted when the domain it covers is. Rejected domains are counted.
+*/
+func (db *dbConn) checkDomain(domainName string) (string, bool) {
+	domainName = canonicalDomain(domainName)
+	if !IsValidDomain(strings.TrimPrefix(domainName, wildcardPrefix)) {
+		db.metrics.rejected.Inc()
+		return domainName, false
+	}
+	return domainName, true
+}
+
+/*
+'retrieve' is part of the redisResponse decision tree above. The expiration date is only
+returned for a trusted cert. Like a failed create, a failed retrieve is 200 OK, unless the
+backend was too busy to answer, which is 503 Service Unavailable.
+*/
+func (db *dbConn) retrieve(ctx context.Context, domainName string) (string, int, time.Time, string) {
+	cert, _, wildcard, revoked, err := db.lookup(ctx, domainName)
+	coveredBy := ""
+	if wildcard != "" {
+		coveredBy = " covered by " + wildcard
+	}
+	resp, trustedUntil := db.retrieveResponse(domainName, coveredBy, cert, revoked, err)
+	if errors.Is(err, ErrBackendBusy) {
+		return resp, http.StatusServiceUnavailable, trustedUntil, ""
+	}
+	var etag string
+	if err == nil {
+		etag = certETag("text", domainName, cert, db.certStatus(cert, revoked))
+	}
+	return resp, http.StatusOK, trustedUntil, etag
+}
+
+/*
+lookup finds the cert a retrieve of domainName is answered with, the wildcard it was found
+under if any, and whether it has been revoked. The lookup order is:
+1: an exact match for the domain, sub.example.com
+2: a wildcard cert for its parent, *.example.com
+An exact match always wins, even if it has expired and the wildcard hasn't. The metadata is
+that of the cert found.
+*/
+func (db *dbConn) lookup(ctx context.Context, domainName string) (cert *x509.Certificate, meta map[string]string, wildcard string, revoked bool, err error) {
+	cert, meta, err = db.getRecord(ctx, domainName)
+	if errors.Is(err, ErrDomainNotFound) {
+		if parent, ok := wildcardFor(domainName); ok {
+			if cert, meta, err = db.getRecord(ctx, parent); err == nil {
+				wildcard = parent
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, "", false, err
+	}
+	serials, err := db.revokedSerials(ctx, []*x509.Certificate{cert})
+	if err != nil {
+		return nil, nil, "", false, err
+	}
+	return cert, meta, wildcard, serials[serialNumber(cert)], nil
+}
+
+/*
+retrieveResponse is the response to retrieving domainName: its cert, covered by the wildcard
+coveredBy if that is set, unless the lookup failed with err. A trusted cert reports how long
+it remains valid and when it expires, so clients can renew ahead of time, and when it was
+issued, and an expired one how long ago it expired. An unexpired cert that has been revoked isn't trusted either.
+Either way the cert's serial number is included, so a client can tell whether it is the cert
+it was handed.
 */
-func (db *dbConn) retrieve(domainName string) string {
-	//attempt to retrieve the domainName query from the redis cache
-	expire, err := db.getCert(domainName)
+func (db *dbConn) retrieveResponse(domainName string, coveredBy string, cert *x509.Certificate, revoked bool, err error) (string, time.Time) {
 	if err != nil {
 		//domain doesn't exist in redis cach
-		if strings.ToUpper(err.Error()) == "REDIGO: NIL RETURNED" {
-			return "This domain doesn't exist: " + domainName + ". Submit a cert request to localhost:8080/certcreate/{domain}"
+		if errors.Is(err, ErrDomainNotFound) {
+			db.metrics.retrieves.WithLabelValues("not_found").Inc()
+			return "This domain doesn't exist: " + domainName + ". Submit a cert request to " + db.basePath + "/certcreate/{domain}", time.Time{}
 		} else {
-			return err.Error()
+			db.metrics.retrieves.WithLabelValues("error").Inc()
+			return err.Error(), time.Time{}
 		}
-	} else if expire.Before(time.Now()) {
+	} else if remaining := cert.NotAfter.Sub(db.now()); remaining < 0 {
 		//domain exists but has expired
-		return "foo{" + domainName + "}" + " expired, not trusted"
+		db.metrics.retrieves.WithLabelValues("expired").Inc()
+		return "foo{" + domainName + "}" + coveredBy + " expired " + (-remaining).Round(time.Second).String() + " ago, not trusted, serial " + serialNumber(cert), time.Time{}
+	} else if revoked {
+		db.metrics.retrieves.WithLabelValues("revoked").Inc()
+		return "foo{" + domainName + "}" + coveredBy + " revoked, not trusted, serial " + serialNumber(cert), time.Time{}
 	} else {
-		return "foo{" + domainName + "}"
+		db.metrics.retrieves.WithLabelValues("trusted").Inc()
+		return "foo{" + domainName + "}" + coveredBy + " valid for " + remaining.Round(time.Second).String() + " until " + cert.NotAfter.UTC().Format(time.RFC3339) + ", issued " + cert.NotBefore.UTC().Format(time.RFC3339) + ", serial " + serialNumber(cert) + alsoNames(cert), cert.NotAfter
 	}
 }
 
 /*
-'create' is part of the redisResponse decision tree above
+'create' is part of the redisResponse decision tree above. Requests carrying the same
+idempotency key for a domain are coalesced so the cert is only created once, and replayed
+with the status it was first sent with: 201 Created for a new domain, 200 OK for a renewal.
+A failed create is reported with 200 OK, as it always has been, unless the backend was too
+busy to answer, which is 503 Service Unavailable, or there was no room for a new domain, 507
+Insufficient Storage.
 */
-func (db *dbConn) create(domainName string) string {
-	// issue a create request to the redis cache
-	resp, err := db.createCert(domainName)
-	// required delay set out by the specification
-	time.Sleep(time.Second * 10)
+func (db *dbConn) create(ctx context.Context, domainName string, idempotencyKey string, ttl time.Duration, sans []string, meta map[string]string, mode issueMode) (string, int) {
+	resp, err := db.createOnce(ctx, modeScope(mode)+domainName, domainName, idempotencyKey, ttl, sans, meta, mode, db.createdText(domainName))
 	if err != nil {
-		return err.Error()
-	} else {
-		return resp
+		if status := errorStatus(err); status != http.StatusInternalServerError {
+			return err.Error(), status
+		}
+		return err.Error(), http.StatusOK
 	}
+	return resp.Body, resp.Status
 }
 
 /*
- Public access method to see if Redis is alive
+createdText renders the response of a plain create of domainName, the result kept for its
+idempotency key by single and batch creates alike.
 */
-func (db *dbConn) PingRedis() bool {
-	/*
-		Use a pooled connection to redis and close the
-		connection when the function exits.
-	*/
-	conn := db.myPool.Get()
-	defer conn.Close()
+func (db *dbConn) createdText(domainName string) func(cert *x509.Certificate, created bool) string {
+	return func(cert *x509.Certificate, created bool) string {
+		verb := "renewed"
+		if created {
+			verb = "created"
+		}
+		return "OK, foo{" + domainName + "} " + verb + ", expires " + cert.NotAfter.UTC().Format(time.RFC3339) + db.availableAfter(cert, created)
+	}
+}
 
-	/*
-		Reply would be "PONG", but an error will be thrown if "PONG" isn't recived
-	*/
-	_, err := conn.Do("PING")
+/*
+createOnce issues the cert of domainName as mode allows, once for every request with the same
+idempotency key within scope, and returns the response to send: render's body for the cert, 201 Created
+for a new domain or 200 OK for a renewal. The create is counted as ok or error.
+*/
+func (db *dbConn) createOnce(ctx context.Context, scope string, domainName string, idempotencyKey string, ttl time.Duration, sans []string, meta map[string]string, mode issueMode, render func(cert *x509.Certificate, created bool) string) (IdempotentResult, error) {
+	resp, err := db.idempotency.do(ctx, scope, idempotencyKey, func() (IdempotentResult, error) {
+		// issue a create request to the redis cache
+		cert, created, err := db.issue(ctx, domainName, ttl, sans, meta, mode)
+		if err != nil {
+			return IdempotentResult{}, err
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		return IdempotentResult{Status: status, Body: render(cert, created)}, nil
+	})
 	if err != nil {
-		return false
-	} else {
-		return true
+		db.metrics.creates.WithLabelValues("error").Inc()
+		return IdempotentResult{}, err
+	}
+	db.metrics.creates.WithLabelValues("ok").Inc()
+	return resp, nil
+}
+
+// createResult is the response to a create with ?format=json.
+type createResult struct {
+	Domain string `json:"domain"`
+	// Status is created for a new domain, renewed for one that had a cert.
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Serial    string    `json:"serial"`
+	// AvailableAfter is when a new cert may be used, under Config.IssueDelay.
+	AvailableAfter *time.Time `json:"available_after,omitempty"`
+	SANs           []string   `json:"sans"`
+	// Metadata is what the create attached to the cert, if anything.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+/*
+createJSONHandler answers a create with ?format=json with a createResult, and its errors with
+an apiError: 400 CodeInvalidDomain for a domain the service doesn't accept, or the
+errorStatus and errorCode of a failed create. Unlike a plain create, a failed store is 500.
+Its idempotent results are kept apart from those of plain creates, as the bodies differ.
+*/
+func (db *dbConn) createJSONHandler(w http.ResponseWriter, r *http.Request, domainName string, ttl time.Duration, sans []string, meta map[string]string, mode issueMode) {
+	domainName, ok := db.checkDomain(domainName)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidDomain, Error: "invalid domain name: " + domainName, Reasons: invalidReasons(strings.TrimPrefix(domainName, wildcardPrefix))})
+		return
+	}
+	resp, err := db.createOnce(r.Context(), "json\x00"+modeScope(mode)+domainName, domainName, r.Header.Get("Idempotency-Key"), ttl, sans, meta, mode, func(cert *x509.Certificate, created bool) string {
+		result := createResult{Domain: domainName, Status: "renewed", ExpiresAt: cert.NotAfter, Serial: serialNumber(cert), SANs: cert.DNSNames, Metadata: meta}
+		if created {
+			result.Status = "created"
+			if db.issueDelay != 0 {
+				availableAfter := cert.NotBefore.Add(db.issueDelay)
+				result.AvailableAfter = &availableAfter
+			}
+		}
+		body, _ := json.Marshal(result)
+		return string(body)
+	})
+	if err != nil {
+		writeJSONError(w, errorStatus(err), apiError{Code: errorCode(err), Error: err.Error()})
+		return
+	}
+	if resp.Status == http.StatusCreated {
+		w.Header().Set("Location", db.basePath+"/cert/"+url.PathEscape(domainName))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Status)
+	io.WriteString(w, resp.Body)
+}
+
+// createdResponse is the response to a successful create of cert in a batch, created if the domain is new.
+func (db *dbConn) createdResponse(cert *x509.Certificate, created bool) string {
+	return "OK" + db.availableAfter(cert, created)
+}
+
+/*
+availableAfter tells the client when cert, which it just had issued, may be used. The
+specification calls for a delay after creating a cert. Rather than holding the request open,
+the cert is written immediately and the client is told when it may use it. The delay stands
+for the cost of a first issuance, so a renewal, created false, is available straight away.
+Whether a domain is new is reported by the store write itself, under the per-domain create
+lock, so two creates of a new domain can't both skip the delay or both take it. The time is
+counted from when cert was issued, its NotBefore, so the creates sharing it all report the same.
+*/
+func (db *dbConn) availableAfter(cert *x509.Certificate, created bool) string {
+	if !created || db.issueDelay == 0 {
+		return ""
 	}
+	return ", available after " + cert.NotBefore.Add(db.issueDelay).Format(time.RFC3339)
+}
+
+/*
+ Public access method to see if Redis is alive, it gives up once ctx is done or the redis
+ timeout elapses
+*/
+func (db *dbConn) PingRedis(ctx context.Context) bool {
+	return db.ping(ctx) == nil
+}
+
+// ping is PingRedis, returning why redis can't be reached, ErrReplicaUnreachable for the read replica.
+func (db *dbConn) ping(ctx context.Context) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	err := db.store.Ping(ctx)
+	db.observeRedis("ping", start, err)
+	return err
 }
 
 //helper functions
-// encode marshals a time.
 
-func encode(t time.Time) []byte {
-	buf := make([]byte, 8)
-	u := uint64(t.Unix())
-	binary.BigEndian.PutUint64(buf, u)
-	return buf
+/*
+Expiry dates are stored with a one byte version prefix, so the encoding can change without
+misreading the values already in redis. Values stored before versions existed are the bare
+8 byte form of version 1. Since version 3 the value also carries when the cert was issued, and
+since version 4 the cert's metadata.
+*/
+const (
+	// expiryV1 is the Unix seconds as 8 big-endian bytes, which loses sub-second and zone info.
+	expiryV1 byte = 1
+	// expiryV2 is an RFC3339 string, with nanoseconds and the zone offset.
+	expiryV2 byte = 2
+	// expiryV3 is the expiry and then the issue time as version 2 strings, separated by a space.
+	expiryV3 byte = 3
+	// expiryV4 is version 3 followed by a space and the metadata as a JSON object.
+	expiryV4 byte = 4
+	// expiryVersion is the newest encoding values are written in, those without metadata in version 3.
+	expiryVersion = expiryV4
+)
+
+/*
+encode marshals an expiry, the time its cert was issued and its metadata in version 4, or in
+version 3 when there is no metadata, so certs without any stay readable by instances that
+predate it.
+*/
+func encode(expires time.Time, issuedAt time.Time, meta map[string]string) []byte {
+	b := append(append(encodeVersion(expires, expiryV3), ' '), issuedAt.Format(time.RFC3339Nano)...)
+	if len(meta) == 0 {
+		return b
+	}
+	// a map of strings always marshals
+	metaJSON, _ := json.Marshal(meta)
+	b[0] = expiryVersion
+	return append(append(b, ' '), metaJSON...)
 }
 
-// decode unmarshals a time.
-func decode(b []byte) time.Time {
-	i := int64(binary.BigEndian.Uint64(b))
-	return time.Unix(i, 0)
+/*
+encodeVersion marshals a time in the encoding of version, prefixed by the version. For version
+3 that is only the expiry, encode appends the issue time.
+*/
+func encodeVersion(t time.Time, version byte) []byte {
+	if version == expiryV1 {
+		buf := make([]byte, 9)
+		buf[0] = expiryV1
+		binary.BigEndian.PutUint64(buf[1:], uint64(t.Unix()))
+		return buf
+	}
+	return append([]byte{version}, t.Format(time.RFC3339Nano)...)
+}
+
+// errCorruptExpiry is returned, wrapped, by decode for a stored expiry it can't read.
+var errCorruptExpiry = errors.New("corrupt expiry date")
+
+/*
+decode unmarshals an expiry, when its cert was issued and its metadata, in any encoding,
+dispatching on its version prefix. The issue time is zero for versions before 3, which didn't
+record it, and the metadata nil before version 4. A bare 8 bytes is the legacy form of version
+1, which can't be mistaken for a prefixed value: version 1 is 9 bytes and an RFC3339 string
+longer still. A truncated or corrupted value is an error matching errCorruptExpiry, rather
+than a panic.
+*/
+func decode(b []byte) (expires time.Time, issuedAt time.Time, meta map[string]string, err error) {
+	if len(b) == 8 {
+		return time.Unix(int64(binary.BigEndian.Uint64(b)), 0), time.Time{}, nil, nil
+	}
+	if len(b) == 9 && b[0] == expiryV1 {
+		return time.Unix(int64(binary.BigEndian.Uint64(b[1:])), 0), time.Time{}, nil, nil
+	}
+	if len(b) > 0 && b[0] == expiryV2 {
+		expires, err = time.Parse(time.RFC3339Nano, string(b[1:]))
+		if err != nil {
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("%w: %v", errCorruptExpiry, err)
+		}
+		return expires, time.Time{}, nil, nil
+	}
+	if len(b) > 0 && (b[0] == expiryV3 || b[0] == expiryV4) {
+		// neither time has a space in it, but the metadata may
+		fields := strings.SplitN(string(b[1:]), " ", 3)
+		if b[0] == expiryV3 && len(fields) != 2 || b[0] == expiryV4 && len(fields) != 3 {
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("%w: %d fields", errCorruptExpiry, len(fields))
+		}
+		if expires, err = time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			issuedAt, err = time.Parse(time.RFC3339Nano, fields[1])
+		}
+		if err == nil && b[0] == expiryV4 {
+			err = json.Unmarshal([]byte(fields[2]), &meta)
+		}
+		if err != nil {
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("%w: %v", errCorruptExpiry, err)
+		}
+		return expires, issuedAt, meta, nil
+	}
+	return time.Time{}, time.Time{}, nil, fmt.Errorf("%w: %d bytes", errCorruptExpiry, len(b))
 }
 
 /*
 GetAll retrieves all of the domains stored in the redis database. This is just provided for
-convenience of testing.
+convenience of testing. If redis fails the error is logged and nil returned, use ListCerts
+to handle it yourself.
 */
 func (db *dbConn) GetAll() []string {
+	certs, err := db.ListCerts()
+	if err != nil {
+		db.logger.Error("listing the certs failed", "err", err)
+		return nil
+	}
+	// only the domain names are returned, sorted so the result is stable between calls
+	c := make([]string, 0, len(certs))
+	for domain := range certs {
+		c = append(c, domain)
+	}
+	sort.Strings(c)
+	return c
+}
 
-	conn := db.myPool.Get()
-	defer conn.Close()
-
-	data, err := redis.ByteSlices(conn.Do("HGETALL", "Domain"))
+/*
+GetCert looks up the cert stored for domain, in any case, and returns its expiration date and
+true, or false if there is none. The error is only for a domain the service doesn't accept or
+a failed store, never for a missing cert. Like a retrieve over http it reads the store through
+getCert, but it returns the domain's own cert only, not a wildcard covering it, and reports an
+expired or revoked cert like any other.
+*/
+func (db *dbConn) GetCert(domain string) (time.Time, bool, error) {
+	domain, ok := db.checkDomain(domain)
+	if !ok {
+		return time.Time{}, false, errors.New("invalid domain name: " + domain)
+	}
+	cert, err := db.getCert(context.Background(), domain)
+	if errors.Is(err, ErrDomainNotFound) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	return cert.NotAfter, true, nil
+}
 
-	if err != nil && err.Error() != "redigo: nil returned" {
-		log.Fatalf("error: %v", err)
+/*
+CreateCert creates or renews the cert of domain, in any case, and returns its new expiration
+date. It is checked and issued as a create over http is, so it waits for any create of the
+same domain already running and shares its cert. An error is returned for a domain the service
+doesn't accept or a failed store.
+*/
+func (db *dbConn) CreateCert(domain string) (time.Time, error) {
+	domain, ok := db.checkDomain(domain)
+	if !ok {
+		return time.Time{}, errors.New("invalid domain name: " + domain)
 	}
-	var c = make([]string, len(data))
-	/* Each value of x contains a 1value for the domain name and 1 for the expiration date
-	   I'm only seeking to return the Domain names. The Domain names are all the even valued
-	   number, hence the i mod 2 expression here.
-	*/
-	for i, v := range data {
-		if i%2 == 0 {
-			c[i] = string(v)
-		} else {
-			c[i] = "\n"
+	cert, err := db.createCert(context.Background(), domain)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+/*
+ListCerts retrieves every domain stored in the redis database paired with its expiration date,
+for example to audit which certs are close to expiring. The certs are read a page of
+Config.ScanCount at a time, with HSCAN, so redis never has to send them all in one reply. The
+metadata of each cert is read along with it, and is in the CertInfo of ListCertsPage and
+StreamCertificates.
+*/
+func (db *dbConn) ListCerts() (map[string]time.Time, error) {
+	return db.listMatching(context.Background(), nil)
+}
+
+/*
+listMatching is ListCerts for ctx, leaving out the certs without every key and value of filter
+in their metadata. An empty filter lists every cert.
+*/
+func (db *dbConn) listMatching(ctx context.Context, filter map[string]string) (map[string]time.Time, error) {
+	certs := make(map[string]time.Time)
+	cursor := 0
+	for {
+		var page []CertInfo
+		var err error
+		if cursor, page, err = db.scanPage(ctx, cursor); err != nil {
+			return nil, err
+		}
+		for _, cert := range page {
+			if matchesMetadata(cert.Metadata, filter) {
+				certs[cert.Domain] = cert.Expires
+			}
+		}
+		// a cursor of 0 means the walk is complete
+		if cursor == 0 {
+			return certs, nil
 		}
 	}
+}
 
-	return c
+/*
+ListCertsPage returns one page of ListCerts, roughly count certs read with a single HSCAN from
+cursor, and the cursor of the next page. A walk starts at cursor 0 and is complete once 0 is
+returned; certs stored or deleted meanwhile may or may not be seen. A count of 0 or less is
+Config.ScanCount, and one above maxPageSize is maxPageSize.
+*/
+func (db *dbConn) ListCertsPage(ctx context.Context, cursor int, count int) (int, []CertInfo, error) {
+	if cursor < 0 {
+		return 0, nil, fmt.Errorf("invalid cursor %d", cursor)
+	}
+	if count <= 0 {
+		count = db.scanCount
+	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	return db.store.Scan(ctx, cursor, min(count, maxPageSize))
+}
+
+/*
+Count returns the number of domains stored in the redis database, without reading them, so it
+stays cheap however many certs there are.
+*/
+func (db *dbConn) Count() (int, error) {
+	ctx, cancel := db.withTimeout(context.Background())
+	defer cancel()
+	return db.store.Count(ctx)
+}
+
+/*
+Flush deletes every cert stored by the service, in its namespace only, and returns how many
+domains were removed. Nothing else in the redis database is touched, nor are the histories
+and revocations kept alongside the certs. The service's own certificate goes too, and is
+issued again at its next renewal. It is meant for clearing out test data.
+*/
+func (db *dbConn) Flush() (int, error) {
+	return db.flush(context.Background())
+}
+
+// flush is Flush for ctx, audited as the actor of ctx.
+func (db *dbConn) flush(ctx context.Context) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	removed, err := db.store.Flush(ctx)
+	db.observeRedis("flush", start, err)
+	if err != nil {
+		db.logger.Error("flushing the certs from redis failed", "err", err)
+		return 0, err
+	}
+	db.logger.Info("flushed the certs", "removed", removed)
+	db.audit(ctx, "flush", "*", "removed", removed)
+	return removed, nil
 }
@@ -12,14 +12,28 @@ This test file essentially runs an emulation  for a Certificate server that serv
 package CertificateService
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 
 	//go get github.com/Pallinder/go-randomdata
 	"github.com/Pallinder/go-randomdata"
+	"github.com/gomodule/redigo/redis"
 	"io/ioutil"
+	"maps"
+	"math/big"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -58,16 +72,22 @@ docker run --name some-redis -d -p 6379:6379 redis redis-server --appendonly yes
 func TestServer(t *testing.T) {
 
 	db := NewCertificateService()
+	// clear out the random domains created below, rather than leaving them in redis
+	t.Cleanup(func() {
+		if _, err := db.Flush(); err != nil {
+			t.Errorf("flushing the test domains failed: %v", err)
+		}
+	})
 	go db.OpenHTTPServer()
 	resp, err := http.Get("http://localhost:8080")
 	if err != nil {
-		t.Fatalf("There was a problem opening http server, Please check your configuration and re run the test \n" + err.Error())
+		t.Fatalf("There was a problem opening http server, Please check your configuration and re run the test \n%v", err)
 	}
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
-	t.Logf(string(body[:]))
+	t.Log(string(body[:]))
 
-	redisOK := db.PingRedis()
+	redisOK := db.PingRedis(context.Background())
 	if !redisOK {
 		t.Fatalf("Redis could not be pinged. Please start Redis befor running these tests")
 	}
@@ -77,20 +97,19 @@ func TestServer(t *testing.T) {
 
 /*
 TestCreateCerts tests the creation of certs, specifically,
-the SIMULTANEOUS creation of domains, despite the built in 10
-second delay after the creation of a cert.
+the SIMULTANEOUS creation of domains.
 
 Simultanaity is achieved by using waitgroups and goroutines.
 
-Just imagine if 200 requests were made within in few seconds during the world series. The first user would have to
-wait 33 minutes before they could validate their connection. This way, each and every simultaneous user would
-wait only 10 seconds ( or slightly more.
+Just imagine if 200 requests were made within in few seconds during the world series. None of them
+should have to wait on each other. The optional issue delay from the specification is reported back
+to the client as an "available after" time rather than blocking the request.
 
 */
 func testCreateCerts(db CertificateService) {
 	//create 100 domain name
 
-	fmt.Print("Simultaneous creation of 10 domains. Should take 10 seconds due to the delay requirements in the specification.\n\n")
+	fmt.Print("Simultaneous creation of 10 domains. Should complete almost immediately.\n\n")
 	var wg = sync.WaitGroup{}
 	for i := 0; i < 9; i++ {
 		//need a seperate wait group for each iteration
@@ -98,9 +117,9 @@ func testCreateCerts(db CertificateService) {
 		//simultaneous creation of domains
 		go func() {
 			defer wg.Done()
-			_, err := http.Get("http://localhost:8080/certcreate/" + randomdata.SillyName() + randExt())
+			_, err := http.Post("http://localhost:8080/certcreate/"+randomdata.SillyName()+randExt(), "", nil)
 			if err != nil {
-				log.Fatalf(err.Error())
+				log.Fatal(err)
 			}
 			//defer resp.Body.Close()
 			//body, _ := ioutil.ReadAll(resp.Body)
@@ -118,20 +137,9 @@ func testCreateCerts(db CertificateService) {
 func testDomains(db CertificateService) {
 	fmt.Println("Testing each cert that we created through an http connection.")
 	fmt.Print("localhost:80808/cert/{Domain}. CERTSERVER.FAN will be created separately by certificate service for its own use.\n\n")
-	x := db.GetAll()
 	//retrieve all the domains in the redis cache
-	for i, v := range x {
-		/* Each value of x contains a 1value for the domain name and 1 for the expiration date
-		   I'm only seeking to return the Domain names. The Domain names are all the even valued
-		   number, hence the i mod 2 expression here.
-		*/
-		if i%2 == 0 {
-			resp, _ := http.Get("http://localhost:8080/cert/" + v)
-			defer resp.Body.Close()
-			body, _ := ioutil.ReadAll(resp.Body)
-			str2 := string(body[:])
-			fmt.Println(str2)
-		}
+	for _, v := range db.GetAll() {
+		printCert(v)
 	}
 	fmt.Print("\n\n")
 	fmt.Println("Each certificate expires after 10 minutes.")
@@ -140,15 +148,16 @@ func testDomains(db CertificateService) {
 	fmt.Println("-the server renews its cert automatically per the requirements")
 	fmt.Println("-the regular certs expire on their own")
 	fmt.Println("You can cancel the program now if you are satisfied, or, ")
-	fmt.Println("during this simulation, try opening a browser ")
-	fmt.Println("and creating a cert by going to localhost:8080/certcreate/{domain}.")
-	fmt.Println("and finally testing it by going to localhost:8080/cert/{domain}")
-	fmt.Println("Valid domains include any alphanumeric combination of 1-62 character, followed ")
-	fmt.Println("by a '.' and finally by another alphanumeric combination of 2-62 characters.")
+	fmt.Println("during this simulation, try creating a cert with a POST, ")
+	fmt.Println("curl -X POST localhost:8080/certcreate/{domain}")
+	fmt.Println("and finally testing it by opening a browser and going to localhost:8080/cert/{domain}")
+	fmt.Println("Valid domains are any number of '.' separated labels of 1-63 alphanumeric or '-' characters,")
+	fmt.Println("not starting or ending with a '-', followed by a final '.' and a top level domain of 2 or more letters.")
 	fmt.Println("Examples: ")
 	fmt.Println("Valid: Fanatics.com")
+	fmt.Println("Valid: Fanatics.co.uk")
 	fmt.Println("Invalid:  Fanatics (no extension)")
-	fmt.Println("Invalid Fanatics.co.uk (too many extensions).")
+	fmt.Println("Invalid: -Fanatics.com (starts with a '-').")
 	fmt.Println("WAITING FOR 11 MINUTES.......")
 	time.Sleep(time.Minute * 11)
 
@@ -160,20 +169,1061 @@ func testFinal(db CertificateService) {
 	fmt.Print("\n\n")
 	fmt.Println("Testing if the domains expired, and the server certificate renewed")
 	//retrieve all the domains in the redis cache
-	x := db.GetAll()
-	for i, v := range x {
-		/* Each value of x contains a 1value for the domain name and 1 for the expiration date
-		   I'm only seeking to return the Domain names. The Domain names are all the even valued
-		   number, hence the i mod 2 expression here.
-		*/
-		if i%2 == 0 {
-			resp, _ := http.Get("http://localhost:8080/cert/" + v)
-			defer resp.Body.Close()
-			body, _ := ioutil.ReadAll(resp.Body)
-			str2 := string(body[:])
-			fmt.Println(str2)
-		}
+	for _, v := range db.GetAll() {
+		printCert(v)
 	}
 
 	fmt.Println("CERTSERVER.FAN should be the only certificate that hasn't expired.")
 }
+
+// printCert retrieves a domain through the http server and prints the response.
+func printCert(domain string) {
+	resp, err := http.Get("http://localhost:8080/cert/" + domain)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Println(string(body[:]))
+}
+
+// TestHandler checks the http API can be served from Handler over a test server, without OpenHTTPServer.
+func TestHandler(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	server := httptest.NewServer(db.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/certcreate/fanatics.com", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected 201, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(server.URL + "/CERT/fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); !strings.HasPrefix(string(body), "<h1>foo{fanatics.com} valid for") {
+		t.Errorf("expected the cert through the case folding route, got %s", body)
+	}
+}
+
+// TestGetAll checks GetAll returns just the stored domain names.
+func TestGetAll(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	if all := db.GetAll(); len(all) != 0 {
+		t.Errorf("expected no domains, got %q", all)
+	}
+	for _, domain := range []string{"fanatics.com", "example.net", "abc.us"} {
+		if _, err := db.createCert(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all := db.GetAll()
+	expected := []string{"abc.us", "example.net", "fanatics.com"}
+	if strings.Join(all, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %q, got %q", expected, all)
+	}
+}
+
+// TestGetCert checks GetCert returns a stored cert's expiry, and only errs for a bad domain or a failed store.
+func TestGetCert(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	cert, err := db.createCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires, ok, err := db.GetCert("Fanatics.COM"); !ok || err != nil || !expires.Equal(cert.NotAfter) {
+		t.Errorf("expected the cert expiring %v, got %v %v %v", cert.NotAfter, expires, ok, err)
+	}
+	if _, ok, err := db.GetCert("missing.com"); ok || err != nil {
+		t.Errorf("expected a missing domain to be not found without an error, got %v %v", ok, err)
+	}
+	if _, _, err := db.GetCert("-invalid"); err == nil {
+		t.Error("expected an invalid domain to be an error")
+	}
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	if _, ok, err := db.GetCert("fanatics.com"); ok || err == nil {
+		t.Errorf("expected a failed store to be an error, got %v %v", ok, err)
+	}
+}
+
+// TestCreateCertAPI checks CreateCert issues a cert GetCert can find, and errs for a bad domain or a failed store.
+func TestCreateCertAPI(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	expires, err := db.CreateCert("Fanatics.COM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining := time.Until(expires); remaining <= 0 || remaining > defaultTTL {
+		t.Errorf("expected the cert to expire within a lifetime, got %v", expires)
+	}
+	if got, ok, err := db.GetCert("fanatics.com"); !ok || err != nil || !got.Equal(expires) {
+		t.Errorf("expected the created cert, got %v %v %v", got, ok, err)
+	}
+	if _, err := db.CreateCert("-invalid"); err == nil || fake.count("EXEC") != 1 {
+		t.Errorf("expected an invalid domain to be rejected without a write, got %v", err)
+	}
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	if _, err := db.CreateCert("example.com"); err == nil {
+		t.Error("expected a failed store to be an error")
+	}
+}
+
+/*
+TestRenewalRetry makes the first renewal of the server certificate fail, as if redis were
+down, and checks that a retry follows quickly instead of a crash or a full renewal interval.
+*/
+func TestRenewalRetry(t *testing.T) {
+	fake := newFakeRedis()
+	var once sync.Once
+	fake.fail = func(cmd string) error {
+		var err error
+		if cmd == "EXEC" {
+			once.Do(func() { err = errors.New("connection refused") })
+		}
+		return err
+	}
+	db := newFakeDBWithConfig(fake, Config{RenewRetryBase: time.Millisecond * 10})
+
+	db.newCertServer()
+	if fake.count("EXEC") != 1 {
+		t.Fatalf("expected one failed renewal attempt, got %d", fake.count("EXEC"))
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for fake.count("EXEC") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the failed renewal was not retried")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	if _, err := db.getCert(context.Background(), "certserver.fan"); err != nil {
+		t.Errorf("the server certificate should exist after the retry: %v", err)
+	}
+}
+
+// TestRenewBackoff checks the retry delay doubles per failure and stays capped.
+func TestRenewBackoff(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{RenewRetryBase: time.Second, RenewRetryMax: time.Second * 5})
+	expected := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5}
+	for failures, want := range expected {
+		if got := db.renewBackoff(failures); got != want {
+			t.Errorf("after %d failures expected a %v delay, got %v", failures, want, got)
+		}
+	}
+}
+
+// TestCreateIssueDelay checks that the issue delay is reported rather than blocking the create.
+func TestCreateIssueDelay(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{IssueDelay: time.Second * 10})
+	start := time.Now()
+	resp, _ := db.create(context.Background(), "fanatics.com", "", 0, nil, nil, createOrRenew)
+	if time.Since(start) > time.Second {
+		t.Errorf("create blocked for %v", time.Since(start))
+	}
+	_, after, ok := strings.Cut(resp, ", available after ")
+	if !strings.HasPrefix(resp, "OK, foo{fanatics.com} created") || !ok {
+		t.Fatalf("expected the available after time in the response, got %q", resp)
+	}
+	available, err := time.Parse(time.RFC3339, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available.Before(start.Add(time.Second*9)) || available.After(time.Now().Add(time.Second*10)) {
+		t.Errorf("expected the cert to be available in 10 seconds, got %v", available)
+	}
+	// counted from the issuance, so every create sharing it reports the same time
+	if cert, err := db.getCert(context.Background(), "fanatics.com"); err != nil || !available.Equal(cert.NotBefore.Add(time.Second*10)) {
+		t.Errorf("expected the cert to be available 10 seconds after it was issued, got %v %v", available, err)
+	}
+
+	if resp, _ := db.create(context.Background(), "fanatics.com", "", 0, nil, nil, createOrRenew); !strings.HasPrefix(resp, "OK, foo{fanatics.com} renewed") || strings.Contains(resp, "available after") {
+		t.Errorf("expected a renewal to skip the delay, got %q", resp)
+	}
+	results := db.createBatch(context.Background(), []string{"fanatics.com", "fanatics.org"})
+	if results[0].Status != "OK" || !strings.HasPrefix(results[1].Status, "OK, available after ") {
+		t.Errorf("expected only the new domain in a batch to be delayed, got %+v", results)
+	}
+
+	if resp, _ := newFakeDB(newFakeRedis()).create(context.Background(), "fanatics.com", "", 0, nil, nil, createOrRenew); strings.Contains(resp, "available after") {
+		t.Errorf("without an issue delay expected no available after time, got %q", resp)
+	}
+}
+
+// TestCreateStatus checks a new domain is 201 Created with a Location, and a renewal 200 OK, in either key layout.
+func TestCreateStatus(t *testing.T) {
+	for _, layout := range []KeyLayout{HashLayout, PerDomainKeyLayout} {
+		db := newFakeDBWithConfig(newFakeRedis(), Config{KeyLayout: layout})
+		create := func(key string) *httptest.ResponseRecorder {
+			r := newRequest("/certcreate/Fanatics.com")
+			r.Header.Set("Idempotency-Key", key)
+			w := httptest.NewRecorder()
+			db.httpHandler(w, r)
+			return w
+		}
+
+		w := create("first")
+		cert, err := db.getCert(context.Background(), "fanatics.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "<h1>OK, foo{fanatics.com} created, expires " + cert.NotAfter.UTC().Format(time.RFC3339) + "</h1>"
+		if w.Code != http.StatusCreated || w.Header().Get("Location") != "/cert/fanatics.com" || w.Body.String() != expected {
+			t.Errorf("layout %d: expected 201 at /cert/fanatics.com %q, got %d at %q %q", layout, expected, w.Code, w.Header().Get("Location"), w.Body)
+		}
+		// a replay is sent as the create was first
+		if replay := create("first"); replay.Code != http.StatusCreated || replay.Body.String() != w.Body.String() {
+			t.Errorf("layout %d: expected the create to be replayed, got %d %q", layout, replay.Code, replay.Body)
+		}
+		w = create("second")
+		if w.Code != http.StatusOK || w.Header().Get("Location") != "" || !strings.HasPrefix(w.Body.String(), "<h1>OK, foo{fanatics.com} renewed, expires ") {
+			t.Errorf("layout %d: expected a renewal to be 200, got %d at %q %q", layout, w.Code, w.Header().Get("Location"), w.Body)
+		}
+	}
+}
+
+// TestListCerts checks ListCerts pairs each domain with its expiration and /certs serves it as JSON.
+func TestListCerts(t *testing.T) {
+	fake := newFakeRedis()
+	expires := time.Now().Add(time.Minute).Truncate(time.Second)
+	fake.set("Domain", "fanatics.com", encode(expires, time.Time{}, nil))
+	fake.set("Domain", "example.net", encode(expires.Add(time.Minute), time.Time{}, nil))
+	db := newFakeDB(fake)
+
+	certs, err := db.ListCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs["fanatics.com"].Equal(expires) || !certs["example.net"].Equal(expires.Add(time.Minute)) {
+		t.Errorf("unexpected certs %v", certs)
+	}
+
+	w := httptest.NewRecorder()
+	db.httpHandler(w, httptest.NewRequest("GET", "/certs", nil))
+	var served map[string]time.Time
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatalf("/certs did not serve JSON: %v %s", err, w.Body)
+	}
+	if len(served) != 2 || !served["fanatics.com"].Equal(expires) {
+		t.Errorf("unexpected /certs response %s", w.Body)
+	}
+}
+
+// TestListCertsPaged checks ListCerts reads the certs with HSCAN a page of Config.ScanCount at a time.
+func TestListCertsPaged(t *testing.T) {
+	fake := newFakeRedis()
+	expires := time.Now().Add(time.Minute).Truncate(time.Second)
+	for i := 0; i < 25; i++ {
+		fake.set("Domain", fmt.Sprintf("domain%d.com", i), encode(expires, time.Time{}, nil))
+	}
+	db := newFakeDBWithConfig(fake, Config{ScanCount: 10})
+
+	certs, err := db.ListCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 25 || !certs["domain24.com"].Equal(expires) {
+		t.Errorf("unexpected certs %v", certs)
+	}
+	if fake.count("HSCAN") != 3 || fake.count("HGETALL") != 0 {
+		t.Errorf("expected 3 HSCAN pages and no HGETALL, got %d and %d", fake.count("HSCAN"), fake.count("HGETALL"))
+	}
+}
+
+// TestListCertsPage checks /certs pages through the certs with a cursor, one HSCAN per page, until it comes back 0.
+func TestListCertsPage(t *testing.T) {
+	fake := newFakeRedis()
+	expires := time.Now().Add(time.Minute).Truncate(time.Second)
+	for i := 0; i < 25; i++ {
+		fake.set("Domain", fmt.Sprintf("domain%d.com", i), encode(expires, time.Time{}, nil))
+	}
+	db := newFakeDB(fake)
+
+	seen := make(map[string]bool)
+	cursor, pages := 0, 0
+	for {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest(fmt.Sprintf("/certs?cursor=%d&count=10", cursor)))
+		var page listPage
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("expected a page, got %d %v", w.Code, err)
+		}
+		for _, cert := range page.Certs {
+			if seen[cert.Domain] || !cert.ExpiresAt.Equal(expires) || cert.IssuedAt != nil {
+				t.Errorf("unexpected cert %+v", cert)
+			}
+			seen[cert.Domain] = true
+		}
+		pages++
+		if cursor = page.Cursor; cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 25 || pages != 3 {
+		t.Errorf("expected 25 certs over 3 pages, got %d over %d", len(seen), pages)
+	}
+	if fake.count("HSCAN") != 3 || fake.count("HGETALL") != 0 {
+		t.Errorf("expected 3 HSCAN pages and no HGETALL, got %d and %d", fake.count("HSCAN"), fake.count("HGETALL"))
+	}
+
+	for _, query := range []string{"cursor=-1", "count=ten"} {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest("/certs?"+query))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(CodeInvalidRequest)) {
+			t.Errorf("%s: expected 400, got %d %s", query, w.Code, w.Body)
+		}
+	}
+	if _, _, err := db.ListCertsPage(context.Background(), -1, 10); err == nil {
+		t.Error("expected a negative cursor to be rejected")
+	}
+}
+
+// TestRetrieveCacheControl checks the Cache-Control max-age follows the remaining lifetime of a cert.
+func TestRetrieveCacheControl(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{CacheControl: true})
+	if _, err := db.storeCert(context.Background(), "valid.com", time.Now().Add(time.Minute*5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.storeCert(context.Background(), "expired.com", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheControl := func(domain string) string {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, httptest.NewRequest("GET", "/cert/"+domain, nil))
+		return w.Header().Get("Cache-Control")
+	}
+
+	header := cacheControl("valid.com")
+	maxAge, err := strconv.Atoi(strings.TrimPrefix(header, "max-age="))
+	if err != nil {
+		t.Fatalf("expected a max-age for a valid cert, got %q", header)
+	}
+	if maxAge < 290 || maxAge > 300 {
+		t.Errorf("expected max-age close to the remaining 300 seconds, got %d", maxAge)
+	}
+	if header := cacheControl("expired.com"); header != "no-store" {
+		t.Errorf("expected no-store for an expired cert, got %q", header)
+	}
+	if header := cacheControl("missing.com"); header != "no-store" {
+		t.Errorf("expected no-store for a missing cert, got %q", header)
+	}
+
+	db.cacheControl = false
+	if header := cacheControl("valid.com"); header != "" {
+		t.Errorf("expected no Cache-Control header when disabled, got %q", header)
+	}
+}
+
+// TestRetrieveHead checks a HEAD retrieve answers with the cert's status and expiry in headers alone.
+func TestRetrieveHead(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{CacheControl: true})
+	expires := time.Now().Add(time.Minute * 5).Truncate(time.Second)
+	for domain, expiry := range map[string]time.Time{"valid.com": expires, "expired.com": expires.Add(-time.Hour), "revoked.com": expires} {
+		if _, err := db.storeCert(context.Background(), domain, expiry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.revoke(context.Background(), "revoked.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		domain  string
+		code    int
+		expires string
+		marker  string
+	}{
+		{"valid.com", http.StatusOK, expires.UTC().Format(time.RFC3339), ""},
+		{"expired.com", http.StatusOK, expires.Add(-time.Hour).UTC().Format(time.RFC3339), "X-Cert-Expired"},
+		{"revoked.com", http.StatusOK, expires.UTC().Format(time.RFC3339), "X-Cert-Revoked"},
+		{"sub.valid.com", http.StatusNotFound, "", ""},
+		{"-invalid.com", http.StatusBadRequest, "", ""},
+	}
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, httptest.NewRequest("HEAD", "/cert/"+req.domain, nil))
+		if w.Code != req.code || w.Body.Len() != 0 || w.Header().Get("X-Cert-Expires") != req.expires {
+			t.Errorf("%s: expected %d expiring %q with no body, got %d %q %q", req.domain, req.code, req.expires, w.Code, w.Header().Get("X-Cert-Expires"), w.Body)
+		}
+		for _, marker := range []string{"X-Cert-Expired", "X-Cert-Revoked"} {
+			if set := w.Header().Get(marker) == "true"; set != (marker == req.marker) {
+				t.Errorf("%s: expected %s set %v", req.domain, marker, !set)
+			}
+		}
+		if trusted := strings.HasPrefix(w.Header().Get("Cache-Control"), "max-age="); trusted != (req.domain == "valid.com") {
+			t.Errorf("%s: unexpected Cache-Control %q", req.domain, w.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+// TestCreateX509 checks a created domain gets a parsable self-signed certificate and private key.
+func TestCreateX509(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	created, err := db.createCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := db.getCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(created) {
+		t.Errorf("getCert returned a different certificate than was created")
+	}
+	if err := cert.VerifyHostname("fanatics.com"); err != nil {
+		t.Errorf("the certificate is not valid for its domain: %v", err)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("the certificate is not self-signed: %v", err)
+	}
+	if d := time.Until(cert.NotAfter); d < db.ttl-time.Minute || d > db.ttl {
+		t.Errorf("expected the certificate to expire in %v, expires in %v", db.ttl, d)
+	}
+
+	keyPEM, _ := redis.Bytes(fake.do("HGET", "PrivateKey", "fanatics.com"))
+	certPEM, _ := redis.Bytes(fake.do("HGET", "Certificate", "fanatics.com"))
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Errorf("the stored key does not match the certificate: %v", err)
+	}
+	if expires, err := db.ListCerts(); err != nil || !expires["fanatics.com"].Equal(cert.NotAfter) {
+		t.Errorf("the listed expiry does not match the certificate")
+	}
+}
+
+/*
+TestRedisTimeout hangs redis and checks calls give up after the configured timeout, and that
+a request whose client has gone away is abandoned.
+*/
+func TestRedisTimeout(t *testing.T) {
+	fake := newFakeRedis()
+	hang := make(chan struct{})
+	defer close(hang)
+	fake.fail = func(cmd string) error {
+		if cmd == "PING" || cmd == "HMGET" {
+			<-hang
+		}
+		return nil
+	}
+	db := newFakeDBWithConfig(fake, Config{RedisTimeout: time.Millisecond * 50, KeyLayout: PerDomainKeyLayout})
+
+	start := time.Now()
+	if db.PingRedis(context.Background()) {
+		t.Error("expected the ping of a hung redis to fail")
+	}
+	if _, err := db.getCert(context.Background(), "fanatics.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the lookup to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the calls to give up after the timeout, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("GET", "/cert/fanatics.com", nil).WithContext(ctx))
+	if body := rec.Body.String(); body != "<h1>"+context.Canceled.Error()+"</h1>" {
+		t.Errorf("expected the request to be abandoned, got %s", body)
+	}
+}
+
+// TestHealthz checks /healthz reports a live instance without asking redis.
+func TestHealthz(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if body := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusOK || body != `{"status":"ok"}` {
+		t.Errorf("expected a live instance, got %d %s", rec.Code, body)
+	}
+	if n := fake.count("PING"); n != 0 {
+		t.Errorf("expected no redis ping, got %d", n)
+	}
+}
+
+// TestReady checks /ready needs redis to answer and the server cert to be stored and unexpired.
+func TestReady(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	ready := func() (int, string) {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest("GET", "/ready", nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "hasn't been issued") {
+		t.Errorf("expected an unready instance before the server cert is issued, got %d %s", code, body)
+	}
+	if _, err := db.createCert(context.Background(), db.serverDomain); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := ready(); code != http.StatusOK || body != `{"status":"ready"}` {
+		t.Errorf("expected a ready instance, got %d %s", code, body)
+	}
+	ttl, _ := db.lifetime()
+	now := time.Now()
+	db.now = func() time.Time { return now.Add(ttl + time.Hour) }
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "expired") {
+		t.Errorf("expected an unready instance once the server cert expired, got %d %s", code, body)
+	}
+	db.now = time.Now
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "redis") {
+		t.Errorf("expected an unready instance without redis, got %d %s", code, body)
+	}
+
+	// without a server cert only redis is checked
+	fake = newFakeRedis()
+	db = newFakeDBWithConfig(fake, Config{DisableServerCert: true})
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("expected a ready instance without a server cert, got %d %s", code, body)
+	}
+}
+
+// TestCount checks /count reports the number of stored domains with a single HLEN, or the redis error.
+func TestCount(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	for _, domain := range []string{"fanatics.com", "example.com"} {
+		if _, err := db.createCert(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() (int, string) {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest("GET", "/count", nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	if code, body := count(); code != http.StatusOK || body != `{"count":2}` {
+		t.Errorf("expected 2 domains, got %d %s", code, body)
+	}
+	if fake.count("HLEN") != 1 || fake.count("HGETALL") != 0 {
+		t.Errorf("expected a single HLEN and no HGETALL")
+	}
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	if code, body := count(); code != http.StatusInternalServerError || body != "connection refused" {
+		t.Errorf("expected the redis error, got %d %s", code, body)
+	}
+}
+
+// TestLogger checks redis errors go to the configured logger instead of ending the process.
+func TestLogger(t *testing.T) {
+	fake := newFakeRedis()
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	var logs bytes.Buffer
+	db := newFakeDBWithConfig(fake, Config{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+
+	if all := db.GetAll(); all != nil {
+		t.Errorf("expected no domains, got %q", all)
+	}
+	if !strings.Contains(logs.String(), `level=ERROR msg="listing the certs failed" err="connection refused"`) {
+		t.Errorf("expected the error to be logged, got %s", logs.String())
+	}
+}
+
+// TestGetCertNotFound checks a missing domain is told apart from a failing redis.
+func TestGetCertNotFound(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	cert, err := db.getCert(context.Background(), "missing.com")
+	if !errors.Is(err, ErrDomainNotFound) || cert != nil {
+		t.Errorf("expected ErrDomainNotFound and no cert, got %v %v", cert, err)
+	}
+
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	if _, err := db.getCert(context.Background(), "missing.com"); err == nil || errors.Is(err, ErrDomainNotFound) {
+		t.Errorf("expected the redis error, got %v", err)
+	}
+	if body, _, _, _ := db.retrieve(context.Background(), "missing.com"); body != "connection refused" {
+		t.Errorf("a failing redis shouldn't be reported as a missing domain, got %s", body)
+	}
+}
+
+// newRequest returns a request for path using the method its route expects: POST to create, GET otherwise.
+func newRequest(path string) *http.Request {
+	if strings.HasPrefix(strings.ToLower(path), "/certcreate") {
+		return httptest.NewRequest("POST", path, nil)
+	}
+	return httptest.NewRequest("GET", path, nil)
+}
+
+// TestMethods checks each route only accepts its methods.
+func TestMethods(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	requests := []struct {
+		method, path, allow string
+	}{
+		{"GET", "/certcreate/fanatics.com", "POST"},
+		{"POST", "/cert/fanatics.com", "GET, HEAD"},
+		{"DELETE", "/certs", "GET, HEAD"},
+		{"PUT", "/healthz", "GET, HEAD"},
+	}
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest(req.method, req.path, nil))
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != req.allow {
+			t.Errorf("%s %s: expected 405 allowing %s, got %d allowing %s", req.method, req.path, req.allow, rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("HEAD", "/cert/fanatics.com", nil))
+	// allowed, and answered that no cert exists
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected HEAD to be allowed for retrieval, got %d", rec.Code)
+	}
+	if n := len(db.GetAll()); n != 0 {
+		t.Errorf("a GET to /certcreate/ shouldn't create a cert, got %d certs", n)
+	}
+}
+
+// TestRouting checks paths that merely contain a route, or only resemble one, aren't routed to it.
+func TestRouting(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	requests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/cert/certcreate/fanatics.com", http.StatusOK, "<h1>Invalid domain name: certcreate/fanatics.com</h1>"},
+		{"POST", "/cert/certcreate/fanatics.com", http.StatusMethodNotAllowed, "method not allowed\n"},
+		{"POST", "/certcreate/cert/fanatics.com", http.StatusOK, "<h1>Invalid domain name: cert/fanatics.com</h1>"},
+		{"POST", "/x/certcreate/fanatics.com", http.StatusOK, "<h1> server is live"},
+		{"GET", "/certsx", http.StatusOK, "<h1> server is live"},
+		{"GET", "/certificate/fanatics.com", http.StatusOK, "<h1> server is live"},
+		{"POST", "/certcreate/fanatics%2Ecom", http.StatusCreated, "<h1>OK, foo{fanatics.com} created"},
+		{"GET", "/CERT/fanatics.com", http.StatusOK, "<h1>foo{fanatics.com} valid for"},
+	}
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest(req.method, req.path, nil))
+		if rec.Code != req.code || !strings.HasPrefix(rec.Body.String(), req.body) {
+			t.Errorf("%s %s: expected %d %q, got %d %q", req.method, req.path, req.code, req.body, rec.Code, rec.Body.String())
+		}
+	}
+
+	// dot segments are cleaned by redirecting the client, never by serving the cleaned route
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("POST", "/cert/../certcreate/fanatics.com", nil))
+	if rec.Code/100 != 3 || rec.Header().Get("Location") != "/certcreate/fanatics.com" {
+		t.Errorf("expected a redirect to the cleaned path, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if n := len(db.GetAll()); n != 1 {
+		t.Errorf("expected only fanatics.com to be created, got %d certs", n)
+	}
+}
+
+// TestRootPage checks the usage page follows the host and keys, and can be replaced or suppressed.
+func TestRootPage(t *testing.T) {
+	get := func(db *dbConn) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = "certs.example.com:9000"
+		db.httpHandler(rec, r)
+		return rec
+	}
+
+	rec := get(newFakeDB(newFakeRedis()))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "GET http://certs.example.com:9000/cert/{domain}") || strings.Contains(body, "localhost") {
+		t.Errorf("expected the usage page at the request's host, got %d %q", rec.Code, body)
+	}
+	if strings.Contains(body, "API key") || strings.Contains(body, "/flush") {
+		t.Errorf("expected no keys or admin endpoints mentioned, got %q", body)
+	}
+
+	body = get(newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"secret"}, AdminToken: "admin"})).Body.String()
+	if !strings.Contains(body, "/certcreate/{domain} creates or renews the cert of a domain (API key required)") || !strings.Contains(body, "/flush") {
+		t.Errorf("expected the keys and admin endpoints mentioned, got %q", body)
+	}
+	if strings.Contains(body, "secret") {
+		t.Errorf("the usage page mustn't show a key, got %q", body)
+	}
+
+	rec = get(newFakeDBWithConfig(newFakeRedis(), Config{RootPage: "<p>hello</p>"}))
+	if rec.Code != http.StatusOK || rec.Body.String() != "<p>hello</p>" {
+		t.Errorf("expected the configured page, got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = get(newFakeDBWithConfig(newFakeRedis(), Config{RootRedirect: "https://example.com/docs"}))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/docs" {
+		t.Errorf("expected a redirect to the docs, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = get(newFakeDBWithConfig(newFakeRedis(), Config{DisableRootPage: true}))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("expected an empty 200, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), RootPage: "x", DisableRootPage: true}); err == nil {
+		t.Error("expected a root page and disabling it to be rejected together")
+	}
+}
+
+// TestBasePath checks every route is served below the base path, and nothing outside it.
+func TestBasePath(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{BasePath: "/CertSvc/"})
+	requests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"POST", "/certsvc/certcreate/fanatics.com", http.StatusCreated, "<h1>OK, foo{fanatics.com} created"},
+		{"GET", "/certsvc/cert/fanatics.com", http.StatusOK, "<h1>foo{fanatics.com} valid for"},
+		{"GET", "/CERTSVC/cert/fanatics.com", http.StatusOK, "<h1>foo{fanatics.com} valid for"},
+		{"GET", "/certsvc/cert/missing.com", http.StatusOK, "<h1>This domain doesn't exist: missing.com. Submit a cert request to /certsvc/certcreate/{domain}</h1>"},
+		{"GET", "/certsvc/validate/fanatics.com", http.StatusOK, `{"domain":"fanatics.com","valid":true}`},
+		{"GET", "/certsvc/certhistory/missing.com", http.StatusNotFound, `{"code":"not_found"`},
+		{"GET", "/certsvc/", http.StatusOK, "<h1> server is live"},
+		{"GET", "/cert/fanatics.com", http.StatusNotFound, "404 page not found"},
+		{"POST", "/certcreate/fanatics.com", http.StatusNotFound, "404 page not found"},
+		{"GET", "/", http.StatusNotFound, "404 page not found"},
+	}
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest(req.method, req.path, nil))
+		if rec.Code != req.code || !strings.HasPrefix(rec.Body.String(), req.body) {
+			t.Errorf("%s %s: expected %d %q, got %d %q", req.method, req.path, req.code, req.body, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("POST", "/certsvc/certcreate/new.com", nil))
+	if loc := rec.Header().Get("Location"); loc != "/certsvc/cert/new.com" {
+		t.Errorf("expected the Location below the base path, got %q", loc)
+	}
+	rec = httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("GET", "/certsvc/", nil))
+	if !strings.Contains(rec.Body.String(), "/certsvc/cert/{domain}") {
+		t.Errorf("expected the usage page below the base path, got %q", rec.Body.String())
+	}
+
+	for _, base := range []string{"certsvc", "/cert/../svc", "/{svc}"} {
+		if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), BasePath: base}); err == nil {
+			t.Errorf("expected base path %q to be rejected", base)
+		}
+	}
+}
+
+// TestClock checks expiration follows the injected clock, so it can be tested without waiting for a cert to expire.
+func TestClock(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	db.now = func() time.Time { return now }
+
+	cert, err := db.createCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := ", serial " + serialNumber(cert)
+	body, _, trustedUntil, _ := db.retrieve(context.Background(), "fanatics.com")
+	if body != "foo{fanatics.com} valid for 10m0s until 2024-01-01T12:10:00Z, issued 2024-01-01T12:00:00Z"+serial || !trustedUntil.Equal(now.Add(defaultTTL)) {
+		t.Fatalf("expected a trusted cert until %v, got %s until %v", now.Add(defaultTTL), body, trustedUntil)
+	}
+
+	now = now.Add(defaultTTL + time.Second)
+	if body, _, _, _ := db.retrieve(context.Background(), "fanatics.com"); body != "foo{fanatics.com} expired 1s ago, not trusted"+serial {
+		t.Errorf("expected the cert to have expired, got %s", body)
+	}
+	if buckets, err := db.ListByTTLBucket(); err != nil || buckets[0].Count != 1 {
+		t.Errorf("expected the cert in the expired bucket, got %v %v", buckets, err)
+	}
+}
+
+// TestCreateTTL checks a create can ask for its own lifetime within the configured bounds, and is rejected outside them.
+func TestCreateTTL(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDBWithConfig(fake, Config{MaxRequestTTL: time.Hour})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	db.now = func() time.Time { return now }
+	create := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest("/certcreate/fanatics.com"+query))
+		return w
+	}
+
+	if w := create("?ttl=30m"); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	if body, _, until, _ := db.retrieve(context.Background(), "fanatics.com"); !until.Equal(now.Add(time.Minute*30)) {
+		t.Errorf("expected the cert to be trusted for 30 minutes, got %s", body)
+	}
+	create("")
+	if expires, _, _ := db.GetCert("fanatics.com"); !expires.Equal(now.Add(defaultTTL)) {
+		t.Errorf("expected a create without a ttl to use the TTL, got %v", expires)
+	}
+
+	for _, query := range []string{"?ttl=soon", "?ttl=30s", "?ttl=2h", "?ttl=-5m"} {
+		if w := create(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+	if n := fake.count("EXEC"); n != 2 {
+		t.Errorf("expected rejected ttls not to write, got %d writes", n)
+	}
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), MinRequestTTL: time.Hour, MaxRequestTTL: time.Minute}); err == nil {
+		t.Error("expected a maximum below the minimum to be rejected")
+	}
+}
+
+// TestConcurrentCreateTTL checks a create asking for another lifetime waits for the running create instead of sharing its cert.
+func TestConcurrentCreateTTL(t *testing.T) {
+	group := newCreateGroup()
+	running := make(chan struct{})
+	release := make(chan struct{})
+	go group.do("fanatics.com", 0, nil, nil, createOrRenew, func() (*x509.Certificate, bool, error) {
+		close(running)
+		<-release
+		return &x509.Certificate{NotAfter: time.Unix(1, 0)}, true, nil
+	})
+	<-running
+	time.AfterFunc(time.Millisecond*20, func() { close(release) })
+	cert, created, _ := group.do("fanatics.com", time.Hour, nil, nil, createOrRenew, func() (*x509.Certificate, bool, error) {
+		return &x509.Certificate{NotAfter: time.Unix(2, 0)}, false, nil
+	})
+	if cert.NotAfter.Unix() != 2 || created {
+		t.Errorf("expected a cert of its own, got one expiring %v", cert.NotAfter)
+	}
+}
+
+// TestSerialNumber checks retrieval reports the serial of the stored cert, and that renewal changes it.
+func TestSerialNumber(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	serials := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		cert, err := db.createCert(context.Background(), "fanatics.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial := serialNumber(cert)
+		if serials[serial] {
+			t.Errorf("expected a new serial on renewal, %s was reused", serial)
+		}
+		serials[serial] = true
+		if stored, err := db.getCert(context.Background(), "fanatics.com"); err != nil || serialNumber(stored) != serial {
+			t.Errorf("expected the stored cert to have serial %s, got %v", serial, err)
+		}
+		if body, _, _, _ := db.retrieve(context.Background(), "fanatics.com"); !strings.HasSuffix(body, ", serial "+serial) {
+			t.Errorf("expected the response to carry serial %s, got %s", serial, body)
+		}
+	}
+}
+
+// TestEncodeExpiry round trips expiry dates through every version of the encoding, and the legacy unversioned form.
+func TestEncodeExpiry(t *testing.T) {
+	zone := time.FixedZone("EST", -5*60*60)
+	expires := time.Date(2024, 1, 1, 12, 0, 0, 123456789, zone)
+	issuedAt := time.Date(2024, 1, 1, 16, 50, 0, 987654321, time.UTC)
+
+	meta := map[string]string{"owner": "alice@example.com", "tags": "web edge"}
+	v4 := encode(expires, issuedAt, meta)
+	if v4[0] != expiryV4 || string(v4[1:]) != `2024-01-01T12:00:00.123456789-05:00 2024-01-01T16:50:00.987654321Z {"owner":"alice@example.com","tags":"web edge"}` {
+		t.Errorf("unexpected version 4 encoding %q", v4)
+	}
+	got, gotIssued, gotMeta, err := decode(v4)
+	if err != nil || !got.Equal(expires) || !gotIssued.Equal(issuedAt) || !maps.Equal(gotMeta, meta) {
+		t.Errorf("expected %v issued %v with %v, got %v %v %v %v", expires, issuedAt, meta, got, gotIssued, gotMeta, err)
+	}
+
+	// without metadata the value is still written in version 3
+	v3 := encode(expires, issuedAt, nil)
+	if v3[0] != expiryV3 || string(v3[1:]) != "2024-01-01T12:00:00.123456789-05:00 2024-01-01T16:50:00.987654321Z" {
+		t.Errorf("unexpected version 3 encoding %q", v3)
+	}
+	got, gotIssued, gotMeta, err = decode(v3)
+	if err != nil || !got.Equal(expires) || got.Format(time.RFC3339) != "2024-01-01T12:00:00-05:00" || !gotIssued.Equal(issuedAt) || gotMeta != nil {
+		t.Errorf("expected %v with its zone, issued %v, got %v %v %v %v", expires, issuedAt, got, gotIssued, gotMeta, err)
+	}
+	// a cert whose issue time isn't known round trips as zero
+	if _, gotIssued, _, err := decode(encode(expires, time.Time{}, nil)); err != nil || !gotIssued.IsZero() {
+		t.Errorf("expected a zero issue time, got %v %v", gotIssued, err)
+	}
+
+	v2 := encodeVersion(expires, expiryV2)
+	if v2[0] != expiryV2 || string(v2[1:]) != "2024-01-01T12:00:00.123456789-05:00" {
+		t.Errorf("unexpected version 2 encoding %q", v2)
+	}
+	if got, gotIssued, _, err := decode(v2); err != nil || !got.Equal(expires) || got.Format(time.RFC3339) != "2024-01-01T12:00:00-05:00" || !gotIssued.IsZero() {
+		t.Errorf("expected %v with its zone and no issue time, got %v %v %v", expires, got, gotIssued, err)
+	}
+
+	v1 := encodeVersion(expires, expiryV1)
+	if len(v1) != 9 || v1[0] != expiryV1 {
+		t.Errorf("unexpected version 1 encoding %v", v1)
+	}
+	// version 1 only keeps whole seconds
+	if got, gotIssued, _, err := decode(v1); err != nil || !got.Equal(expires.Truncate(time.Second)) || !gotIssued.IsZero() {
+		t.Errorf("expected %v, got %v %v %v", expires.Truncate(time.Second), got, gotIssued, err)
+	}
+}
+
+// TestDecodeCorrupt checks truncated and corrupted expiry dates are errors rather than panics, and reach the caller.
+func TestDecodeCorrupt(t *testing.T) {
+	expires := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	legacy := make([]byte, 8)
+	binary.BigEndian.PutUint64(legacy, uint64(expires.Unix()))
+	if got, _, _, err := decode(legacy); err != nil || !got.Equal(expires) {
+		t.Errorf("expected the 8 byte legacy form to decode to %v, got %v %v", expires, got, err)
+	}
+	for _, corrupt := range [][]byte{{}, {0, 0, 1}, {expiryV1, 0, 0, 0, 0, 0, 0}, {expiryV2, 'x'}, {expiryV3, 'x'}, append(encodeVersion(expires, expiryV3), " x"...), append(encode(expires, expires, nil), " {}"...), append(encodeVersion(expires, expiryV4), " 2024-01-01T12:00:00Z"...), append(encodeVersion(expires, expiryV4), " 2024-01-01T12:00:00Z {"...), {9, 9, 9, 9, 9, 9, 9, 9, 9}} {
+		if _, _, _, err := decode(corrupt); !errors.Is(err, errCorruptExpiry) {
+			t.Errorf("expected %v to be rejected, got %v", corrupt, err)
+		}
+	}
+
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	fake.set("Domain", "fanatics.com", []byte{0, 0, 1})
+	if _, err := db.getCert(context.Background(), "fanatics.com"); !errors.Is(err, errCorruptExpiry) {
+		t.Errorf("expected getCert to report the corrupt expiry, got %v", err)
+	}
+	if _, err := db.ListCerts(); !errors.Is(err, errCorruptExpiry) {
+		t.Errorf("expected listing to report the corrupt expiry, got %v", err)
+	}
+}
+
+// TestOnRenew checks the hook hears of user creates, batch creates and the server's own renewal, without blocking them.
+func TestOnRenew(t *testing.T) {
+	type renewal struct {
+		domain  string
+		expires time.Time
+	}
+	// unbuffered, so a create that waited on the hook would never return
+	renewals := make(chan renewal)
+	db := newFakeDBWithConfig(newFakeRedis(), Config{OnRenew: func(domain string, expires time.Time) {
+		renewals <- renewal{domain, expires}
+	}})
+
+	db.httpHandler(httptest.NewRecorder(), newRequest("/certcreate/fanatics.com"))
+	db.httpHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/certcreate", strings.NewReader(`["example.com"]`)))
+	db.renewCertServer(0)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-renewals:
+			if r.expires.Before(time.Now()) {
+				t.Errorf("%s: unexpected expiry %v", r.domain, r.expires)
+			}
+			seen[r.domain] = true
+		case <-time.After(time.Second * 5):
+			t.Fatalf("expected 3 renewals, heard of %v", seen)
+		}
+	}
+	for _, domain := range []string{"fanatics.com", "example.com", "certserver.fan"} {
+		if !seen[domain] {
+			t.Errorf("the hook didn't hear of %s", domain)
+		}
+	}
+}
+
+// TestConcurrentCreate checks simultaneous creates of a domain share one cert, and a failed create releases the domain.
+func TestConcurrentCreate(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	// hold the first write until every create has started
+	release := make(chan struct{})
+	var once sync.Once
+	fake.fail = func(cmd string) error {
+		if cmd == "EXEC" {
+			once.Do(func() { <-release })
+		}
+		return nil
+	}
+
+	const creates = 5
+	certs := make(chan *x509.Certificate, creates)
+	var started, wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			cert, err := db.createCert(context.Background(), "fanatics.com")
+			if err != nil {
+				t.Error(err)
+			}
+			certs <- cert
+		}()
+	}
+	started.Wait()
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+	wg.Wait()
+	close(certs)
+
+	var serial *big.Int
+	for cert := range certs {
+		if serial == nil {
+			serial = cert.SerialNumber
+		} else if cert.SerialNumber.Cmp(serial) != 0 {
+			t.Errorf("expected every create to share one cert")
+		}
+	}
+	if n := fake.count("EXEC"); n != 1 {
+		t.Errorf("expected a single write, got %d", n)
+	}
+
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err == nil {
+		t.Fatal("expected the create to fail")
+	}
+	fake.fail = nil
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Errorf("a failed create should release the domain: %v", err)
+	}
+}
+
+// TestRetrieveJSON checks ?format=json reports a cert trusted, then expired as the clock passes its expiry, and a missing one 404.
+func TestRetrieveJSON(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	db.now = func() time.Time { return now }
+	retrieve := func(path string) (int, retrieveResult) {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest(path))
+		var result retrieveResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return w.Code, result
+	}
+
+	cert, err := db.createCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, result := retrieve("/cert/fanatics.com?format=json")
+	if code != http.StatusOK || result.Status != "trusted" || !result.ExpiresAt.Equal(now.Add(defaultTTL)) || !result.IssuedAt.Equal(now) || result.Serial != serialNumber(cert) || result.ExpiresIn == nil || *result.ExpiresIn != int64(defaultTTL/time.Second) {
+		t.Errorf("expected a trusted cert, got %d %+v", code, result)
+	}
+
+	now = now.Add(defaultTTL + time.Second)
+	if code, result := retrieve("/cert/fanatics.com?format=json"); code != http.StatusOK || result.Status != "expired" || result.ExpiresAt == nil || result.ExpiresIn == nil || *result.ExpiresIn != -1 {
+		t.Errorf("expected an expired cert, got %d %+v", code, result)
+	}
+	if code, result := retrieve("/cert/missing.com?format=json"); code != http.StatusNotFound || result.Status != "not_found" || result.ExpiresAt != nil || result.ExpiresIn != nil {
+		t.Errorf("expected a missing cert, got %d %+v", code, result)
+	}
+	if code, result := retrieve("/cert/bad_domain?format=json"); code != http.StatusBadRequest || result.Status != "invalid" || len(result.Reasons) != 2 {
+		t.Errorf("expected an invalid domain, got %d %+v", code, result)
+	}
+
+	w := httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert/fanatics.com?format=xml"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown format rejected, got %d", w.Code)
+	}
+}
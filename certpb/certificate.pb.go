@@ -0,0 +1,451 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: certificate.proto
+
+package certpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CreateCertRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Domain        string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateCertRequest) Reset() {
+	*x = CreateCertRequest{}
+	mi := &file_certificate_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateCertRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateCertRequest) ProtoMessage() {}
+
+func (x *CreateCertRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_certificate_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateCertRequest.ProtoReflect.Descriptor instead.
+func (*CreateCertRequest) Descriptor() ([]byte, []int) {
+	return file_certificate_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CreateCertRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+type GetCertRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Domain        string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCertRequest) Reset() {
+	*x = GetCertRequest{}
+	mi := &file_certificate_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCertRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCertRequest) ProtoMessage() {}
+
+func (x *GetCertRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_certificate_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCertRequest.ProtoReflect.Descriptor instead.
+func (*GetCertRequest) Descriptor() ([]byte, []int) {
+	return file_certificate_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetCertRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+type DeleteCertRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Domain        string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteCertRequest) Reset() {
+	*x = DeleteCertRequest{}
+	mi := &file_certificate_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteCertRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteCertRequest) ProtoMessage() {}
+
+func (x *DeleteCertRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_certificate_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteCertRequest.ProtoReflect.Descriptor instead.
+func (*DeleteCertRequest) Descriptor() ([]byte, []int) {
+	return file_certificate_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DeleteCertRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+type DeleteCertResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteCertResponse) Reset() {
+	*x = DeleteCertResponse{}
+	mi := &file_certificate_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteCertResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteCertResponse) ProtoMessage() {}
+
+func (x *DeleteCertResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_certificate_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteCertResponse.ProtoReflect.Descriptor instead.
+func (*DeleteCertResponse) Descriptor() ([]byte, []int) {
+	return file_certificate_proto_rawDescGZIP(), []int{3}
+}
+
+type PingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingRequest) Reset() {
+	*x = PingRequest{}
+	mi := &file_certificate_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingRequest) ProtoMessage() {}
+
+func (x *PingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_certificate_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingRequest.ProtoReflect.Descriptor instead.
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return file_certificate_proto_rawDescGZIP(), []int{4}
+}
+
+type PingResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingResponse) Reset() {
+	*x = PingResponse{}
+	mi := &file_certificate_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingResponse) ProtoMessage() {}
+
+func (x *PingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_certificate_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingResponse.ProtoReflect.Descriptor instead.
+func (*PingResponse) Descriptor() ([]byte, []int) {
+	return file_certificate_proto_rawDescGZIP(), []int{5}
+}
+
+type Cert struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Domain        string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	CoveredBy     string                 `protobuf:"bytes,2,opt,name=covered_by,json=coveredBy,proto3" json:"covered_by,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Serial        string                 `protobuf:"bytes,4,opt,name=serial,proto3" json:"serial,omitempty"`
+	CertPem       []byte                 `protobuf:"bytes,5,opt,name=cert_pem,json=certPem,proto3" json:"cert_pem,omitempty"`
+	Revoked       bool                   `protobuf:"varint,6,opt,name=revoked,proto3" json:"revoked,omitempty"`
+	Trusted       bool                   `protobuf:"varint,7,opt,name=trusted,proto3" json:"trusted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Cert) Reset() {
+	*x = Cert{}
+	mi := &file_certificate_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Cert) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cert) ProtoMessage() {}
+
+func (x *Cert) ProtoReflect() protoreflect.Message {
+	mi := &file_certificate_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Cert.ProtoReflect.Descriptor instead.
+func (*Cert) Descriptor() ([]byte, []int) {
+	return file_certificate_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Cert) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *Cert) GetCoveredBy() string {
+	if x != nil {
+		return x.CoveredBy
+	}
+	return ""
+}
+
+func (x *Cert) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *Cert) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *Cert) GetCertPem() []byte {
+	if x != nil {
+		return x.CertPem
+	}
+	return nil
+}
+
+func (x *Cert) GetRevoked() bool {
+	if x != nil {
+		return x.Revoked
+	}
+	return false
+}
+
+func (x *Cert) GetTrusted() bool {
+	if x != nil {
+		return x.Trusted
+	}
+	return false
+}
+
+var File_certificate_proto protoreflect.FileDescriptor
+
+const file_certificate_proto_rawDesc = "" +
+	"\n" +
+	"\x11certificate.proto\x12\x12certificateservice\x1a\x1fgoogle/protobuf/timestamp.proto\"+\n" +
+	"\x11CreateCertRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\"(\n" +
+	"\x0eGetCertRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\"+\n" +
+	"\x11DeleteCertRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\"\x14\n" +
+	"\x12DeleteCertResponse\"\r\n" +
+	"\vPingRequest\"\x0e\n" +
+	"\fPingResponse\"\xdf\x01\n" +
+	"\x04Cert\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\x12\x1d\n" +
+	"\n" +
+	"covered_by\x18\x02 \x01(\tR\tcoveredBy\x129\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12\x16\n" +
+	"\x06serial\x18\x04 \x01(\tR\x06serial\x12\x19\n" +
+	"\bcert_pem\x18\x05 \x01(\fR\acertPem\x12\x18\n" +
+	"\arevoked\x18\x06 \x01(\bR\arevoked\x12\x18\n" +
+	"\atrusted\x18\a \x01(\bR\atrusted2\xd4\x02\n" +
+	"\x12CertificateService\x12M\n" +
+	"\n" +
+	"CreateCert\x12%.certificateservice.CreateCertRequest\x1a\x18.certificateservice.Cert\x12G\n" +
+	"\aGetCert\x12\".certificateservice.GetCertRequest\x1a\x18.certificateservice.Cert\x12[\n" +
+	"\n" +
+	"DeleteCert\x12%.certificateservice.DeleteCertRequest\x1a&.certificateservice.DeleteCertResponse\x12I\n" +
+	"\x04Ping\x12\x1f.certificateservice.PingRequest\x1a .certificateservice.PingResponseB\x1bZ\x19CertificateService/certpbb\x06proto3"
+
+var (
+	file_certificate_proto_rawDescOnce sync.Once
+	file_certificate_proto_rawDescData []byte
+)
+
+func file_certificate_proto_rawDescGZIP() []byte {
+	file_certificate_proto_rawDescOnce.Do(func() {
+		file_certificate_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_certificate_proto_rawDesc), len(file_certificate_proto_rawDesc)))
+	})
+	return file_certificate_proto_rawDescData
+}
+
+var file_certificate_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_certificate_proto_goTypes = []any{
+	(*CreateCertRequest)(nil),     // 0: certificateservice.CreateCertRequest
+	(*GetCertRequest)(nil),        // 1: certificateservice.GetCertRequest
+	(*DeleteCertRequest)(nil),     // 2: certificateservice.DeleteCertRequest
+	(*DeleteCertResponse)(nil),    // 3: certificateservice.DeleteCertResponse
+	(*PingRequest)(nil),           // 4: certificateservice.PingRequest
+	(*PingResponse)(nil),          // 5: certificateservice.PingResponse
+	(*Cert)(nil),                  // 6: certificateservice.Cert
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_certificate_proto_depIdxs = []int32{
+	7, // 0: certificateservice.Cert.expires_at:type_name -> google.protobuf.Timestamp
+	0, // 1: certificateservice.CertificateService.CreateCert:input_type -> certificateservice.CreateCertRequest
+	1, // 2: certificateservice.CertificateService.GetCert:input_type -> certificateservice.GetCertRequest
+	2, // 3: certificateservice.CertificateService.DeleteCert:input_type -> certificateservice.DeleteCertRequest
+	4, // 4: certificateservice.CertificateService.Ping:input_type -> certificateservice.PingRequest
+	6, // 5: certificateservice.CertificateService.CreateCert:output_type -> certificateservice.Cert
+	6, // 6: certificateservice.CertificateService.GetCert:output_type -> certificateservice.Cert
+	3, // 7: certificateservice.CertificateService.DeleteCert:output_type -> certificateservice.DeleteCertResponse
+	5, // 8: certificateservice.CertificateService.Ping:output_type -> certificateservice.PingResponse
+	5, // [5:9] is the sub-list for method output_type
+	1, // [1:5] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_certificate_proto_init() }
+func file_certificate_proto_init() {
+	if File_certificate_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_certificate_proto_rawDesc), len(file_certificate_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_certificate_proto_goTypes,
+		DependencyIndexes: file_certificate_proto_depIdxs,
+		MessageInfos:      file_certificate_proto_msgTypes,
+	}.Build()
+	File_certificate_proto = out.File
+	file_certificate_proto_goTypes = nil
+	file_certificate_proto_depIdxs = nil
+}
@@ -0,0 +1,100 @@
+package CertificateService
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*
+clientIPs finds the address a request came from, for everything keyed by client IP: the rate
+limits and the access log. X-Forwarded-For is only believed when the request arrived from a
+trusted proxy, so a client can't pick its own address by sending the header itself.
+*/
+type clientIPs struct {
+	// the networks of the trusted proxies
+	trusted []*net.IPNet
+	// whether every peer is trusted, as TrustForwardedFor asks
+	any bool
+}
+
+// newClientIPs returns how clients are identified for cfg, whose TrustedProxies were validated.
+func newClientIPs(cfg Config) *clientIPs {
+	trusted, _ := parseTrustedProxies(cfg.TrustedProxies)
+	return &clientIPs{trusted: trusted, any: cfg.TrustForwardedFor}
+}
+
+/*
+parseTrustedProxies parses proxies, each a CIDR, "10.0.0.0/8", or a single address, "10.0.0.1",
+into the networks they cover.
+*/
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, expected a CIDR or an IP address", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected a CIDR or an IP address", proxy)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+/*
+of returns the address r came from. That is the peer, r.RemoteAddr, unless the peer is a
+trusted proxy. Then X-Forwarded-For is walked back from the address the proxy added, past
+any further trusted proxies, to the first address that isn't one: the client. The addresses
+before it are whatever the client claimed and can't be trusted.
+*/
+func (c *clientIPs) of(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !c.any && !c.isTrusted(peer) {
+		return peer
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			break
+		}
+		client = hop
+		if !c.isTrusted(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// isTrusted reports whether ip is in the network of a trusted proxy.
+func (c *clientIPs) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range c.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
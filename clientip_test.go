@@ -0,0 +1,65 @@
+package CertificateService
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestClientIP checks X-Forwarded-For is only used when the peer is trusted, and then only past the trusted proxies.
+func TestClientIP(t *testing.T) {
+	request := func(remoteAddr string, forwardedFor ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/cert/fanatics.com", nil)
+		r.RemoteAddr = remoteAddr
+		for _, header := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		return r
+	}
+	proxies := newClientIPs(Config{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}})
+	tests := []struct {
+		name string
+		ips  *clientIPs
+		r    *http.Request
+		want string
+	}{
+		{"untrusted by default", newClientIPs(Config{}), request("192.0.2.1:1234", "203.0.113.9, 198.51.100.7"), "192.0.2.1"},
+		{"every peer trusted", newClientIPs(Config{TrustForwardedFor: true}), request("192.0.2.1:1234", "203.0.113.9, 198.51.100.7"), "198.51.100.7"},
+		{"trusted peer", proxies, request("192.0.2.1:1234", "203.0.113.9, 198.51.100.7"), "198.51.100.7"},
+		{"untrusted peer", proxies, request("198.51.100.50:1234", "203.0.113.9"), "198.51.100.50"},
+		{"chain of proxies", proxies, request("10.0.0.1:1234", "203.0.113.9, 198.51.100.7, 10.1.2.3", "10.0.0.2"), "198.51.100.7"},
+		{"no header", proxies, request("10.0.0.1:1234"), "10.0.0.1"},
+		{"only proxies", proxies, request("10.0.0.1:1234", "10.0.0.3"), "10.0.0.3"},
+	}
+	for _, test := range tests {
+		if ip := test.ips.of(test.r); ip != test.want {
+			t.Errorf("%s: expected %s, got %s", test.name, test.want, ip)
+		}
+	}
+
+	for _, proxy := range []string{"10.0.0.0/33", "proxy.internal", ""} {
+		if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), TrustedProxies: []string{proxy}}); err == nil || !strings.Contains(err.Error(), "trusted proxy") {
+			t.Errorf("expected %q to be rejected, got %v", proxy, err)
+		}
+	}
+}
+
+// TestClientIPRateLimit checks the rate limit keys off the client behind a trusted proxy, not the proxy.
+func TestClientIPRateLimit(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{RetrieveRateLimit: 0.1, RetrieveRateBurst: 1, TrustedProxies: []string{"10.0.0.0/8"}})
+	send := func(client string) int {
+		r := newRequest("/cert/fanatics.com")
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return w.Code
+	}
+	if send("203.0.113.9") != 200 || send("198.51.100.7") != 200 {
+		t.Error("expected two clients behind the proxy to have a burst each")
+	}
+	if code := send("203.0.113.9"); code != 429 {
+		t.Errorf("expected the first client limited, got %d", code)
+	}
+}
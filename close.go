@@ -0,0 +1,184 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServiceClosed is returned by every call to a CertificateService after it has been closed.
+var ErrServiceClosed = errors.New("certificate service closed")
+
+/*
+closableStorage is the Storage of a service, failing every call with ErrServiceClosed once the
+service is closed rather than reaching for a closed redis pool.
+*/
+type closableStorage struct {
+	Storage
+	closed atomic.Bool
+}
+
+func (c *closableStorage) Set(ctx context.Context, domain string, rec Record) (bool, error) {
+	if c.closed.Load() {
+		return false, ErrServiceClosed
+	}
+	return c.Storage.Set(ctx, domain, rec)
+}
+
+func (c *closableStorage) Get(ctx context.Context, domain string) (Record, error) {
+	if c.closed.Load() {
+		return Record{}, ErrServiceClosed
+	}
+	return c.Storage.Get(ctx, domain)
+}
+
+func (c *closableStorage) SetMany(ctx context.Context, recs map[string]Record) (map[string]bool, map[string]error) {
+	if c.closed.Load() {
+		errs := make(map[string]error, len(recs))
+		for domain := range recs {
+			errs[domain] = ErrServiceClosed
+		}
+		return nil, errs
+	}
+	return c.Storage.SetMany(ctx, recs)
+}
+
+func (c *closableStorage) GetMany(ctx context.Context, domains []string) (map[string]Record, error) {
+	if c.closed.Load() {
+		return nil, ErrServiceClosed
+	}
+	return c.Storage.GetMany(ctx, domains)
+}
+
+func (c *closableStorage) Delete(ctx context.Context, domain string) (bool, error) {
+	if c.closed.Load() {
+		return false, ErrServiceClosed
+	}
+	return c.Storage.Delete(ctx, domain)
+}
+
+func (c *closableStorage) List(ctx context.Context) (map[string]time.Time, error) {
+	if c.closed.Load() {
+		return nil, ErrServiceClosed
+	}
+	return c.Storage.List(ctx)
+}
+
+func (c *closableStorage) Count(ctx context.Context) (int, error) {
+	if c.closed.Load() {
+		return 0, ErrServiceClosed
+	}
+	return c.Storage.Count(ctx)
+}
+
+func (c *closableStorage) Scan(ctx context.Context, cursor int, count int) (int, []CertInfo, error) {
+	if c.closed.Load() {
+		return 0, nil, ErrServiceClosed
+	}
+	return c.Storage.Scan(ctx, cursor, count)
+}
+
+func (c *closableStorage) Flush(ctx context.Context) (int, error) {
+	if c.closed.Load() {
+		return 0, ErrServiceClosed
+	}
+	return c.Storage.Flush(ctx)
+}
+
+func (c *closableStorage) Ping(ctx context.Context) error {
+	if c.closed.Load() {
+		return ErrServiceClosed
+	}
+	return c.Storage.Ping(ctx)
+}
+
+func (c *closableStorage) Revoke(ctx context.Context, serial string, expires time.Time) error {
+	if c.closed.Load() {
+		return ErrServiceClosed
+	}
+	return c.Storage.Revoke(ctx, serial, expires)
+}
+
+func (c *closableStorage) Revoked(ctx context.Context, serials []string) (map[string]bool, error) {
+	if c.closed.Load() {
+		return nil, ErrServiceClosed
+	}
+	return c.Storage.Revoked(ctx, serials)
+}
+
+func (c *closableStorage) AddHistory(ctx context.Context, domain string, entry HistoryEntry, depth int) error {
+	if c.closed.Load() {
+		return ErrServiceClosed
+	}
+	return c.Storage.AddHistory(ctx, domain, entry, depth)
+}
+
+func (c *closableStorage) History(ctx context.Context, domain string) ([]HistoryEntry, error) {
+	if c.closed.Load() {
+		return nil, ErrServiceClosed
+	}
+	return c.Storage.History(ctx, domain)
+}
+
+func (c *closableStorage) SaveIdempotent(ctx context.Context, key string, result IdempotentResult, expires time.Time) error {
+	if c.closed.Load() {
+		return ErrServiceClosed
+	}
+	return c.Storage.SaveIdempotent(ctx, key, result, expires)
+}
+
+func (c *closableStorage) LoadIdempotent(ctx context.Context, key string) (IdempotentResult, bool, error) {
+	if c.closed.Load() {
+		return IdempotentResult{}, false, ErrServiceClosed
+	}
+	return c.Storage.LoadIdempotent(ctx, key)
+}
+
+/*
+Close shuts the http server down, stops renewing the server certificate and the other
+background work, and closes the Storage if it has a Close method, as the redis Storage does
+to release its pool. Every later call fails with ErrServiceClosed, including a second Close.
+*/
+func (db *dbConn) Close() error {
+	db.closeMu.Lock()
+	if !db.store.closed.CompareAndSwap(false, true) {
+		db.closeMu.Unlock()
+		return ErrServiceClosed
+	}
+	if db.renewTimer != nil {
+		db.renewTimer.Stop()
+	}
+	closers := db.closers
+	db.closers = nil
+	db.closeMu.Unlock()
+
+	for _, close := range closers {
+		close()
+	}
+	if closer, ok := db.store.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// onClose registers fn to be called by Close, and reports false if the service is already closed.
+func (db *dbConn) onClose(fn func()) bool {
+	db.closeMu.Lock()
+	defer db.closeMu.Unlock()
+	if db.store.closed.Load() {
+		return false
+	}
+	db.closers = append(db.closers, fn)
+	return true
+}
+
+// renewAfter renews the server certificate after d, unless the service is closed first.
+func (db *dbConn) renewAfter(d time.Duration, renew func()) {
+	db.closeMu.Lock()
+	defer db.closeMu.Unlock()
+	if !db.store.closed.Load() {
+		db.renewTimer = time.AfterFunc(d, renew)
+	}
+}
@@ -0,0 +1,91 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestClose uses a service, closes it, and checks no redis connections remain and every later call fails clearly.
+func TestClose(t *testing.T) {
+	fake := newFakeRedis()
+	pool := newFakePool(fake)
+	pool.MaxIdle = 4
+	svc, err := NewCertificateServiceWithConfig(Config{Storage: NewRedisStorage(pool, HashLayout)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := svc.(*dbConn)
+	db.renewCertServer(0)
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ListCerts(); err != nil {
+		t.Fatal(err)
+	}
+	if pool.IdleCount() == 0 {
+		t.Fatal("expected the pool to hold idle connections")
+	}
+
+	if err := svc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if active, idle := pool.ActiveCount(), pool.IdleCount(); active != 0 || idle != 0 {
+		t.Errorf("expected no connections after Close, got %d active, %d idle", active, idle)
+	}
+	db.renewTimer.Stop()
+	if db.renewTimer.Stop() {
+		t.Error("the server certificate renewal is still scheduled")
+	}
+
+	if _, err := db.ListCerts(); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("expected ErrServiceClosed listing certs, got %v", err)
+	}
+	if _, err := db.createCert(context.Background(), "fanatics.com"); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("expected ErrServiceClosed creating a cert, got %v", err)
+	}
+	if _, err := db.MigrateToKeyLayout(); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("expected ErrServiceClosed migrating, got %v", err)
+	}
+	if err := db.OpenHTTPServer(); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("expected ErrServiceClosed opening the server, got %v", err)
+	}
+	if db.PingRedis(context.Background()) {
+		t.Error("a closed service shouldn't report redis alive")
+	}
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, newRequest("/cert/fanatics.com"))
+	if !strings.Contains(rec.Body.String(), ErrServiceClosed.Error()) {
+		t.Errorf("expected requests to report the service closed, got %s", rec.Body.String())
+	}
+	if err := db.Close(); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("expected a second Close to fail, got %v", err)
+	}
+}
+
+// TestDisableServerCert checks a service without a server certificate never issues or schedules one.
+func TestDisableServerCert(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDBWithConfig(fake, Config{DisableServerCert: true})
+	db.startBackground()
+	if n, err := db.Count(); n != 0 || err != nil {
+		t.Errorf("expected nothing stored, got %d %v", n, err)
+	}
+	db.closeMu.Lock()
+	timer := db.renewTimer
+	db.closeMu.Unlock()
+	if timer != nil {
+		t.Error("expected no renewal to be scheduled")
+	}
+
+	// the server domain is an ordinary domain
+	if kind := db.certKind("certserver.fan"); kind != "domain" {
+		t.Errorf("expected the server domain to count as a domain, got %s", kind)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), DisableServerCert: true, HTTPS: true}); err == nil {
+		t.Error("expected HTTPS without a server certificate to be rejected")
+	}
+}
@@ -0,0 +1,129 @@
+package CertificateService
+
+import (
+	"crypto/x509"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+createGroup makes sure only one create or renewal of a domain runs at a time. A create
+arriving while another for the same domain is running waits for it and shares its result,
+rather than generating a second cert and racing it into redis. Unlike idempotencyStore,
+nothing is remembered once the running create returns.
+*/
+type createGroup struct {
+	mu    sync.Mutex
+	calls map[string]*createCall
+}
+
+// createCall is a single running create, of a cert valid for ttl naming sans with meta in mode, and once done is closed its result.
+type createCall struct {
+	ttl     time.Duration
+	sans    []string
+	meta    map[string]string
+	mode    issueMode
+	done    chan struct{}
+	cert    *x509.Certificate
+	created bool
+	err     error
+	// held is set for a slot taken by hold, which has no result for a create to share.
+	held bool
+}
+
+func newCreateGroup() *createGroup {
+	return &createGroup{calls: make(map[string]*createCall)}
+}
+
+/*
+do runs fn for domain, to issue a cert valid for ttl naming sans with meta in mode, or waits for
+the fn already running for it and returns its result. A create asking for a different ttl, sans,
+meta or mode can't share that cert, so it waits its turn and runs after. The domain is released however fn returns, even if it panics.
+*/
+func (g *createGroup) do(domain string, ttl time.Duration, sans []string, meta map[string]string, mode issueMode, fn func() (*x509.Certificate, bool, error)) (*x509.Certificate, bool, error) {
+	g.mu.Lock()
+	for {
+		call, ok := g.calls[domain]
+		if !ok {
+			break
+		}
+		g.mu.Unlock()
+		<-call.done
+		if !call.held && call.ttl == ttl && slices.Equal(call.sans, sans) && maps.Equal(call.meta, meta) && call.mode == mode {
+			return call.cert, call.created, call.err
+		}
+		g.mu.Lock()
+	}
+	call := &createCall{ttl: ttl, sans: sans, meta: meta, mode: mode, done: make(chan struct{})}
+	g.calls[domain] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, domain)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.cert, call.created, call.err = fn()
+	return call.cert, call.created, call.err
+}
+
+// errCreateAbandoned is shared with the creates waiting on a slot acquire took that was released without a result.
+var errCreateAbandoned = errors.New("the create running for the domain failed")
+
+/*
+acquire takes the slot of every domain in domains, as do takes one to issue a cert valid for
+the certificate lifetime in mode, for creates that run together outside do, such as a batch
+written in a single round trip. It waits for the calls already running for them, taking the
+domains in sorted order so two batches sharing domains can't each hold one the other waits
+for. The caller sets the result of each call it returns, shared with the creates waiting on
+it as do's is, then hands them to release, on every path.
+*/
+func (g *createGroup) acquire(domains []string, mode issueMode) map[string]*createCall {
+	sorted := slices.Clone(domains)
+	slices.Sort(sorted)
+	calls := make(map[string]*createCall, len(sorted))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, domain := range slices.Compact(sorted) {
+		for {
+			call, ok := g.calls[domain]
+			if !ok {
+				break
+			}
+			g.mu.Unlock()
+			<-call.done
+			g.mu.Lock()
+		}
+		call := &createCall{mode: mode, done: make(chan struct{}), err: errCreateAbandoned}
+		g.calls[domain] = call
+		calls[domain] = call
+	}
+	return calls
+}
+
+// release frees the slots acquire took, handing each call's result to the creates waiting on it.
+func (g *createGroup) release(calls map[string]*createCall) {
+	g.mu.Lock()
+	for domain := range calls {
+		delete(g.calls, domain)
+	}
+	g.mu.Unlock()
+	for _, call := range calls {
+		close(call.done)
+	}
+}
+
+/*
+hold takes the slot of domain for something other than a create, such as deleting its cert,
+waiting for the call running for it, and returns the func releasing it. The creates waiting
+on the slot run once it is released, there is no cert to share.
+*/
+func (g *createGroup) hold(domain string) (release func()) {
+	calls := g.acquire([]string{domain}, createOrRenew)
+	calls[domain].held = true
+	return func() { g.release(calls) }
+}
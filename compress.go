@@ -0,0 +1,173 @@
+package CertificateService
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+compression gzips the responses of clients that accept gzip once they reach minSize bytes,
+which in practice are the listings, exports and batch results. Anything smaller is sent as it
+is, as compressing it would cost more than it saves. A nil compression compresses nothing.
+*/
+type compression struct {
+	minSize int
+}
+
+// newCompression returns the compression for cfg, or nil if it is disabled.
+func newCompression(cfg Config) *compression {
+	if cfg.DisableCompression {
+		return nil
+	}
+	return &compression{minSize: cfg.CompressMinSize}
+}
+
+/*
+wrap returns next with its responses compressed for a client accepting gzip. The response is
+held back until minSize bytes are written or the handler returns, to decide whether to
+compress it. If the handler panics what was held back is dropped, so the panic can still be
+answered with a clean 500.
+*/
+func (c *compression) wrap(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on Accept-Encoding, whether or not this one is compressed
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, minSize: c.minSize}
+		next.ServeHTTP(gw, r)
+		gw.close()
+	})
+}
+
+// acceptsGzip reports whether r's Accept-Encoding lists gzip, without refusing it with q=0.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+/*
+gzipWriter is a ResponseWriter that buffers the response until it reaches minSize bytes, then
+compresses it, or sends it as it is if it never gets that big. The status is held back with
+it, as the headers can't be sent until Content-Encoding is known.
+*/
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	// gz is set once the response is being compressed, sent once it is being sent as it is
+	gz   *gzip.Writer
+	sent bool
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	switch {
+	case g.gz != nil:
+		return g.gz.Write(b)
+	case g.sent:
+		return g.ResponseWriter.Write(b)
+	}
+	g.buf = append(g.buf, b...)
+	if len(g.buf) >= g.minSize {
+		if err := g.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+/*
+start sends the headers and what was buffered, compressed unless the handler already encoded
+the response itself or it is a status without a body.
+*/
+func (g *gzipWriter) start() error {
+	h := g.Header()
+	if h.Get("Content-Encoding") != "" || g.status == http.StatusNoContent || g.status == http.StatusNotModified {
+		return g.send()
+	}
+	if h.Get("Content-Type") == "" {
+		// sniffed from the body before it is compressed, which the server would sniff otherwise
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	h.Set("Content-Encoding", "gzip")
+	// the length of the uncompressed body no longer applies
+	h.Del("Content-Length")
+	g.writeHeader()
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// send sends the headers and what was buffered as it is, as will be everything written after.
+func (g *gzipWriter) send() error {
+	g.sent = true
+	g.writeHeader()
+	if len(g.buf) == 0 {
+		return nil
+	}
+	_, err := g.ResponseWriter.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// writeHeader sends the held back status, if the handler set one.
+func (g *gzipWriter) writeHeader() {
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+}
+
+// close ends the response once the handler returns, sending it as it is if it stayed small.
+func (g *gzipWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	} else if !g.sent {
+		g.send()
+	}
+}
+
+/*
+Flush sends what has been written so far, so streamed responses still stream. A response
+flushed before it reaches minSize is sent uncompressed.
+*/
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	} else if !g.sent {
+		g.send()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
@@ -0,0 +1,82 @@
+package CertificateService
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestCompression checks large responses are gzipped for clients that accept it, and small ones aren't.
+func TestCompression(t *testing.T) {
+	c := newCompression(Config{CompressMinSize: 100})
+	send := func(body string, acceptEncoding string) *httptest.ResponseRecorder {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			// written in pieces, so the threshold is crossed part way through
+			for i := 0; i < len(body); i += 30 {
+				io.WriteString(w, body[i:min(i+30, len(body))])
+			}
+		})
+		r := httptest.NewRequest("GET", "/certs", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		c.wrap(next).ServeHTTP(w, r)
+		return w
+	}
+
+	large := strings.Repeat("foo{fanatics.com} ", 20)
+	w := send(large, "deflate, gzip;q=0.5")
+	if w.Code != http.StatusAccepted || w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzipped 202, got %d %v", w.Code, w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(zr); err != nil || string(body) != large {
+		t.Errorf("expected the body to decompress, got %q %v", body, err)
+	}
+
+	for _, tc := range []struct{ name, body, acceptEncoding string }{
+		{"small", "foo{fanatics.com}", "gzip"},
+		{"not accepted", large, ""},
+		{"refused", large, "gzip;q=0"},
+	} {
+		w := send(tc.body, tc.acceptEncoding)
+		if w.Code != http.StatusAccepted || w.Header().Get("Content-Encoding") != "" || w.Body.String() != tc.body {
+			t.Errorf("%s: expected the body sent as it is, got %d %v", tc.name, w.Code, w.Header())
+		}
+	}
+}
+
+// TestCompressionList checks a large listing decompresses to the same certs.
+func TestCompressionList(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	for i := 0; i < 50; i++ {
+		db.httpHandler(httptest.NewRecorder(), newRequest("/certcreate/site"+strconv.Itoa(i)+".com"))
+	}
+	r := httptest.NewRequest("GET", "/certs", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	db.httpHandler(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a gzipped JSON listing, got %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs map[string]json.RawMessage
+	if err := json.NewDecoder(zr).Decode(&certs); err != nil || len(certs) != 50 {
+		t.Errorf("expected 50 certs, got %d %v", len(certs), err)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), CompressMinSize: -1}); err == nil {
+		t.Error("expected a negative threshold to be rejected")
+	}
+}
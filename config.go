@@ -0,0 +1,800 @@
+package CertificateService
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	//imported package, run go get go.opentelemetry.io/otel
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// defaultTTL is the certificate lifetime used when Config.TTL is unset.
+	defaultTTL = time.Minute * 10
+
+	// minTTL is the shortest certificate lifetime accepted.
+	minTTL = time.Minute
+
+	// defaultMaxRequestTTL is the longest lifetime a create may ask for with its ttl parameter.
+	defaultMaxRequestTTL = time.Hour * 24
+
+	/*
+		minRenewBuffer is the shortest time before expiry the server certificate may be
+		renewed at. Anything shorter leaves too small a window to complete the renewal,
+		including a retry or two, before the old certificate expires.
+	*/
+	minRenewBuffer = time.Second * 5
+
+	// defaultRenewJitter is the fraction of the renewal interval a renewal is moved by at most.
+	defaultRenewJitter = 0.1
+
+	// defaultRenewRetryBase is the first retry delay after a failed server certificate renewal.
+	defaultRenewRetryBase = time.Second
+
+	// defaultRenewRetryMax caps the retry delay after repeated renewal failures.
+	defaultRenewRetryMax = time.Second * 30
+
+	// defaultIdempotencyTTL is how long the result of an idempotent create is remembered.
+	defaultIdempotencyTTL = time.Minute * 5
+
+	// defaultRedisTimeout is the longest a single redis operation may take.
+	defaultRedisTimeout = time.Second * 5
+
+	// defaultRedisPollInterval is the first delay between pings of WaitForRedis.
+	defaultRedisPollInterval = time.Millisecond * 250
+
+	// defaultRedisPollMax caps the delay between pings of WaitForRedis.
+	defaultRedisPollMax = time.Second * 5
+
+	// defaultScanCount is how many certs are asked of the store per page of a listing.
+	defaultScanCount = 100
+
+	// defaultDialAttempts is how many times dialing redis is tried before a connection fails.
+	defaultDialAttempts = 3
+
+	// defaultDialRetryBase is the first delay between attempts to dial redis.
+	defaultDialRetryBase = time.Millisecond * 100
+
+	// defaultIdleTestThreshold is how long a pooled redis connection may sit idle before it is checked.
+	defaultIdleTestThreshold = time.Minute
+
+	// defaultMaxIdle and defaultMaxActive size the redis connection pool.
+	defaultMaxIdle   = 80
+	defaultMaxActive = 12000
+
+	// defaultIdleTimeout is how long an idle redis connection is kept before it is closed.
+	defaultIdleTimeout = time.Minute * 5
+
+	// defaultMaxBatchSize is the most domains a batch create may carry.
+	defaultMaxBatchSize = 100
+
+	// defaultSweepGrace is how long an expired cert is kept before the sweeper deletes it.
+	defaultSweepGrace = time.Hour
+
+	// defaultExpiringWindow is how soon a cert must expire to be counted by the expiring gauge.
+	defaultExpiringWindow = time.Minute * 5
+
+	// defaultExpiryWebhookThreshold is how close to expiring a cert is when the webhook is notified.
+	defaultExpiryWebhookThreshold = time.Minute * 2
+
+	// defaultExpiryWebhookInterval is how often certs are checked for the expiry webhook.
+	defaultExpiryWebhookInterval = time.Second * 30
+
+	// defaultRateBurst is how many requests a rate limited client may make at once.
+	defaultRateBurst = 10
+
+	// defaultCompressMinSize is the size from which a response is gzipped.
+	defaultCompressMinSize = 1024
+
+	// defaultListenAddr is the address OpenHTTPServer listens on when Config.ListenAddr is unset.
+	defaultListenAddr = ":8080"
+)
+
+// defaultTTLBuckets are the ListByTTLBucket boundaries used when Config.TTLBuckets is unset.
+var defaultTTLBuckets = []time.Duration{time.Minute, time.Minute * 5}
+
+/*
+Config holds the settings for a CertificateService created with
+NewCertificateServiceWithConfig. The zero value of each field selects its default.
+*/
+type Config struct {
+	// TTL is the lifetime of every certificate created by the service. Defaults to 10 minutes.
+	TTL time.Duration
+
+	// RenewBuffer is how long before expiry the server certificate is renewed. Defaults to 10% of TTL.
+	RenewBuffer time.Duration
+
+	/*
+		MinRequestTTL and MaxRequestTTL bound the lifetime a create may ask for in place of TTL,
+		with a ttl query parameter such as /certcreate/{domain}?ttl=30m. A ttl outside them is
+		rejected with 400 Bad Request. Default 1 minute, the shortest TTL allowed, and 24 hours.
+	*/
+	MinRequestTTL time.Duration
+	MaxRequestTTL time.Duration
+
+	/*
+		RenewRetryBase is the delay before retrying a failed renewal of the server certificate,
+		doubled after every further failure up to RenewRetryMax. Default 1 second and 30 seconds.
+	*/
+	RenewRetryBase time.Duration
+	RenewRetryMax  time.Duration
+
+	/*
+		StrictCreate makes a create over http or gRPC, batches included, fail for a domain that
+		already has a cert, with ErrDomainExists, answered 409 Conflict, so renewing takes
+		/certrenew and a client's intent is explicit. Off by default, a create of a stored
+		domain renews it. CreateCert always does either.
+	*/
+	StrictCreate bool
+
+	/*
+		RenewJitter moves every renewal of the server certificate earlier or later by a random
+		amount of up to this fraction of the renewal interval, so instances, or certs, created
+		together don't all renew at the same moment. A renewal is never moved later by more
+		than half RenewBuffer, so it still lands before the cert expires. Default 0.1, 10%;
+		DisableRenewJitter renews at exactly the interval.
+	*/
+	RenewJitter        float64
+	DisableRenewJitter bool
+
+	/*
+		IssueDelay is the delay the specification requires before a newly created cert may be
+		used. The request is never blocked by it; the create response reports the time the
+		cert becomes available instead. Only the first issuance for a domain is delayed,
+		renewals of a domain that already has a cert are available at once. Off by default.
+	*/
+	IssueDelay time.Duration
+
+	/*
+		TTLBuckets are the ascending boundaries ListByTTLBucket groups the remaining lifetime
+		of certificates by. Defaults to 1 and 5 minutes, giving <1m, 1m-5m and >=5m buckets.
+	*/
+	TTLBuckets []time.Duration
+
+	/*
+		IdempotencyTTL is how long the result of a create request carrying an Idempotency-Key
+		header is remembered and replayed to requests with the same key. Results are saved in
+		the Storage, so every instance sharing it replays them. Default 5 minutes.
+	*/
+	IdempotencyTTL time.Duration
+
+	/*
+		CacheControl adds a Cache-Control header to retrieve responses, so clients and
+		intermediaries can cache a trusted result until the cert expires. Expired and
+		unknown domains are sent with no-store.
+	*/
+	CacheControl bool
+
+	/*
+		AdminToken is the bearer token the admin endpoints, such as /admin/lifetime, require in
+		the Authorization header. The admin endpoints are disabled when it is empty.
+	*/
+	AdminToken string
+
+	/*
+		Storage is where certs are kept. Defaults to redis on localhost:6379, NewMemoryStorage
+		keeps them in memory instead. Closing the service closes the Storage too if it has a
+		Close method, as the redis Storage does.
+	*/
+	Storage Storage
+
+	// KeyLayout selects how certs are stored in the default redis Storage. Defaults to HashLayout.
+	KeyLayout KeyLayout
+
+	/*
+		MaxDomains caps how many domains are stored, to bound the memory they take in a shared
+		redis. Once it is reached a create of a new domain is handled as FullPolicy says,
+		rejected by default. Renewals always go through, as does the service's own cert, which
+		counts towards the cap once stored but is never evicted. The store is counted before
+		each new domain is written, and evicting walks every stored cert to find the one
+		expiring soonest. No cap by default.
+	*/
+	MaxDomains int
+	FullPolicy FullPolicy
+
+	/*
+		KeyType is the key generated for each cert, ECDSAP256 by default, or RSA2048 or RSA4096
+		for clients that need RSA. It only applies to certs created from then on; stored certs
+		keep their key until renewed. The algorithm and size of a cert's key are reported by a
+		retrieve with ?format=json and by batch retrieves.
+	*/
+	KeyType KeyType
+
+	/*
+		Namespace prefixes every key of the default redis Storage, "tenantA:Domain" for
+		"tenantA", so teams or environments sharing a redis keep their certs apart. Defaults to
+		no prefix, the keys used before namespaces existed.
+	*/
+	Namespace string
+
+	/*
+		RedisTimeout is the longest a single call to redis, or the configured Storage, may take
+		before it is abandoned, so a hung redis fails requests instead of wedging them. Requests
+		are also abandoned when the client goes away. Default 5 seconds.
+	*/
+	RedisTimeout time.Duration
+
+	/*
+		SlowRedisThreshold is how long a call to redis, or the configured Storage, may take
+		before it is logged as a warning with its operation and domain, to diagnose latency.
+		Every call is timed for the redis duration histogram either way. Zero, the default, logs
+		none.
+	*/
+	SlowRedisThreshold time.Duration
+
+	/*
+		RedisPollInterval is how long WaitForRedis waits after the first failed ping before
+		pinging again, doubled after every further failure up to RedisPollMax. Default 250
+		milliseconds and 5 seconds.
+	*/
+	RedisPollInterval time.Duration
+	RedisPollMax      time.Duration
+
+	/*
+		StartupTimeout has OpenHTTPServer and OpenGRPCServer wait up to that long for redis to
+		answer, with WaitForRedis, before serving, for a service started alongside its redis.
+		They fail without serving if it doesn't answer in time, or the service is closed while
+		they wait. Off by default, they serve straight away.
+	*/
+	StartupTimeout time.Duration
+
+	/*
+		ScanCount is how many certs are asked of the store per page when they are listed,
+		streamed or exported, the COUNT of each redis HSCAN, so every reply stays small however
+		many certs are stored. Larger pages take fewer round trips. Default 100.
+	*/
+	ScanCount int
+
+	/*
+		DialAttempts is how many times dialing redis is tried before the connection fails, so a
+		momentarily unavailable redis doesn't fail requests. The delay between attempts starts
+		at DialRetryBase and doubles after every failure. Default 3 attempts, 100 milliseconds.
+	*/
+	DialAttempts  int
+	DialRetryBase time.Duration
+
+	/*
+		RedisDatabase is the number of the redis database the certs are kept in, so they can
+		share a redis with other data. Every connection SELECTs it once dialed, and fails if
+		that does. Sentinels aren't affected. Default 0, the database redis starts in.
+	*/
+	RedisDatabase int
+
+	/*
+		RedisReadAddr is the address of a redis read replica, "replica:6379". When set, certs,
+		revocations and history are read from it, and only written to the master, so reads
+		don't load the master, at the cost of reads lagging writes by the replication delay.
+		The replica gets a pool of its own, sized, dialed and checked like the master's, and
+		the health check fails if either is down. Default none, everything goes to the master.
+	*/
+	RedisReadAddr string
+
+	/*
+		IdleTestThreshold is how long a pooled redis connection may sit idle before it is PINGed
+		on its way out of the pool. One that doesn't answer, say because redis restarted, is
+		discarded and a fresh connection dialed instead. Default 1 minute.
+	*/
+	IdleTestThreshold time.Duration
+
+	/*
+		MaxIdle and MaxActive cap the idle and total connections in the redis pool, and idle
+		connections are closed after IdleTimeout. Default 80 idle, or MaxActive if that is
+		smaller, 12000 total and 5 minutes.
+
+		Once MaxActive connections are in use a request waits for one to free up, for no longer
+		than RedisTimeout or its client stays, and is answered 503 "backend busy" if none does.
+		Waiting rides out a short burst at the cost of holding requests, and their goroutines,
+		while redis is slow. Set NoWait to fail such requests straight away instead.
+	*/
+	MaxIdle     int
+	MaxActive   int
+	IdleTimeout time.Duration
+	NoWait      bool
+
+	/*
+		SentinelAddrs are the Redis Sentinels watching SentinelMaster, the name of the master.
+		When set, the address of the current master is asked of the Sentinels before every
+		dial, so the service follows a failover. Off by default, redis is dialed on localhost.
+	*/
+	SentinelAddrs  []string
+	SentinelMaster string
+
+	/*
+		Dial, when set, dials the connections of the default redis storage in place of redis on
+		localhost, so tests can supply a double of redis.Conn, or embedders a connection of
+		their own. The pool still sizes and checks the connections, retries the dial and
+		selects RedisDatabase. It can't be combined with Sentinels, or a custom Storage.
+	*/
+	Dial func() (redis.Conn, error)
+
+	/*
+		UseTLS connects to redis, and any Sentinels, over TLS, as managed redis services
+		(rediss://) require. The server certificate is verified using TLSConfig, or the system
+		roots when it is nil. TLSSkipVerify turns verification off, for self-signed test setups
+		only. Off by default.
+	*/
+	UseTLS        bool
+	TLSConfig     *tls.Config
+	TLSSkipVerify bool
+
+	/*
+		HTTPS serves the http API over TLS, using the ServerDomain certificate the service
+		issues for itself. Renewals are picked up without a restart. Off by default, the API
+		is served over plain http.
+	*/
+	HTTPS bool
+
+	/*
+		ClientCAs turns on client certificate auth, mTLS, for the https and gRPC servers: client
+		certs are verified against this pool, and the create, renew, import, revoke and admin
+		routes refuse a request without a verified one with 403 Forbidden. ClientAuth is when a
+		cert is asked for, RequireAndVerifyClientCert, the default, refusing the handshake of
+		any client without one, or VerifyClientCertIfGiven, leaving the other routes open. The
+		verified cert's subject is recorded in the audit log. It requires HTTPS. Off by default.
+	*/
+	ClientCAs  *x509.CertPool
+	ClientAuth tls.ClientAuthType
+
+	/*
+		ListenAddr is the address OpenHTTPServer listens on, in the form net.Listen takes, such
+		as ":8443" or "127.0.0.1:8080". Defaults to ":8080".
+	*/
+	ListenAddr string
+
+	/*
+		BasePath is a path prefix, such as "/certsvc", every http route is served below, for a
+		reverse proxy that routes a path of its own to the service without stripping it. The
+		usage page and Location headers include it, and requests outside it are 404 Not Found.
+		Routes match case insensitively, so it is lowercased. Defaults to none, the routes at
+		the root.
+	*/
+	BasePath string
+
+	/*
+		RootPage replaces the usage page answered at "/", and at any path without a route of
+		its own, with HTML of the deployment's own. RootRedirect redirects there instead, with
+		302 Found, and DisableRootPage answers with an empty 200, for deployments that don't
+		advertise their API. At most one of them may be set. By default the usage page lists the
+		routes served, at the address the request was sent to, and which need an API key.
+	*/
+	RootPage        string
+	RootRedirect    string
+	DisableRootPage bool
+
+	/*
+		ServerDomain is the domain of the certificate the service issues and renews for its own
+		server, so a deployment can use a name of its own. It must be a valid domain. Defaults
+		to CERTSERVER.FAN.
+	*/
+	ServerDomain string
+
+	/*
+		DisableServerCert stops the service issuing and renewing a ServerDomain certificate for
+		itself, for embedders using it purely as a store of domain certs. No renewal is
+		scheduled and nothing is written on its behalf, and ServerDomain is then an ordinary
+		domain. It can't be combined with HTTPS, which serves that certificate.
+	*/
+	DisableServerCert bool
+
+	/*
+		Logger receives the service's errors, such as failed redis calls and renewals, which
+		are logged and returned rather than ending the process. Defaults to slog.Default().
+	*/
+	Logger *slog.Logger
+
+	/*
+		AuditLogger receives an audit event, at Info, for every create, delete and revoke that
+		succeeds: the action, the domain, when it happened, the client IP, the API key's
+		identifier when keys are required, the client cert's subject under ClientCAs, and the
+		serial of the cert created or revoked.
+		Actions the service takes on its own, such as sweeping, are recorded as by "service".
+		Point it at a sink of its own to keep the audit trail apart. Defaults to Logger.
+	*/
+	AuditLogger *slog.Logger
+
+	/*
+		AccessLogLevel is the level every http request is logged at through Logger, with its
+		method, path, client IP, status and duration. The query string is never logged.
+		DisableAccessLog turns the access log off, for example in tests. Default Info.
+	*/
+	AccessLogLevel   slog.Level
+	DisableAccessLog bool
+
+	// MaxBatchSize is the most domains a POST to /certcreate may carry. Default 100.
+	MaxBatchSize int
+
+	/*
+		SweepInterval is how often a serving service deletes the certs that expired more than
+		SweepGrace ago, so the store doesn't grow forever and listings aren't padded with
+		certs no one can use. The sweep stops when the service is closed. Redis evicts expired certs itself
+		in the PerDomainKeyLayout, leaving nothing to sweep. Off by default, grace 1 hour.
+
+		Each sweep also counts the certs expiring within ExpiringWindow from the same pass over
+		the store, exposed as the certservice_certs_expiring gauge until the next sweep, so a
+		mass expiry can be alerted on before it happens. Default 5 minutes.
+	*/
+	SweepInterval  time.Duration
+	SweepGrace     time.Duration
+	ExpiringWindow time.Duration
+
+	/*
+		HistoryDepth is how many of a domain's issuances are recorded, with their serials and
+		expiries, so replaced certs can be audited at /certhistory/{domain}. The history is
+		kept apart from the cert, so retrieves aren't slowed by it. Off by default.
+	*/
+	HistoryDepth int
+
+	/*
+		OnRenew is called with the domain and new expiry of every cert created or renewed,
+		whether by a request or the service renewing its own certificate, for example to
+		reload a TLS config or notify someone. It is called in a goroutine of its own, so it
+		may block, but calls can arrive in any order. Off by default.
+	*/
+	OnRenew func(domain string, expires time.Time)
+
+	/*
+		ExpiryWebhookURL is POSTed {"domain":...,"expires_at":...} once for every cert that
+		comes within ExpiryWebhookThreshold of expiring, so downstream systems can renew it in
+		time. A serving service checks every ExpiryWebhookInterval. A cert is notified once per
+		expiry, and again once it has been renewed. Notices the webhook doesn't answer with a
+		2xx are dropped, unless ExpiryWebhookRetry is set, when they are sent again on the next
+		check. Off by default, threshold 2 minutes, checked every 30 seconds.
+	*/
+	ExpiryWebhookURL       string
+	ExpiryWebhookThreshold time.Duration
+	ExpiryWebhookInterval  time.Duration
+	ExpiryWebhookRetry     bool
+
+	/*
+		CreateRateLimit is how many create requests a second each client IP may make, after a
+		burst of CreateRateBurst. A client over its limit is answered 429 Too Many Requests
+		with a Retry-After header. RetrieveRateLimit and RetrieveRateBurst limit retrieval the
+		same way, usually more loosely. No limits by default, bursts of 10.
+	*/
+	CreateRateLimit   float64
+	CreateRateBurst   int
+	RetrieveRateLimit float64
+	RetrieveRateBurst int
+
+	/*
+		TrustedProxies are the CIDRs, "10.0.0.0/8", or addresses of the load balancers and
+		proxies in front of the service. The client IP the rate limits and access log go by is
+		the address a request came from, unless that is a trusted proxy: then it is read from
+		X-Forwarded-For, the last address in it that isn't a trusted proxy too. A client that
+		isn't behind one can't claim another address by sending the header itself.
+		TrustForwardedFor trusts whatever the request came from, for a service that can only be
+		reached through its proxy. Off by default, the address a request came from is used.
+	*/
+	TrustedProxies    []string
+	TrustForwardedFor bool
+
+	/*
+		APIKeys are the keys allowed to create certs. When set, a create request must carry one
+		in an Authorization: Bearer or an X-API-Key header, or is answered 401 Unauthorized.
+		Retrieval, listing and counting certs stay public unless APIKeyRetrieve is set, and
+		/healthz and /ready unless APIKeyHealth is set. Off by default, anyone can create certs.
+	*/
+	APIKeys        []string
+	APIKeyRetrieve bool
+	APIKeyHealth   bool
+
+	/*
+		TracerProvider creates an OpenTelemetry span for every http request, with children for
+		the calls to the store made to serve it. Propagator reads the trace context a request's
+		headers carry, so the spans join the caller's trace. Defaults to the global provider,
+		which does nothing unless the application installs one, and W3C Trace Context headers.
+	*/
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+
+	/*
+		CORSOrigins are the origins, such as "https://app.example.com", whose browser apps may
+		call the http API and read its responses. "*" allows every origin. Preflight requests
+		from them are answered 204 No Content. Off by default, browsers only allow the
+		service's own origin.
+	*/
+	CORSOrigins []string
+
+	/*
+		CompressMinSize is the size, in bytes, from which a response is gzipped for a client
+		that sends Accept-Encoding: gzip, so large listings and exports travel compressed while
+		small responses skip the overhead. DisableCompression sends every response as it is.
+		Default 1024 bytes.
+	*/
+	CompressMinSize    int
+	DisableCompression bool
+
+	/*
+		Middleware wraps the http API in your own handlers, for example to authenticate
+		requests or add headers, the first outermost. They run inside the access log, panic
+		recovery, CORS policy and compression, so their requests are logged, a panic in one is
+		answered with a 500, preflights never reach them and what they write is compressed.
+		They don't wrap the gRPC API.
+	*/
+	Middleware []Middleware
+}
+
+// withDefaults returns a copy of cfg with every unset field filled in.
+func (cfg Config) withDefaults() Config {
+	if cfg.TTL == 0 {
+		cfg.TTL = defaultTTL
+	}
+	if cfg.RenewBuffer == 0 {
+		cfg.RenewBuffer = cfg.TTL / 10
+	}
+	if cfg.ClientCAs != nil && cfg.ClientAuth == tls.NoClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.RenewJitter == 0 {
+		cfg.RenewJitter = defaultRenewJitter
+	}
+	if cfg.RenewRetryBase == 0 {
+		cfg.RenewRetryBase = defaultRenewRetryBase
+	}
+	if cfg.RenewRetryMax == 0 {
+		cfg.RenewRetryMax = defaultRenewRetryMax
+	}
+	if cfg.IdempotencyTTL == 0 {
+		cfg.IdempotencyTTL = defaultIdempotencyTTL
+	}
+	if cfg.RedisTimeout == 0 {
+		cfg.RedisTimeout = defaultRedisTimeout
+	}
+	if cfg.RedisPollInterval == 0 {
+		cfg.RedisPollInterval = defaultRedisPollInterval
+	}
+	if cfg.RedisPollMax == 0 {
+		cfg.RedisPollMax = max(defaultRedisPollMax, cfg.RedisPollInterval)
+	}
+	// "/certsvc/" and "/certsvc" are the same prefix, and "/" is none
+	cfg.BasePath = strings.ToLower(strings.TrimRight(cfg.BasePath, "/"))
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = defaultListenAddr
+	}
+	if cfg.ServerDomain == "" {
+		cfg.ServerDomain = defaultServerDomain
+	}
+	if cfg.CompressMinSize == 0 {
+		cfg.CompressMinSize = defaultCompressMinSize
+	}
+	if cfg.ScanCount == 0 {
+		cfg.ScanCount = defaultScanCount
+	}
+	if cfg.DialAttempts == 0 {
+		cfg.DialAttempts = defaultDialAttempts
+	}
+	if cfg.DialRetryBase == 0 {
+		cfg.DialRetryBase = defaultDialRetryBase
+	}
+	if cfg.IdleTestThreshold == 0 {
+		cfg.IdleTestThreshold = defaultIdleTestThreshold
+	}
+	if cfg.MaxActive == 0 {
+		cfg.MaxActive = defaultMaxActive
+	}
+	if cfg.MaxIdle == 0 {
+		cfg.MaxIdle = defaultMaxIdle
+		if cfg.MaxIdle > cfg.MaxActive {
+			cfg.MaxIdle = cfg.MaxActive
+		}
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+	if cfg.MaxBatchSize == 0 {
+		cfg.MaxBatchSize = defaultMaxBatchSize
+	}
+	if cfg.SweepGrace == 0 {
+		cfg.SweepGrace = defaultSweepGrace
+	}
+	if cfg.ExpiringWindow == 0 {
+		cfg.ExpiringWindow = defaultExpiringWindow
+	}
+	if cfg.ExpiryWebhookThreshold == 0 {
+		cfg.ExpiryWebhookThreshold = defaultExpiryWebhookThreshold
+	}
+	if cfg.ExpiryWebhookInterval == 0 {
+		cfg.ExpiryWebhookInterval = defaultExpiryWebhookInterval
+	}
+	if cfg.CreateRateBurst == 0 {
+		cfg.CreateRateBurst = defaultRateBurst
+	}
+	if cfg.RetrieveRateBurst == 0 {
+		cfg.RetrieveRateBurst = defaultRateBurst
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.AuditLogger == nil {
+		cfg.AuditLogger = cfg.Logger
+	}
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+	if cfg.Propagator == nil {
+		cfg.Propagator = propagation.TraceContext{}
+	}
+	if len(cfg.TTLBuckets) == 0 {
+		cfg.TTLBuckets = defaultTTLBuckets
+	}
+	if cfg.MinRequestTTL == 0 {
+		cfg.MinRequestTTL = minTTL
+	}
+	if cfg.MaxRequestTTL == 0 {
+		cfg.MaxRequestTTL = defaultMaxRequestTTL
+	}
+	return cfg
+}
+
+// validate reports whether cfg can be used to run the service safely.
+func (cfg Config) validate() error {
+	if err := validateLifetime(cfg.TTL, cfg.RenewBuffer); err != nil {
+		return err
+	}
+	if cfg.MinRequestTTL < minTTL || cfg.MaxRequestTTL < cfg.MinRequestTTL {
+		return fmt.Errorf("invalid requested TTL bounds %v to %v, the minimum is %v", cfg.MinRequestTTL, cfg.MaxRequestTTL, minTTL)
+	}
+	if cfg.KeyLayout != HashLayout && cfg.KeyLayout != PerDomainKeyLayout {
+		return fmt.Errorf("unknown key layout %d", cfg.KeyLayout)
+	}
+	if cfg.MaxDomains < 0 {
+		return fmt.Errorf("invalid maximum of %d domains", cfg.MaxDomains)
+	}
+	if cfg.FullPolicy != RejectWhenFull && cfg.FullPolicy != EvictWhenFull {
+		return fmt.Errorf("unknown full policy %d", cfg.FullPolicy)
+	}
+	if cfg.KeyType != ECDSAP256 && cfg.KeyType != RSA2048 && cfg.KeyType != RSA4096 {
+		return fmt.Errorf("unknown key type %d", cfg.KeyType)
+	}
+	if cfg.Namespace != "" && cfg.Storage != nil {
+		return fmt.Errorf("a namespace can't be applied to a custom Storage, use NewNamespacedRedisStorage")
+	}
+	if cfg.Dial != nil && (cfg.Storage != nil || len(cfg.SentinelAddrs) > 0) {
+		return fmt.Errorf("a dial func can't be combined with a custom Storage or Sentinels")
+	}
+	if cfg.RedisReadAddr != "" && cfg.Storage != nil {
+		return fmt.Errorf("a read replica can't be applied to a custom Storage, use NewReplicatedRedisStorage")
+	}
+	if strings.ContainsAny(cfg.Namespace, "*?[]\\") {
+		return fmt.Errorf("namespace %q can't contain redis glob characters", cfg.Namespace)
+	}
+	if cfg.IdempotencyTTL < 0 {
+		return fmt.Errorf("invalid idempotency TTL %v", cfg.IdempotencyTTL)
+	}
+	if cfg.RedisTimeout < 0 {
+		return fmt.Errorf("invalid redis timeout %v", cfg.RedisTimeout)
+	}
+	if cfg.SlowRedisThreshold < 0 {
+		return fmt.Errorf("invalid slow redis threshold %v", cfg.SlowRedisThreshold)
+	}
+	if cfg.RedisPollInterval < 0 || cfg.RedisPollMax < cfg.RedisPollInterval {
+		return fmt.Errorf("invalid redis poll intervals %v to %v", cfg.RedisPollInterval, cfg.RedisPollMax)
+	}
+	if cfg.StartupTimeout < 0 {
+		return fmt.Errorf("invalid startup timeout %v", cfg.StartupTimeout)
+	}
+	if (cfg.RootPage != "" && cfg.RootRedirect != "") || (cfg.DisableRootPage && (cfg.RootPage != "" || cfg.RootRedirect != "")) {
+		return fmt.Errorf("only one of RootPage, RootRedirect and DisableRootPage can be set")
+	}
+	if cfg.BasePath != "" && (!strings.HasPrefix(cfg.BasePath, "/") || path.Clean(cfg.BasePath) != cfg.BasePath || strings.ContainsAny(cfg.BasePath, "{}?#% ")) {
+		return fmt.Errorf("invalid base path %q, expected a clean path such as /certsvc", cfg.BasePath)
+	}
+	if cfg.ClientCAs != nil && !cfg.HTTPS {
+		return fmt.Errorf("client certificates need HTTPS")
+	}
+	if (cfg.ClientCAs != nil) != validClientAuth(cfg.ClientAuth) {
+		return fmt.Errorf("invalid client auth %v, expected ClientCAs with RequireAndVerifyClientCert or VerifyClientCertIfGiven", cfg.ClientAuth)
+	}
+	if cfg.DisableServerCert && cfg.HTTPS {
+		return fmt.Errorf("HTTPS serves the server certificate, it can't be disabled")
+	}
+	if reason := invalidReason(canonicalDomain(cfg.ServerDomain)); reason != "" {
+		return fmt.Errorf("invalid server domain %q: %s", cfg.ServerDomain, reason)
+	}
+	if cfg.HistoryDepth < 0 {
+		return fmt.Errorf("invalid history depth %d", cfg.HistoryDepth)
+	}
+	if cfg.ScanCount < 0 {
+		return fmt.Errorf("invalid scan count %d", cfg.ScanCount)
+	}
+	if cfg.RedisDatabase < 0 {
+		return fmt.Errorf("invalid redis database %d", cfg.RedisDatabase)
+	}
+	if cfg.DialAttempts < 0 || cfg.DialRetryBase < 0 {
+		return fmt.Errorf("invalid redis dial retries %d every %v", cfg.DialAttempts, cfg.DialRetryBase)
+	}
+	if cfg.IdleTestThreshold < 0 {
+		return fmt.Errorf("invalid idle test threshold %v", cfg.IdleTestThreshold)
+	}
+	if cfg.MaxIdle < 0 || cfg.MaxActive < 0 || cfg.MaxIdle > cfg.MaxActive {
+		return fmt.Errorf("invalid redis pool size, %d idle of %d connections", cfg.MaxIdle, cfg.MaxActive)
+	}
+	if cfg.IdleTimeout < 0 {
+		return fmt.Errorf("invalid redis idle timeout %v", cfg.IdleTimeout)
+	}
+	if (len(cfg.SentinelAddrs) > 0) != (cfg.SentinelMaster != "") {
+		return fmt.Errorf("sentinel addresses and a master name must be set together")
+	}
+	if !cfg.UseTLS && (cfg.TLSConfig != nil || cfg.TLSSkipVerify) {
+		return fmt.Errorf("TLS options are set but UseTLS is off")
+	}
+	if cfg.MaxBatchSize < 0 {
+		return fmt.Errorf("invalid batch size %d", cfg.MaxBatchSize)
+	}
+	if cfg.SweepInterval < 0 || cfg.SweepGrace < 0 {
+		return fmt.Errorf("invalid sweep of certs expired %v ago every %v", cfg.SweepGrace, cfg.SweepInterval)
+	}
+	if cfg.ExpiringWindow < 0 {
+		return fmt.Errorf("invalid expiring window %v", cfg.ExpiringWindow)
+	}
+	if cfg.ExpiryWebhookThreshold < 0 || cfg.ExpiryWebhookInterval < 0 {
+		return fmt.Errorf("invalid expiry webhook checks every %v for certs expiring within %v", cfg.ExpiryWebhookInterval, cfg.ExpiryWebhookThreshold)
+	}
+	if cfg.ExpiryWebhookURL != "" {
+		if u, err := url.Parse(cfg.ExpiryWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid expiry webhook URL %q", cfg.ExpiryWebhookURL)
+		}
+	}
+	if cfg.CreateRateLimit < 0 || cfg.CreateRateBurst < 0 || cfg.RetrieveRateLimit < 0 || cfg.RetrieveRateBurst < 0 {
+		return fmt.Errorf("invalid rate limits, %v creates and %v retrieves a second", cfg.CreateRateLimit, cfg.RetrieveRateLimit)
+	}
+	if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	if len(cfg.APIKeys) == 0 && (cfg.APIKeyRetrieve || cfg.APIKeyHealth) {
+		return fmt.Errorf("retrieval or health checks require an API key but no APIKeys are set")
+	}
+	for _, key := range cfg.APIKeys {
+		if key == "" {
+			return fmt.Errorf("API keys can't be empty")
+		}
+	}
+	for _, origin := range cfg.CORSOrigins {
+		if !validOrigin(origin) {
+			return fmt.Errorf("invalid CORS origin %q, expected * or scheme://host", origin)
+		}
+	}
+	if cfg.CompressMinSize < 0 {
+		return fmt.Errorf("invalid compression threshold %d bytes", cfg.CompressMinSize)
+	}
+	for i, mw := range cfg.Middleware {
+		if mw == nil {
+			return fmt.Errorf("middleware %d is nil", i)
+		}
+	}
+	if cfg.IssueDelay < 0 {
+		return fmt.Errorf("invalid issue delay %v", cfg.IssueDelay)
+	}
+	if cfg.RenewJitter < 0 || cfg.RenewJitter >= 1 {
+		return fmt.Errorf("invalid renewal jitter %v, expected a fraction from 0 to 1", cfg.RenewJitter)
+	}
+	if cfg.RenewRetryBase < 0 || cfg.RenewRetryMax < cfg.RenewRetryBase {
+		return fmt.Errorf("invalid renewal retry delays %v to %v", cfg.RenewRetryBase, cfg.RenewRetryMax)
+	}
+	for i, bound := range cfg.TTLBuckets {
+		if bound <= 0 || (i > 0 && bound <= cfg.TTLBuckets[i-1]) {
+			return fmt.Errorf("TTL buckets must be positive and ascending, got %v", cfg.TTLBuckets)
+		}
+	}
+	return nil
+}
+
+// validateLifetime reports whether certificates can be issued for ttl and renewed renewBuffer before expiry.
+func validateLifetime(ttl time.Duration, renewBuffer time.Duration) error {
+	if ttl < minTTL {
+		return fmt.Errorf("certificate TTL %v is too short to renew safely, the minimum is %v", ttl, minTTL)
+	}
+	if renewBuffer < minRenewBuffer || renewBuffer >= ttl {
+		return fmt.Errorf("renewal buffer %v must be at least %v and less than the TTL %v", renewBuffer, minRenewBuffer, ttl)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConfigTTL checks the TTL defaults and that TTLs too short to renew are rejected.
+func TestConfigTTL(t *testing.T) {
+	svc, err := NewCertificateServiceWithConfig(Config{})
+	if err != nil {
+		t.Fatalf("the default configuration should be valid: %v", err)
+	}
+	if db := svc.(*dbConn); db.ttl != defaultTTL {
+		t.Errorf("expected the default TTL %v, got %v", defaultTTL, db.ttl)
+	}
+
+	svc, err = NewCertificateServiceWithConfig(Config{TTL: time.Hour * 24})
+	if err != nil {
+		t.Fatalf("a 24 hour TTL should be valid: %v", err)
+	}
+	if db := svc.(*dbConn); db.renewInterval() != time.Hour*24/10*9 {
+		t.Errorf("expected renewal at 90%% of the TTL, got %v", db.renewInterval())
+	}
+
+	if _, err = NewCertificateServiceWithConfig(Config{TTL: time.Second}); err == nil {
+		t.Errorf("a 1 second TTL should be rejected")
+	}
+	if _, err = NewCertificateServiceWithConfig(Config{TTL: -time.Minute}); err == nil {
+		t.Errorf("a negative TTL should be rejected")
+	}
+}
+
+// TestConfigRenewJitter checks renewals are spread around the interval, but never past half the renewal buffer.
+func TestConfigRenewJitter(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{TTL: time.Minute * 100, RenewBuffer: time.Minute * 10})
+	earliest, latest := time.Duration(1<<62), time.Duration(0)
+	for i := 0; i < 500; i++ {
+		d := db.renewDelay()
+		earliest, latest = min(earliest, d), max(latest, d)
+	}
+	if earliest < time.Minute*81 || latest > time.Minute*95 || earliest == latest || earliest >= time.Minute*90 {
+		t.Errorf("expected renewals spread from 81 to 95 minutes, got %v to %v", earliest, latest)
+	}
+
+	db = newFakeDBWithConfig(newFakeRedis(), Config{TTL: time.Minute * 100, RenewBuffer: time.Minute * 10, DisableRenewJitter: true})
+	if d := db.renewDelay(); d != time.Minute*90 {
+		t.Errorf("expected renewal at exactly the interval without jitter, got %v", d)
+	}
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), RenewJitter: 1.5}); err == nil {
+		t.Error("expected a jitter over the whole interval to be rejected")
+	}
+}
+
+// TestConfigPool checks the redis pool defaults, and that a pool with more idle than total connections is rejected.
+func TestConfigPool(t *testing.T) {
+	pool := newPool(Config{}.withDefaults())
+	if pool.MaxIdle != defaultMaxIdle || pool.MaxActive != defaultMaxActive || pool.IdleTimeout != defaultIdleTimeout || !pool.Wait {
+		t.Errorf("unexpected default pool %d idle, %d active, %v idle timeout, wait %v", pool.MaxIdle, pool.MaxActive, pool.IdleTimeout, pool.Wait)
+	}
+
+	pool = newPool(Config{MaxActive: 10, NoWait: true}.withDefaults())
+	if pool.MaxIdle != 10 || pool.MaxActive != 10 || pool.Wait {
+		t.Errorf("expected the default idle connections capped at MaxActive, got %d idle of %d", pool.MaxIdle, pool.MaxActive)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{MaxIdle: 20, MaxActive: 10}); err == nil {
+		t.Errorf("more idle than total connections should be rejected")
+	}
+}
+
+// TestConfigServerDomain checks the server certificate is issued for ServerDomain, which must be valid.
+func TestConfigServerDomain(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{ServerDomain: "Certs.Example.com."})
+	db.newCertServer()
+	if _, err := db.getCert(context.Background(), "certs.example.com"); err != nil {
+		t.Errorf("expected the server certificate under the configured domain: %v", err)
+	}
+	if _, err := db.getCert(context.Background(), "certserver.fan"); !errors.Is(err, ErrDomainNotFound) {
+		t.Errorf("expected no certificate for the default domain, got %v", err)
+	}
+
+	for _, domain := range []string{"certserver", "-certs.example.com", "192.168.0.1"} {
+		_, err := NewCertificateServiceWithConfig(Config{ServerDomain: domain, Storage: NewMemoryStorage()})
+		if err == nil || !strings.Contains(err.Error(), "invalid server domain") {
+			t.Errorf("expected server domain %q to be rejected, got %v", domain, err)
+		}
+	}
+}
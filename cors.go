@@ -0,0 +1,105 @@
+package CertificateService
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// corsMethods are every method a route of the http API serves.
+	corsMethods = "GET, HEAD, POST"
+	// corsHeaders are the request headers the API reads that a browser won't send cross-origin unless allowed.
+	corsHeaders = "Authorization, Content-Type, Idempotency-Key, X-API-Key"
+	// corsExposed are the response headers a browser app may read besides the basic ones.
+	corsExposed = "Content-Disposition, Retry-After, X-Cert-Expires, X-Cert-Expired, X-Cert-Revoked"
+	// corsMaxAge is how long, in seconds, a browser may cache a preflight result.
+	corsMaxAge = 600
+)
+
+/*
+cors lets browser apps on the allowed origins call the API, by answering their preflight
+requests and marking the responses they may read. When the origins include "*" every origin
+is allowed. A nil cors allows none, the browser default.
+*/
+type cors struct {
+	origins map[string]bool
+	any     bool
+}
+
+// newCORS returns the policy allowing origins, or nil if there are none. Browsers send origins in lower case.
+func newCORS(origins []string) *cors {
+	if len(origins) == 0 {
+		return nil
+	}
+	c := &cors{origins: make(map[string]bool, len(origins))}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.any = true
+		}
+		c.origins[strings.ToLower(origin)] = true
+	}
+	return c
+}
+
+// validOrigin reports whether origin is "*" or a bare scheme://host[:port], as browsers send it.
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+/*
+wrap returns next with the policy applied. A request from an allowed origin is answered with
+Access-Control-Allow-Origin, "*" if every origin is allowed or else its own origin. A
+preflight, an OPTIONS request with Access-Control-Request-Method, is answered 204 No Content
+by wrap itself, listing the methods and headers the API accepts when the origin is allowed.
+Requests without an Origin header pass straight through.
+*/
+func (c *cors) wrap(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		allowed := c.allowOrigin(w, origin)
+		if !preflight {
+			if allowed {
+				w.Header().Set("Access-Control-Expose-Headers", corsExposed)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+/*
+allowOrigin sets Access-Control-Allow-Origin for origin and reports whether it is allowed.
+Unless every origin is, the response depends on the Origin header, so caches are told so.
+*/
+func (c *cors) allowOrigin(w http.ResponseWriter, origin string) bool {
+	if c.any {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+	w.Header().Add("Vary", "Origin")
+	if !c.origins[origin] {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	return true
+}
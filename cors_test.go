@@ -0,0 +1,78 @@
+package CertificateService
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCORS checks allowed origins are echoed, others get no CORS headers, and preflights are answered 204.
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("next")) })
+	send := func(c *cors, method string, origin string, preflight bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/cert/fanatics.com", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		c.wrap(next).ServeHTTP(w, r)
+		return w
+	}
+
+	listed := newCORS([]string{"https://App.example.com", "http://localhost:3000"})
+	tests := []struct {
+		name      string
+		c         *cors
+		method    string
+		origin    string
+		preflight bool
+		code      int
+		allow     string
+		body      string
+	}{
+		{"listed", listed, "GET", "https://app.example.com", false, http.StatusOK, "https://app.example.com", "next"},
+		{"listed port", listed, "GET", "http://localhost:3000", false, http.StatusOK, "http://localhost:3000", "next"},
+		{"unlisted", listed, "GET", "https://evil.example.com", false, http.StatusOK, "", "next"},
+		{"no origin", listed, "GET", "", false, http.StatusOK, "", "next"},
+		{"preflight", listed, "OPTIONS", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com", ""},
+		{"unlisted preflight", listed, "OPTIONS", "https://evil.example.com", true, http.StatusNoContent, "", ""},
+		{"options without preflight", listed, "OPTIONS", "https://app.example.com", false, http.StatusOK, "https://app.example.com", "next"},
+		{"any", newCORS([]string{"*"}), "GET", "https://evil.example.com", false, http.StatusOK, "*", "next"},
+		{"any preflight", newCORS([]string{"*"}), "OPTIONS", "https://evil.example.com", true, http.StatusNoContent, "*", ""},
+		{"off", newCORS(nil), "OPTIONS", "https://app.example.com", true, http.StatusOK, "", "next"},
+	}
+	for _, test := range tests {
+		w := send(test.c, test.method, test.origin, test.preflight)
+		if w.Code != test.code || w.Header().Get("Access-Control-Allow-Origin") != test.allow || w.Body.String() != test.body {
+			t.Errorf("%s: expected %d allowing %q with body %q, got %d allowing %q with body %q", test.name,
+				test.code, test.allow, test.body, w.Code, w.Header().Get("Access-Control-Allow-Origin"), w.Body)
+		}
+		if methods := w.Header().Get("Access-Control-Allow-Methods"); (methods == corsMethods) != (test.preflight && test.allow != "") {
+			t.Errorf("%s: unexpected allowed methods %q", test.name, methods)
+		}
+	}
+	if vary := send(listed, "GET", "https://evil.example.com", false).Header().Get("Vary"); vary != "Origin" {
+		t.Errorf("expected responses to vary by origin, got %q", vary)
+	}
+}
+
+// TestCORSConfig checks the service applies CORSOrigins to its routes and rejects malformed origins.
+func TestCORSConfig(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{CORSOrigins: []string{"https://app.example.com"}})
+	r := httptest.NewRequest("HEAD", "/cert/fanatics.com", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	db.httpHandler(w, r)
+	if w.Code != http.StatusNotFound || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("expected the retrieve to be allowed cross-origin, got %d %v", w.Code, w.Header())
+	}
+
+	for _, origin := range []string{"", "app.example.com", "https://app.example.com/", "https://app.example.com/path"} {
+		if _, err := NewCertificateServiceWithConfig(Config{CORSOrigins: []string{origin}}); err == nil {
+			t.Errorf("expected origin %q to be rejected", origin)
+		}
+	}
+}
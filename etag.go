@@ -0,0 +1,42 @@
+package CertificateService
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+certETag is the ETag of a retrieve of domainName in format, answered with cert in status. It is
+derived from the stored cert alone, its serial and expiry, so every instance serving the same
+record gives the same ETag, and it changes when the cert is renewed, revoked or expires. It is
+weak, as the text of a retrieve counts down the time the cert has left without the cert
+changing.
+*/
+func certETag(format string, domainName string, cert *x509.Certificate, status string) string {
+	fields := []string{format, domainName, serialNumber(cert), strconv.FormatInt(cert.NotAfter.UnixNano(), 10), status}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+/*
+notModified sets etag on the response and reports whether r's If-None-Match already lists it,
+comparing weakly as a GET or HEAD does, so the response can be 304 Not Modified. An empty
+etag, for a response without a cert, never matches.
+*/
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package CertificateService
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestETag checks a retrieve is 304 Not Modified while the cert is unchanged, and gets a new ETag once it is renewed, revoked or expires.
+func TestETag(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	retrieve := func(method string, path string, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return w
+	}
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/cert/fanatics.com", "/cert/fanatics.com?format=json"} {
+		w := retrieve("GET", path, "")
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: expected an ETag, got %d %v", path, w.Code, w.Header())
+		}
+		// the text counts down, the ETag doesn't
+		now = now.Add(time.Second)
+		if w := retrieve("GET", path, `"other", `+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected 304, got %d %s", path, w.Code, w.Body)
+		}
+		if w := retrieve("HEAD", path, etag); path == "/cert/fanatics.com" && w.Code != http.StatusNotModified {
+			t.Errorf("%s: expected a HEAD to be 304 too, got %d", path, w.Code)
+		}
+	}
+
+	// a second service over the same store gives the same ETag
+	etag := retrieve("GET", "/cert/fanatics.com", "").Header().Get("ETag")
+	other := newFakeDB(fake)
+	w := httptest.NewRecorder()
+	other.httpHandler(w, newRequest("/cert/fanatics.com"))
+	if w.Header().Get("ETag") != etag {
+		t.Errorf("expected the same ETag from another instance, got %s and %s", etag, w.Header().Get("ETag"))
+	}
+
+	for _, change := range []struct {
+		name string
+		fn   func()
+	}{
+		{"revoked", func() { retrieve("POST", "/revoke/fanatics.com", "") }},
+		{"renewed", func() { db.createCert(context.Background(), "fanatics.com") }},
+		{"expired", func() { now = now.Add(defaultTTL + time.Second) }},
+	} {
+		change.fn()
+		w := retrieve("GET", "/cert/fanatics.com", etag)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Errorf("%s: expected a new ETag, got %d %s", change.name, w.Code, w.Header().Get("ETag"))
+		}
+		etag = w.Header().Get("ETag")
+	}
+
+	if w := retrieve("GET", "/cert/missing.com", "*"); w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("expected a missing cert to have no ETag, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,187 @@
+package CertificateService
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// exportRow is a row of /export and /import: a domain and when its cert expires.
+type exportRow struct {
+	Domain    string    `json:"domain"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// exportHeader is the header row of the CSV format.
+var exportHeader = []string{"domain", "expires_at"}
+
+// rowError reports a row /import rejected, by its line number.
+type rowError struct {
+	Line   int    `json:"line"`
+	Domain string `json:"domain,omitempty"`
+	Error  string `json:"error"`
+}
+
+// importResult is the JSON response of /import.
+type importResult struct {
+	Imported int        `json:"imported"`
+	Rejected []rowError `json:"rejected"`
+}
+
+/*
+exportFormat is the format a request to /export or /import selects with its format query
+parameter: "jsonl", one JSON object per line and the default, or "csv" with a header row.
+*/
+func exportFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "jsonl":
+		return "jsonl", nil
+	case "csv":
+		return "csv", nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected jsonl or csv", format)
+	}
+}
+
+/*
+exportHandler streams every stored domain with its expiry, as the rows come off the store's
+cursor, so even a huge store is never held in memory. The response is already under way when
+the store fails part way through, so the connection is aborted instead of ending it cleanly,
+and a client can't mistake a partial export for a complete one.
+*/
+func (db *dbConn) exportHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := exportFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var write func(row exportRow) error
+	var flush func() error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="certs.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(exportHeader)
+		write = func(row exportRow) error {
+			return cw.Write([]string{row.Domain, row.ExpiresAt.UTC().Format(time.RFC3339)})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Disposition", `attachment; filename="certs.jsonl"`)
+		enc := json.NewEncoder(w)
+		write = func(row exportRow) error {
+			return enc.Encode(exportRow{Domain: row.Domain, ExpiresAt: row.ExpiresAt.UTC()})
+		}
+		flush = func() error { return nil }
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	certs, errc := db.StreamCertificates(ctx)
+	for cert := range certs {
+		if err := write(exportRow{Domain: cert.Domain, ExpiresAt: cert.Expires}); err != nil {
+			// the client went away
+			return
+		}
+	}
+	if err := <-errc; err != nil {
+		db.logger.Error("exporting the certs failed", "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	flush()
+}
+
+/*
+importHandler repopulates the store from the rows of an export POSTed to it, in the format
+selected as for /export. Every valid row is issued a new cert for its domain, expiring when
+the exported one did. Rows with an invalid domain or expiry, or that can't be stored, are
+skipped and reported in the response along with the number imported, as is a body that
+can't be read to the end.
+*/
+func (db *dbConn) importHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := exportFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := importResult{Rejected: []rowError{}}
+	store := func(line int, domain string, expires string) {
+		domain, ok := db.checkDomain(domain)
+		if !ok {
+			result.Rejected = append(result.Rejected, rowError{Line: line, Domain: domain, Error: "invalid domain name"})
+			return
+		}
+		expiresAt, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			result.Rejected = append(result.Rejected, rowError{Line: line, Domain: domain, Error: "invalid expiry: " + err.Error()})
+			return
+		}
+		if _, err := db.storeCert(r.Context(), domain, expiresAt); err != nil {
+			result.Rejected = append(result.Rejected, rowError{Line: line, Domain: domain, Error: err.Error()})
+			return
+		}
+		result.Imported++
+	}
+
+	if format == "csv" {
+		cr := csv.NewReader(r.Body)
+		cr.FieldsPerRecord = len(exportHeader)
+		for {
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			line, _ := cr.FieldPos(0)
+			if err != nil {
+				var parseErr *csv.ParseError
+				if !errors.As(err, &parseErr) {
+					// the rest of the body can't be read, the rows before it are imported
+					result.Rejected = append(result.Rejected, rowError{Line: line, Error: "reading the import failed: " + err.Error()})
+					break
+				}
+				result.Rejected = append(result.Rejected, rowError{Line: parseErr.Line, Error: parseErr.Err.Error()})
+				continue
+			}
+			if line == 1 && record[0] == exportHeader[0] && record[1] == exportHeader[1] {
+				continue
+			}
+			store(line, record[0], record[1])
+		}
+	} else {
+		scanner := bufio.NewScanner(r.Body)
+		line := 1
+		for ; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			// the expiry is read as a string so it is validated like a CSV one
+			var row struct {
+				Domain    string `json:"domain"`
+				ExpiresAt string `json:"expires_at"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				result.Rejected = append(result.Rejected, rowError{Line: line, Error: "invalid JSON: " + err.Error()})
+				continue
+			}
+			store(line, row.Domain, row.ExpiresAt)
+		}
+		if err := scanner.Err(); err != nil {
+			result.Rejected = append(result.Rejected, rowError{Line: line, Error: "reading the import failed: " + err.Error()})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
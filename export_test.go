@@ -0,0 +1,124 @@
+package CertificateService
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportImport exports a store in both formats and imports each into an empty one.
+func TestExportImport(t *testing.T) {
+	expires := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	source := newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"key"}})
+	for i, domain := range []string{"fanatics.com", "*.example.com", "münchen.de"} {
+		if _, err := source.storeCert(context.Background(), canonicalDomain(domain), expires.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send := func(db *dbConn, method string, path string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-API-Key", "key")
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, r)
+		return rec
+	}
+
+	rec := httptest.NewRecorder()
+	source.httpHandler(rec, httptest.NewRequest("GET", "/export", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the export to require a key, got %d", rec.Code)
+	}
+	if rec := send(source, "GET", "/export?format=xml", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown format to be rejected, got %d", rec.Code)
+	}
+
+	for _, format := range []string{"jsonl", "csv"} {
+		export := send(source, "GET", "/export?format="+format, "")
+		if export.Code != http.StatusOK {
+			t.Fatalf("%s: export failed %d %s", format, export.Code, export.Body)
+		}
+		if lines := strings.Count(export.Body.String(), "\n"); (format == "jsonl" && lines != 3) || (format == "csv" && lines != 4) {
+			t.Errorf("%s: unexpected export %s", format, export.Body)
+		}
+
+		target := newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"key"}})
+		imported := send(target, "POST", "/import?format="+format, export.Body.String())
+		var result importResult
+		if err := json.Unmarshal(imported.Body.Bytes(), &result); err != nil || result.Imported != 3 || len(result.Rejected) != 0 {
+			t.Fatalf("%s: unexpected import %d %s", format, imported.Code, imported.Body)
+		}
+		for i, domain := range []string{"fanatics.com", "*.example.com", "xn--mnchen-3ya.de"} {
+			cert, err := target.getCert(context.Background(), domain)
+			if err != nil || !cert.NotAfter.Equal(expires.Add(time.Duration(i)*time.Hour)) {
+				t.Errorf("%s: expected %s to be imported with its expiry, got %v", format, domain, err)
+			}
+		}
+	}
+}
+
+// TestImportRejects checks every row that can't be imported is reported by line, and the rest imported.
+func TestImportRejects(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	imports := map[string]string{
+		"jsonl": `{"domain":"fanatics.com","expires_at":"2030-01-01T12:00:00Z"}
+{"domain":"-invalid","expires_at":"2030-01-01T12:00:00Z"}
+
+{"domain":"example.com","expires_at":"tomorrow"}
+not json
+{"domain":"example.org","expires_at":"2030-01-01T12:00:00+01:00"}
+`,
+		"csv": `domain,expires_at
+fanatics.com,2030-01-01T12:00:00Z
+-invalid,2030-01-01T12:00:00Z
+example.com,tomorrow
+too,many,fields
+example.org,2030-01-01T12:00:00+01:00
+`,
+	}
+	expected := map[string][]int{"jsonl": {2, 4, 5}, "csv": {3, 4, 5}}
+	for format, body := range imports {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest("POST", "/import?format="+format, bytes.NewBufferString(body)))
+		var result importResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %d %s", format, rec.Code, rec.Body)
+		}
+		var lines []int
+		for _, rejected := range result.Rejected {
+			lines = append(lines, rejected.Line)
+		}
+		if result.Imported != 2 || len(lines) != 3 || lines[0] != expected[format][0] || lines[1] != expected[format][1] || lines[2] != expected[format][2] {
+			t.Errorf("%s: expected 2 imported and lines %v rejected, got %+v", format, expected[format], result)
+		}
+	}
+}
+
+// TestExportAborted checks a store failing part way through aborts the export rather than ending it cleanly.
+func TestExportAborted(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	for i := 0; i < defaultScanCount*2; i++ {
+		fake.set("Domain", strings.Repeat("a", i+1)+".com", encode(time.Now(), time.Time{}, nil))
+	}
+	scans := 0
+	fake.fail = func(cmd string) error {
+		if cmd == "HSCAN" {
+			if scans++; scans > 1 {
+				return errors.New("connection reset")
+			}
+		}
+		return nil
+	}
+	defer func() {
+		if recover() != http.ErrAbortHandler {
+			t.Error("expected the export to be aborted")
+		}
+	}()
+	db.exportHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/export", nil))
+}
@@ -0,0 +1,192 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+
+	//imported package, run go get google.golang.org/grpc
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"CertificateService/certpb"
+)
+
+/*
+grpcServer serves the certpb API. Like the http handlers it is a thin wrapper, every call
+goes through the same dbConn methods.
+*/
+type grpcServer struct {
+	certpb.UnimplementedCertificateServiceServer
+	db *dbConn
+}
+
+/*
+OpenGRPCServer serves the gRPC API of certpb/certificate.proto on addr, alongside or instead of
+the http server, until the service is closed. It shares the store, clock and background
+renewals with the http server, and uses TLS with the server certificate when HTTPS is
+configured. API keys are required as they are over http, sent as "authorization: Bearer {key}"
+or "x-api-key" metadata. The per client rate limits only apply to http. With
+Config.StartupTimeout it first waits for redis to answer.
+*/
+func (db *dbConn) OpenGRPCServer(addr string) error {
+	if err := db.waitAtStartup(); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := db.newGRPCServer()
+	if !db.onClose(server.Stop) {
+		ln.Close()
+		return ErrServiceClosed
+	}
+	db.startBackground()
+	err = server.Serve(ln)
+	db.logger.Error("the gRPC server stopped", "err", err)
+	return err
+}
+
+// newGRPCServer returns a gRPC server with the certpb API registered, not yet serving.
+func (db *dbConn) newGRPCServer() *grpc.Server {
+	options := []grpc.ServerOption{grpc.ChainUnaryInterceptor(db.recoverRPC, db.authorizeRPC, db.identifyRPC)}
+	if db.https {
+		options = append(options, grpc.Creds(credentials.NewTLS(db.serverTLSConfig())))
+	}
+	server := grpc.NewServer(options...)
+	certpb.RegisterCertificateServiceServer(server, &grpcServer{db: db})
+	return server
+}
+
+/*
+recoverRPC recovers a panic in any call, logging it with the method and its stack as the http
+API does, and fails the call with Internal instead of crashing the server.
+*/
+func (db *dbConn) recoverRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			db.panicked(p, "method", info.FullMethod)
+			resp, err = nil, status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+/*
+authorizeRPC rejects a call without a valid API key with Unauthenticated, when its method
+requires one: CreateCert and DeleteCert when keys are configured, GetCert when retrieval is
+locked, and Ping when health checks are. Under Config.ClientCAs a CreateCert or DeleteCert
+without a verified client cert is PermissionDenied.
+*/
+func (db *dbConn) authorizeRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	keys := db.createKeys
+	switch info.FullMethod {
+	case certpb.CertificateService_GetCert_FullMethodName:
+		keys = db.retrieveKeys
+	case certpb.CertificateService_Ping_FullMethodName:
+		keys = db.healthKeys
+	default:
+		if db.clientCAs != nil && rpcClientCertSubject(ctx) == "" {
+			return nil, status.Error(codes.PermissionDenied, "a verified client certificate is required")
+		}
+	}
+	if keys != nil {
+		first := func(key string) string {
+			if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}
+		if !keys.valid(presentedKey(first("authorization"), first("x-api-key"))) {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) CreateCert(ctx context.Context, req *certpb.CreateCertRequest) (*certpb.Cert, error) {
+	domain, ok := s.db.checkDomain(req.GetDomain())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid domain name: "+domain)
+	}
+	cert, _, err := s.db.issue(ctx, domain, 0, nil, nil, s.db.createMode())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return s.db.certMessage(domain, "", cert, false), nil
+}
+
+func (s *grpcServer) GetCert(ctx context.Context, req *certpb.GetCertRequest) (*certpb.Cert, error) {
+	domain, ok := s.db.checkDomain(req.GetDomain())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid domain name: "+domain)
+	}
+	cert, _, wildcard, revoked, err := s.db.lookup(ctx, domain)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return s.db.certMessage(domain, wildcard, cert, revoked), nil
+}
+
+func (s *grpcServer) DeleteCert(ctx context.Context, req *certpb.DeleteCertRequest) (*certpb.DeleteCertResponse, error) {
+	domain, ok := s.db.checkDomain(req.GetDomain())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid domain name: "+domain)
+	}
+	deleted, err := s.db.deleteCert(ctx, domain)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !deleted {
+		return nil, grpcError(ErrDomainNotFound)
+	}
+	return &certpb.DeleteCertResponse{}, nil
+}
+
+func (s *grpcServer) Ping(ctx context.Context, req *certpb.PingRequest) (*certpb.PingResponse, error) {
+	if !s.db.PingRedis(ctx) {
+		return nil, status.Error(codes.Unavailable, "redis can't be reached")
+	}
+	return &certpb.PingResponse{}, nil
+}
+
+// certMessage is the certpb form of the cert of domainName, found under wildcard if that is set.
+func (db *dbConn) certMessage(domainName string, wildcard string, cert *x509.Certificate, revoked bool) *certpb.Cert {
+	return &certpb.Cert{
+		Domain:    domainName,
+		CoveredBy: wildcard,
+		ExpiresAt: timestamppb.New(cert.NotAfter),
+		Serial:    serialNumber(cert),
+		CertPem:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		Revoked:   revoked,
+		Trusted:   !revoked && cert.NotAfter.After(db.now()),
+	}
+}
+
+/*
+grpcError maps an error of the store to a gRPC status: a missing domain is NotFound, a
+cancelled call Canceled, a corrupt record DataLoss, and anything else, a redis that is down,
+hung or closed, Unavailable.
+*/
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrDomainNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, errCorruptExpiry):
+		return status.Error(codes.DataLoss, err.Error())
+	case errors.Is(err, ErrStoreFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrDomainExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
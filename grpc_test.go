@@ -0,0 +1,135 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"CertificateService/certpb"
+)
+
+// newGRPCClient serves db's gRPC API over an in-memory listener and returns a client of it.
+func newGRPCClient(t *testing.T, db *dbConn) certpb.CertificateServiceClient {
+	ln := bufconn.Listen(1 << 20)
+	server := db.newGRPCServer()
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return certpb.NewCertificateServiceClient(conn)
+}
+
+// TestGRPC runs every RPC and checks errors map to the right status codes.
+func TestGRPC(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	client := newGRPCClient(t, db)
+	ctx := context.Background()
+	code := func(err error) codes.Code { return status.Code(err) }
+
+	created, err := client.CreateCert(ctx, &certpb.CreateCertRequest{Domain: "Fanatics.COM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Domain != "fanatics.com" || !created.Trusted || created.Serial == "" {
+		t.Errorf("unexpected cert %v", created)
+	}
+	got, err := client.GetCert(ctx, &certpb.GetCertRequest{Domain: "fanatics.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Serial != created.Serial || !got.ExpiresAt.AsTime().Equal(created.ExpiresAt.AsTime()) || len(got.CertPem) == 0 {
+		t.Errorf("expected the created cert, got %v", got)
+	}
+
+	if _, err := client.CreateCert(ctx, &certpb.CreateCertRequest{Domain: "*.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := client.GetCert(ctx, &certpb.GetCertRequest{Domain: "www.example.com"}); err != nil || got.CoveredBy != "*.example.com" {
+		t.Errorf("expected the wildcard cert, got %v %v", got, err)
+	}
+	if _, err := db.revoke(ctx, "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := client.GetCert(ctx, &certpb.GetCertRequest{Domain: "fanatics.com"}); err != nil || !got.Revoked || got.Trusted {
+		t.Errorf("expected a revoked cert, got %v %v", got, err)
+	}
+
+	if _, err := client.GetCert(ctx, &certpb.GetCertRequest{Domain: "missing.com"}); code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+	if _, err := client.CreateCert(ctx, &certpb.CreateCertRequest{Domain: "-invalid"}); code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+	if _, err := client.DeleteCert(ctx, &certpb.DeleteCertRequest{Domain: "fanatics.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DeleteCert(ctx, &certpb.DeleteCertRequest{Domain: "fanatics.com"}); code(err) != codes.NotFound {
+		t.Errorf("expected deleting again to be NotFound, got %v", err)
+	}
+
+	if _, err := client.Ping(ctx, &certpb.PingRequest{}); err != nil {
+		t.Errorf("expected the ping to succeed, got %v", err)
+	}
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	if _, err := client.Ping(ctx, &certpb.PingRequest{}); code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+	if _, err := client.GetCert(ctx, &certpb.GetCertRequest{Domain: "fanatics.com"}); code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
+
+// TestGRPCAPIKeys checks the RPCs require the API keys the http routes do.
+func TestGRPCAPIKeys(t *testing.T) {
+	client := newGRPCClient(t, newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"key"}}))
+	ctx := context.Background()
+	if _, err := client.CreateCert(ctx, &certpb.CreateCertRequest{Domain: "fanatics.com"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a key, got %v", err)
+	}
+	wrong := metadata.AppendToOutgoingContext(ctx, "x-api-key", "wrong")
+	if _, err := client.DeleteCert(wrong, &certpb.DeleteCertRequest{Domain: "fanatics.com"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated with the wrong key, got %v", err)
+	}
+	authorized := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer key")
+	if _, err := client.CreateCert(authorized, &certpb.CreateCertRequest{Domain: "fanatics.com"}); err != nil {
+		t.Errorf("expected the key to be accepted, got %v", err)
+	}
+	if _, err := client.GetCert(ctx, &certpb.GetCertRequest{Domain: "fanatics.com"}); err != nil {
+		t.Errorf("retrieval should stay public, got %v", err)
+	}
+}
+
+// TestGRPCRecover checks a panicking RPC fails with Internal and the server keeps serving.
+func TestGRPCRecover(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	client := newGRPCClient(t, db)
+	ctx := context.Background()
+
+	fake.fail = func(cmd string) error { panic("short read") }
+	if _, err := client.GetCert(ctx, &certpb.GetCertRequest{Domain: "fanatics.com"}); status.Code(err) != codes.Internal {
+		t.Errorf("expected Internal, got %v", err)
+	}
+	if n := testutil.ToFloat64(db.metrics.panics); n != 1 {
+		t.Errorf("expected 1 panic counted, got %v", n)
+	}
+	fake.fail = nil
+	if _, err := client.CreateCert(ctx, &certpb.CreateCertRequest{Domain: "fanatics.com"}); err != nil {
+		t.Errorf("expected the server to keep serving, got %v", err)
+	}
+}
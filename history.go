@@ -0,0 +1,85 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// historyKeyPrefix prefixes the redis list holding a domain's issuance history.
+const historyKeyPrefix = "History:"
+
+// HistoryEntry is a single issuance of a domain's cert.
+type HistoryEntry struct {
+	Serial    string    `json:"serial"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// history is the JSON response of /certhistory.
+type history struct {
+	Domain  string         `json:"domain"`
+	History []HistoryEntry `json:"history"`
+}
+
+/*
+recordIssuance adds cert, just issued for domainName, to the domain's history when
+Config.HistoryDepth is set. The cert is already stored, so a failure is logged rather than
+failing the issuance.
+*/
+func (db *dbConn) recordIssuance(ctx context.Context, domainName string, cert *x509.Certificate) {
+	if db.historyDepth == 0 {
+		return
+	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	err := db.store.AddHistory(ctx, domainName, HistoryEntry{Serial: serialNumber(cert), IssuedAt: db.now(), ExpiresAt: cert.NotAfter}, db.historyDepth)
+	db.observeRedis("add_history", start, err, "domain", domainName)
+	if err != nil {
+		db.logger.Error("recording a cert's history in redis failed", "domain", domainName, "err", err)
+	}
+}
+
+// certHistory returns the recorded issuances of domainName, the latest first.
+func (db *dbConn) certHistory(ctx context.Context, domainName string) ([]HistoryEntry, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	entries, err := db.store.History(ctx, domainName)
+	db.observeRedis("history", start, err, "domain", domainName)
+	if err != nil {
+		db.logger.Error("reading a cert's history from redis failed", "domain", domainName, "err", err)
+	}
+	return entries, err
+}
+
+/*
+historyHandler writes the recent issuances of the domain in the path after /certhistory/ as
+JSON, the latest first. A domain with no history recorded is 404 Not Found. Errors are
+answered as JSON too, with an ErrorCode.
+*/
+func (db *dbConn) historyHandler(w http.ResponseWriter, r *http.Request) {
+	domain, ok := pathDomain(w, r, db.basePath+"/certhistory/", true)
+	if !ok {
+		return
+	}
+	if domain, ok = db.checkDomain(domain); !ok {
+		writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidDomain, Error: "invalid domain name: " + domain, Reasons: invalidReasons(strings.TrimPrefix(domain, wildcardPrefix))})
+		return
+	}
+	entries, err := db.certHistory(r.Context(), domain)
+	if err != nil {
+		writeJSONError(w, errorStatus(err), apiError{Code: errorCode(err), Error: err.Error()})
+		return
+	}
+	if len(entries) == 0 {
+		writeJSONError(w, http.StatusNotFound, apiError{Code: CodeNotFound, Error: "no history for " + domain})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history{Domain: domain, History: entries})
+}
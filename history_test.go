@@ -0,0 +1,70 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCertHistory renews a domain and checks /certhistory lists its latest issuances, trimmed to HistoryDepth.
+func TestCertHistory(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{HistoryDepth: 2})
+	var serials []string
+	for i := 0; i < 3; i++ {
+		cert, err := db.createCert(context.Background(), "fanatics.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials = append(serials, serialNumber(cert))
+	}
+
+	w := httptest.NewRecorder()
+	db.httpHandler(w, httptest.NewRequest("GET", "/certhistory/Fanatics.com", nil))
+	var h history
+	if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if h.Domain != "fanatics.com" || len(h.History) != 2 || h.History[0].Serial != serials[2] || h.History[1].Serial != serials[1] {
+		t.Errorf("expected the last 2 of %v, latest first, got %+v", serials, h)
+	}
+	// the current cert is still read from its own record
+	if cert, err := db.getCert(context.Background(), "fanatics.com"); err != nil || serialNumber(cert) != serials[2] || !cert.NotAfter.Equal(h.History[0].ExpiresAt) {
+		t.Errorf("expected the latest cert to be current, got %v", err)
+	}
+
+	for path, code := range map[string]int{"/certhistory/missing.com": http.StatusNotFound, "/certhistory/-invalid.com": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+
+	// a batch create is recorded too
+	w = httptest.NewRecorder()
+	db.httpHandler(w, httptest.NewRequest("POST", "/certcreate", strings.NewReader(`["example.com", "fanatics.com"]`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected batch response %d %s", w.Code, w.Body)
+	}
+	for domain, depth := range map[string]int{"example.com": 1, "fanatics.com": 2} {
+		cert, err := db.getCert(context.Background(), domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if history, err := db.certHistory(context.Background(), domain); err != nil || len(history) != depth || history[0].Serial != serialNumber(cert) {
+			t.Errorf("%s: expected the batch's cert first of %d, got %+v %v", domain, depth, history, err)
+		}
+	}
+
+	// without a depth no history is kept
+	db = newFakeDB(newFakeRedis())
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if history, err := db.certHistory(context.Background(), "fanatics.com"); err != nil || len(history) != 0 {
+		t.Errorf("expected no history, got %v %v", history, err)
+	}
+}
@@ -0,0 +1,134 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/*
+idempotencyStore coalesces create operations that share an idempotency key. The first
+request with a key runs the operation, concurrent requests with the same key wait for it,
+and later requests replay its result until the entry expires. Every create path consults
+the same store, and a batch create keeps each domain in the scope of a single create of it,
+so a key is honored whether a domain arrives alone or in a batch. Only creates with
+?format=json are kept apart, as their responses differ.
+
+Results are also saved in the Storage, so a retry replays them even if it reaches another
+instance of the service, or this one after a restart.
+
+Keys are scoped per domain, the same key sent for two different domains runs twice.
+*/
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotentCall
+	// where results are saved for other instances, each call bounded by timeout
+	store   Storage
+	timeout time.Duration
+	logger  *slog.Logger
+}
+
+// idempotentCall is a single coalesced operation and, once done is closed, its result.
+type idempotentCall struct {
+	done   chan struct{}
+	result IdempotentResult
+	err    error
+}
+
+// idempotencyKeyPrefix prefixes the redis key holding the result saved for an idempotency key.
+const idempotencyKeyPrefix = "Idempotency:"
+
+// IdempotentResult is the response of a create made with an idempotency key, replayed as it was first sent.
+type IdempotentResult struct {
+	// Status is the http status code of the response.
+	Status int
+	Body   string
+}
+
+// newIdempotencyStore returns the idempotency store for cfg, saving results in store.
+func newIdempotencyStore(cfg Config, store Storage) *idempotencyStore {
+	return &idempotencyStore{ttl: cfg.IdempotencyTTL, entries: make(map[string]*idempotentCall), store: store, timeout: cfg.RedisTimeout, logger: cfg.Logger}
+}
+
+/*
+do runs fn once per domain and key. Failed operations are not remembered, so a client can
+retry with the same key. An empty key disables coalescing and always runs fn. A request
+waiting for the same key gives up with ctx.Err() once ctx is done, the operation running on.
+*/
+func (s *idempotencyStore) do(ctx context.Context, domain string, key string, fn func() (IdempotentResult, error)) (IdempotentResult, error) {
+	if key == "" {
+		return fn()
+	}
+	scoped := domain + "\x00" + key
+
+	s.mu.Lock()
+	if call, ok := s.entries[scoped]; ok {
+		s.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.result, call.err
+		case <-ctx.Done():
+			return IdempotentResult{}, ctx.Err()
+		}
+	}
+	call := &idempotentCall{done: make(chan struct{})}
+	s.entries[scoped] = call
+	s.mu.Unlock()
+
+	// the key is released however run returns, even if fn panics, which fails the call
+	completed := false
+	defer func() {
+		if !completed {
+			call.err = errors.New("the create with this idempotency key failed")
+		}
+		close(call.done)
+		if call.err != nil {
+			s.forget(scoped, call)
+		} else {
+			time.AfterFunc(s.ttl, func() { s.forget(scoped, call) })
+		}
+	}()
+	call.result, call.err = s.run(ctx, scoped, fn)
+	completed = true
+	return call.result, call.err
+}
+
+/*
+run replays the result saved in the Storage for scoped, or runs fn and saves its result. The
+Storage is keyed by a hash of scoped, so a client's key can't make the stored key unbounded.
+Failing to save is only logged, the create itself succeeded.
+*/
+func (s *idempotencyStore) run(ctx context.Context, scoped string, fn func() (IdempotentResult, error)) (IdempotentResult, error) {
+	sum := sha256.Sum256([]byte(scoped))
+	key := hex.EncodeToString(sum[:])
+
+	loadCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	result, ok, err := s.store.LoadIdempotent(loadCtx, key)
+	cancel()
+	if err != nil || ok {
+		return result, err
+	}
+	if result, err = fn(); err != nil {
+		return result, err
+	}
+	saveCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.store.SaveIdempotent(saveCtx, key, result, time.Now().Add(s.ttl)); err != nil {
+		s.logger.Error("saving an idempotent result failed", "err", err)
+	}
+	return result, nil
+}
+
+// forget removes call from the store, unless it has already been replaced.
+func (s *idempotencyStore) forget(scoped string, call *idempotentCall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[scoped] == call {
+		delete(s.entries, scoped)
+	}
+}
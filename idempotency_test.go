@@ -0,0 +1,164 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+TestIdempotentCreate sends concurrent and repeated create requests sharing an idempotency key
+and checks the cert is only written once, while a different key or domain runs again.
+*/
+func TestIdempotentCreate(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+
+	send := func(domain string, key string) string {
+		r := httptest.NewRequest("POST", "/certcreate/"+domain, nil)
+		r.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return w.Body.String()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body := send("fanatics.com", "abc"); !strings.HasPrefix(body, "<h1>OK, foo{fanatics.com} created") {
+				t.Errorf("unexpected response %s", body)
+			}
+		}()
+	}
+	wg.Wait()
+	send("fanatics.com", "abc")
+	if n := fake.count("EXEC"); n != 1 {
+		t.Fatalf("expected a single create for one idempotency key, got %d", n)
+	}
+
+	send("fanatics.com", "def")
+	send("example.com", "abc")
+	if n := fake.count("EXEC"); n != 3 {
+		t.Errorf("expected a new key or domain to create again, got %d creates", n)
+	}
+}
+
+/*
+TestIdempotentBatchCreate checks a batch create honors the idempotency key too, per domain, so
+a domain sent with the same key in a batch and on its own is only created once.
+*/
+func TestIdempotentBatchCreate(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	post := func(body string, key string) string {
+		r := httptest.NewRequest("POST", "/certcreate", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return w.Body.String()
+	}
+
+	first := post(`["fanatics.com", "example.com"]`, "abc")
+	if again := post(`["fanatics.com", "example.com"]`, "abc"); again != first {
+		t.Errorf("expected the batch to be replayed, got %s then %s", first, again)
+	}
+	if n := fake.count("EXEC"); n != 2 {
+		t.Fatalf("expected the batch to be created once, got %d writes", n)
+	}
+
+	// a single create of a domain of the batch with the same key replays its result
+	send := func(domain string) string {
+		r := httptest.NewRequest("POST", "/certcreate/"+domain, nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return w.Body.String()
+	}
+	if body := send("fanatics.com"); !strings.HasPrefix(body, "<h1>OK, foo{fanatics.com} created") {
+		t.Errorf("expected the batch's create to be replayed, got %s", body)
+	}
+	if n := fake.count("EXEC"); n != 2 {
+		t.Errorf("expected a single create of a batch's domain to write nothing, got %d writes", n)
+	}
+
+	// and the other way round, only the domain new to the key is written
+	send("example.net")
+	if resp := post(`["example.net", "example.org"]`, "abc"); !strings.Contains(resp, `"status":"OK"`) {
+		t.Errorf("unexpected batch response %s", resp)
+	}
+	if n := fake.count("EXEC"); n != 4 {
+		t.Errorf("expected a single write for each new domain, got %d writes", n)
+	}
+}
+
+// TestIdempotentAcrossInstances checks a retry reaching another instance sharing the redis is replayed too.
+func TestIdempotentAcrossInstances(t *testing.T) {
+	fake := newFakeRedis()
+	first, second := newFakeDB(fake), newFakeDB(fake)
+	send := func(db *dbConn, domain string) string {
+		r := httptest.NewRequest("POST", "/certcreate/"+domain, nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		return strconv.Itoa(w.Code) + " " + w.Body.String()
+	}
+
+	created := send(first, "fanatics.com")
+	if replayed := send(second, "fanatics.com"); replayed != created {
+		t.Errorf("expected %q replayed, got %q", created, replayed)
+	}
+	if n := fake.count("EXEC"); n != 1 {
+		t.Fatalf("expected a single create across instances, got %d", n)
+	}
+	if send(second, "example.com"); fake.count("EXEC") != 2 {
+		t.Errorf("expected the key sent for another domain to create again")
+	}
+
+	// a store that can't be read fails the create rather than risk issuing it twice
+	fake.fail = func(cmd string) error {
+		if cmd == "GET" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if resp := send(newFakeDB(fake), "fanatics.org"); !strings.Contains(resp, "connection refused") || fake.count("EXEC") != 2 {
+		t.Errorf("expected the create to fail without writing, got %q", resp)
+	}
+}
+
+/*
+TestIdempotentRelease checks a waiter on a key gives up once its context is done, and a create
+that panics still releases its key so a retry runs.
+*/
+func TestIdempotentRelease(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	started, finish := make(chan struct{}), make(chan struct{})
+	go db.idempotency.do(context.Background(), "fanatics.com", "abc", func() (IdempotentResult, error) {
+		close(started)
+		<-finish
+		return IdempotentResult{Status: 201}, nil
+	})
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.idempotency.do(ctx, "fanatics.com", "abc", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the waiter to give up, got %v", err)
+	}
+	close(finish)
+
+	func() {
+		defer func() { recover() }()
+		db.idempotency.do(context.Background(), "example.com", "abc", func() (IdempotentResult, error) { panic("boom") })
+	}()
+	if result, err := db.idempotency.do(context.Background(), "example.com", "abc", func() (IdempotentResult, error) {
+		return IdempotentResult{Status: 201}, nil
+	}); err != nil || result.Status != 201 {
+		t.Errorf("expected a retry after the panic to run, got %+v %v", result, err)
+	}
+}
@@ -0,0 +1,137 @@
+package CertificateService
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// KeyType is the algorithm and size of the key generated for each cert.
+type KeyType int
+
+const (
+	// ECDSAP256 is an ECDSA key on the P-256 curve, small and fast, and the default.
+	ECDSAP256 KeyType = iota
+
+	// RSA2048 is a 2048 bit RSA key, for clients that don't support ECDSA.
+	RSA2048
+
+	// RSA4096 is a 4096 bit RSA key. Generating one takes a good part of a second.
+	RSA4096
+)
+
+func (k KeyType) String() string {
+	switch k {
+	case ECDSAP256:
+		return "ECDSA P-256"
+	case RSA2048:
+		return "RSA 2048"
+	case RSA4096:
+		return "RSA 4096"
+	}
+	return "KeyType(" + strconv.Itoa(int(k)) + ")"
+}
+
+// generateKey returns a new private key of keyType.
+func generateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case RSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case RSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	}
+	return nil, fmt.Errorf("unknown key type %d", keyType)
+}
+
+/*
+keyInfo returns the algorithm of cert's key, "ECDSA" or "RSA", and its size in bits, so a
+client knows what it will get before fetching it.
+*/
+func keyInfo(cert *x509.Certificate) (algorithm string, bits int) {
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// serialLimit bounds the random serial numbers given to generated certificates.
+var serialLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
+/*
+generateCert creates a self-signed X.509 certificate for domainName, and sans as well if there are
+any, issued at issuedAt and valid from then until notAfter, with a new key of keyType. The certificate is returned parsed and, together with
+its private key, PEM encoded for storage.
+*/
+func generateCert(domainName string, sans []string, issuedAt time.Time, notAfter time.Time, keyType KeyType) (*x509.Certificate, []byte, []byte, error) {
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, serialLimit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: domainName},
+		DNSNames:              append([]string{domainName}, sans...),
+		NotBefore:             issuedAt,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		// RSA key exchange encrypts the session key with the cert's key
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return cert, certPEM, keyPEM, nil
+}
+
+/*
+serialNumber is the serial number of cert in hex. Every certificate is generated with a new
+random serial, so it identifies one issuance of a domain's cert and changes on renewal.
+*/
+func serialNumber(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// parseCertPEM decodes a certificate stored by storeCert.
+func parseCertPEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("stored certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
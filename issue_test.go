@@ -0,0 +1,81 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestKeyTypes checks each key type generates a cert and key that parse back with its algorithm and size.
+func TestKeyTypes(t *testing.T) {
+	for _, tc := range []struct {
+		keyType   KeyType
+		algorithm string
+		bits      int
+	}{
+		{ECDSAP256, "ECDSA", 256},
+		{RSA2048, "RSA", 2048},
+		{RSA4096, "RSA", 4096},
+	} {
+		_, certPEM, keyPEM, err := generateCert("fanatics.com", nil, time.Now(), time.Now().Add(time.Hour), tc.keyType)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.keyType, err)
+		}
+		cert, err := parseCertPEM(certPEM)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.keyType, err)
+		}
+		if algorithm, bits := keyInfo(cert); algorithm != tc.algorithm || bits != tc.bits {
+			t.Errorf("%v: expected a %s %d key, got %s %d", tc.keyType, tc.algorithm, tc.bits, algorithm, bits)
+		}
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			t.Fatalf("%v: the key is not PEM encoded", tc.keyType)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.keyType, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PrivateKey:
+			if tc.algorithm != "ECDSA" {
+				t.Errorf("%v: got an ECDSA key", tc.keyType)
+			}
+		case *rsa.PrivateKey:
+			if tc.algorithm != "RSA" || cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+				t.Errorf("%v: got an RSA key, usage %v", tc.keyType, cert.KeyUsage)
+			}
+		}
+		if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			t.Errorf("%v: the cert and key don't pair: %v", tc.keyType, err)
+		}
+	}
+}
+
+// TestKeyTypeConfig checks the configured key type is used for created certs and reported by a retrieve.
+func TestKeyTypeConfig(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{KeyType: RSA2048})
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert/fanatics.com?format=json"))
+	var result retrieveResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.KeyAlgorithm != "RSA" || result.KeySize != 2048 {
+		t.Errorf("expected an RSA 2048 key, got %+v", result)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), KeyType: RSA4096 + 1}); err == nil {
+		t.Error("expected an unknown key type to be rejected")
+	}
+}
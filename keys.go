@@ -0,0 +1,317 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// KeyLayout is how certs are laid out in redis.
+type KeyLayout int
+
+const (
+	/*
+		HashLayout stores every cert as a field of the shared "Domain", "Certificate" and
+		"PrivateKey" hashes. Expired certs stay in the hashes until they are renewed, so a
+		retrieve can still report them as expired.
+	*/
+	HashLayout KeyLayout = iota
+
+	/*
+		PerDomainKeyLayout stores each cert under its own key, cert:{domain}, set to expire
+		with the cert so redis evicts it. An expired cert is indistinguishable from one that
+		never existed.
+	*/
+	PerDomainKeyLayout
+)
+
+// certKeyPrefix prefixes the per-domain keys of PerDomainKeyLayout.
+const certKeyPrefix = "cert:"
+
+// certKey is the key a domain's cert is stored under in PerDomainKeyLayout.
+func certKey(domainName string) string {
+	return certKeyPrefix + domainName
+}
+
+/*
+queueStoreKey queues the commands storing a cert in PerDomainKeyLayout on conn, to be run
+inside a MULTI/EXEC transaction, and returns how many commands it queued. The key is a hash
+of the PEM encoded cert and key and the encoded expiry, and expires with the cert.
+*/
+func (s *redisStorage) queueStoreKey(conn redis.Conn, domainName string, rec Record) int {
+	key := s.key(certKey(domainName))
+	conn.Send("DEL", key)
+	conn.Send("HSET", key, "cert", rec.CertPEM, "key", rec.KeyPEM, "expires", encode(rec.Expires, rec.IssuedAt, rec.Metadata))
+	conn.Send("PEXPIREAT", key, rec.Expires.UnixMilli())
+	return 3
+}
+
+// scanCertKeys is Storage.Scan for PerDomainKeyLayout.
+func (s *redisStorage) scanCertKeys(ctx context.Context, conn redis.Conn, cursor int, count int) (int, []CertInfo, error) {
+	reply, err := redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", s.key(certKeyPrefix)+"*", "COUNT", count))
+	if err != nil {
+		return 0, nil, err
+	}
+	if cursor, err = redis.Int(reply[0], nil); err != nil {
+		return 0, nil, err
+	}
+	keys, err := redis.Strings(reply[1], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// read every expiry in a single round trip
+	for _, key := range keys {
+		conn.Send("HGET", key, "expires")
+	}
+	if err := conn.Flush(); err != nil {
+		return 0, nil, err
+	}
+	page := make([]CertInfo, 0, len(keys))
+	for _, key := range keys {
+		expires, err := redis.Bytes(redis.ReceiveContext(conn, ctx))
+		if errors.Is(err, redis.ErrNil) {
+			// the cert expired between the SCAN and the HGET
+			continue
+		} else if err != nil {
+			return 0, nil, err
+		}
+		domain := key[len(s.key(certKeyPrefix)):]
+		decoded, issuedAt, meta, err := decode(expires)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", domain, err)
+		}
+		page = append(page, CertInfo{Domain: domain, Expires: decoded, IssuedAt: issuedAt, Metadata: meta})
+	}
+	return cursor, page, nil
+}
+
+// flushCertKeys deletes the cert keys of PerDomainKeyLayout a SCAN page at a time.
+func (s *redisStorage) flushCertKeys(ctx context.Context, conn redis.Conn) (int, error) {
+	removed := 0
+	cursor := 0
+	for {
+		reply, err := redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", s.key(certKeyPrefix)+"*", "COUNT", defaultScanCount))
+		if err != nil {
+			return removed, err
+		}
+		if cursor, err = redis.Int(reply[0], nil); err != nil {
+			return removed, err
+		}
+		keys, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			return removed, err
+		}
+		if len(keys) > 0 {
+			n, err := redis.Int(redis.DoContext(conn, ctx, "DEL", redis.Args{}.AddFlat(keys)...))
+			if err != nil {
+				return removed, err
+			}
+			removed += n
+		}
+		if cursor == 0 {
+			return removed, nil
+		}
+	}
+}
+
+// countCertKeys counts the cert keys of PerDomainKeyLayout, walking them with SCAN.
+func (s *redisStorage) countCertKeys(ctx context.Context, conn redis.Conn) (int, error) {
+	count := 0
+	cursor := 0
+	for {
+		reply, err := redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", s.key(certKeyPrefix)+"*", "COUNT", defaultScanCount))
+		if err != nil {
+			return 0, err
+		}
+		if cursor, err = redis.Int(reply[0], nil); err != nil {
+			return 0, err
+		}
+		keys, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			return 0, err
+		}
+		count += len(keys)
+		if cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
+// MigrateOptions changes how Migrate moves certs to the per-domain key layout.
+type MigrateOptions struct {
+	// DryRun reports what would be done without writing or deleting anything.
+	DryRun bool
+	// KeepHash leaves the moved certs in the hashes as well, rather than deleting them.
+	KeepHash bool
+}
+
+// MigrateReport is what Migrate did, or would have done in a dry run, to each cert in the hashes.
+type MigrateReport struct {
+	// Migrated certs were written to their own key, Reissued of them issued a certificate first.
+	Migrated, Reissued int
+	/*
+		AlreadyMigrated certs had their own key with the same expiry, from an earlier run, or a
+		later one, renewed since, and weren't written again.
+	*/
+	AlreadyMigrated int
+	// Expired certs were skipped, as redis would evict them straight away.
+	Expired int
+	// Removed certs were deleted from the hashes, every cert unless KeepHash is set.
+	Removed int
+}
+
+/*
+MigrateToKeyLayout moves every cert stored in the hash layout to its own key, as used by
+PerDomainKeyLayout, removing them from the hashes, and returns how many certs were moved. It
+is Migrate with the default options.
+*/
+func (db *dbConn) MigrateToKeyLayout() (int, error) {
+	report, err := db.Migrate(MigrateOptions{})
+	return report.Migrated, err
+}
+
+/*
+Migrate moves every cert stored in the hash layout to its own key, as used by
+PerDomainKeyLayout, set to expire with the cert. Each cert is removed from the hashes as it is
+moved unless opts.KeepHash is set; expired certs are skipped, and removed the same way. Certs
+stored before X.509 issuance, with only an expiry, are issued a certificate with the same
+expiry. With opts.DryRun nothing is written, only reported.
+
+It is safe to run again, after a failure or a run with KeepHash: a cert whose key already holds
+the same expiry isn't written again, so a cert issued by an earlier run is kept, and neither is
+one whose key expires later, renewed since, which the hashes would only roll back.
+
+Migrating requires the redis Storage.
+*/
+func (db *dbConn) Migrate(opts MigrateOptions) (MigrateReport, error) {
+	var report MigrateReport
+	if db.store.closed.Load() {
+		return report, ErrServiceClosed
+	}
+	s, ok := db.store.Storage.(*redisStorage)
+	if !ok {
+		return report, errors.New("migrating to the per-domain key layout requires the redis storage")
+	}
+
+	ctx, cancel := db.withTimeout(context.Background())
+	conn, err := s.conn(ctx)
+	cancel()
+	if err != nil {
+		return report, err
+	}
+	defer conn.Close()
+
+	cursor := 0
+	for {
+		ctx, cancel := db.withTimeout(context.Background())
+		start := time.Now()
+		reply, err := redis.Values(redis.DoContext(conn, ctx, "HSCAN", s.key("Domain"), cursor, "COUNT", defaultScanCount))
+		cancel()
+		db.observeRedis("scan", start, err)
+		if err != nil {
+			return report, err
+		}
+		if cursor, err = redis.Int(reply[0], nil); err != nil {
+			return report, err
+		}
+		fields, err := redis.ByteSlices(reply[1], nil)
+		if err != nil {
+			return report, err
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			expires, issuedAt, meta, err := decode(fields[i+1])
+			if err != nil {
+				return report, fmt.Errorf("%s: %w", fields[i], err)
+			}
+			rec := Record{Expires: expires, IssuedAt: issuedAt, Metadata: meta}
+			ctx, cancel := db.withTimeout(context.Background())
+			start := time.Now()
+			err = s.migrateCert(ctx, conn, string(fields[i]), rec, db.now(), db.keyType, opts, &report)
+			cancel()
+			db.observeRedis("migrate", start, err, "domain", string(fields[i]))
+			if err != nil {
+				return report, err
+			}
+		}
+		if cursor == 0 {
+			if opts.DryRun {
+				db.logger.Info("migration dry run", "would_migrate", report.Migrated, "would_reissue", report.Reissued, "already_migrated", report.AlreadyMigrated, "expired", report.Expired, "would_remove", report.Removed)
+			}
+			return report, nil
+		}
+	}
+}
+
+/*
+migrateCert moves a single cert for Migrate, valid or not at now, counting what it did in
+report. rec is what the hash layout stores of it besides the PEMs, read here. A cert without a
+certificate is issued one of keyType.
+*/
+func (s *redisStorage) migrateCert(ctx context.Context, conn redis.Conn, domainName string, rec Record, now time.Time, keyType KeyType, opts MigrateOptions, report *MigrateReport) error {
+	expires := rec.Expires
+	key := s.key(certKey(domainName))
+	conn.Send("HGET", s.key("Certificate"), domainName)
+	conn.Send("HGET", s.key("PrivateKey"), domainName)
+	conn.Send("HGET", key, "expires")
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	certPEM, certErr := redis.Bytes(redis.ReceiveContext(conn, ctx))
+	keyPEM, keyErr := redis.Bytes(redis.ReceiveContext(conn, ctx))
+	migrated, migratedErr := redis.Bytes(redis.ReceiveContext(conn, ctx))
+	for _, err := range []error{certErr, keyErr, migratedErr} {
+		if err != nil && !errors.Is(err, redis.ErrNil) {
+			return err
+		}
+	}
+
+	valid := expires.After(now)
+	write := valid
+	if valid && migratedErr == nil {
+		// the key was written by an earlier run, or the cert renewed since in the key layout
+		if migratedExpiry, _, _, err := decode(migrated); err == nil && !migratedExpiry.Before(expires) {
+			write = false
+			report.AlreadyMigrated++
+		}
+	}
+	reissue := write && (errors.Is(certErr, redis.ErrNil) || errors.Is(keyErr, redis.ErrNil))
+	switch {
+	case !valid:
+		report.Expired++
+	case write:
+		report.Migrated++
+		if reissue {
+			report.Reissued++
+		}
+	}
+	if !opts.KeepHash {
+		report.Removed++
+	}
+	if opts.DryRun || (!write && opts.KeepHash) {
+		return nil
+	}
+
+	if reissue {
+		var err error
+		rec.IssuedAt = now
+		if _, certPEM, keyPEM, err = generateCert(domainName, nil, rec.IssuedAt, expires, keyType); err != nil {
+			return err
+		}
+	}
+	conn.Send("MULTI")
+	if write {
+		rec.CertPEM, rec.KeyPEM = certPEM, keyPEM
+		s.queueStoreKey(conn, domainName, rec)
+	}
+	if !opts.KeepHash {
+		conn.Send("HDEL", s.key("Domain"), domainName)
+		conn.Send("HDEL", s.key("Certificate"), domainName)
+		conn.Send("HDEL", s.key("PrivateKey"), domainName)
+	}
+	_, err := exec(ctx, conn)
+	return err
+}
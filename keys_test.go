@@ -0,0 +1,160 @@
+package CertificateService
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPerDomainKeyLayout checks certs are stored under their own expiring key and evicted when they expire.
+func TestPerDomainKeyLayout(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDBWithConfig(fake, Config{KeyLayout: PerDomainKeyLayout})
+
+	created, err := db.createCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deadline := fake.expires["cert:fanatics.com"]; !deadline.Equal(created.NotAfter) {
+		t.Errorf("expected the key to expire with the cert at %v, expires at %v", created.NotAfter, deadline)
+	}
+	if len(fake.hashes["Domain"]) != 0 || len(fake.hashes["Certificate"]) != 0 {
+		t.Errorf("the hash layout should be left untouched")
+	}
+	if body, _, _, _ := db.retrieve(context.Background(), "fanatics.com"); !strings.HasPrefix(body, "foo{fanatics.com} valid for") {
+		t.Errorf("unexpected retrieve response %s", body)
+	}
+
+	if _, err := db.storeCert(context.Background(), "expired.com", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if body, _, _, _ := db.retrieve(context.Background(), "expired.com"); body != "This domain doesn't exist: expired.com. Submit a cert request to /certcreate/{domain}" {
+		t.Errorf("expected redis to have evicted the expired cert, got %s", body)
+	}
+
+	certs, err := db.ListCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs["fanatics.com"].Equal(created.NotAfter) {
+		t.Errorf("unexpected certs %v", certs)
+	}
+	stream, errc := db.StreamCertificates(context.Background())
+	for cert := range stream {
+		if cert.Domain != "fanatics.com" {
+			t.Errorf("unexpected streamed cert %v", cert)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMigrateToKeyLayout moves hash layout certs to their own keys.
+func TestMigrateToKeyLayout(t *testing.T) {
+	fake := newFakeRedis()
+	hashDB := newFakeDB(fake)
+	for _, domain := range []string{"fanatics.com", "example.net"} {
+		if _, err := hashDB.createCert(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := hashDB.storeCert(context.Background(), "expired.com", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// a cert stored before X.509 issuance only has an expiry
+	legacyExpiry := time.Now().Add(time.Minute).Truncate(time.Second)
+	fake.set("Domain", "legacy.org", encode(legacyExpiry, time.Time{}, nil))
+
+	moved, err := hashDB.MigrateToKeyLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 3 {
+		t.Errorf("expected 3 certs to be moved, moved %d", moved)
+	}
+	for _, hash := range []string{"Domain", "Certificate", "PrivateKey"} {
+		if len(fake.hashes[hash]) != 0 {
+			t.Errorf("expected the %s hash to be emptied, it holds %d fields", hash, len(fake.hashes[hash]))
+		}
+	}
+
+	keyDB := newFakeDBWithConfig(fake, Config{KeyLayout: PerDomainKeyLayout})
+	certs, err := keyDB.ListCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || !certs["legacy.org"].Equal(legacyExpiry) {
+		t.Errorf("unexpected migrated certs %v", certs)
+	}
+	for _, domain := range []string{"fanatics.com", "example.net", "legacy.org"} {
+		if _, err := keyDB.getCert(context.Background(), domain); err != nil {
+			t.Errorf("%s: %v", domain, err)
+		}
+	}
+
+	if moved, err = hashDB.MigrateToKeyLayout(); err != nil || moved != 0 {
+		t.Errorf("expected a second migration to do nothing, moved %d: %v", moved, err)
+	}
+}
+
+/*
+TestMigrateOptions checks a dry run writes nothing, KeepHash keeps the hashes, and a run after
+either moves nothing twice, nor rolls back a cert renewed in the key layout since.
+*/
+func TestMigrateOptions(t *testing.T) {
+	fake := newFakeRedis()
+	hashDB := newFakeDB(fake)
+	if _, err := hashDB.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hashDB.storeCert(context.Background(), "expired.com", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	legacyExpiry := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	fake.set("Domain", "legacy.org", encode(legacyExpiry, time.Time{}, nil))
+	fields := func() int {
+		return len(fake.hashes["Domain"]) + len(fake.hashes["Certificate"]) + len(fake.hashes["PrivateKey"])
+	}
+	legacySerial := func() string {
+		cert, err := parseCertPEM(fake.hashes[certKey("legacy.org")]["cert"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serialNumber(cert)
+	}
+
+	report, err := hashDB.Migrate(MigrateOptions{DryRun: true})
+	if expected := (MigrateReport{Migrated: 2, Reissued: 1, Expired: 1, Removed: 3}); err != nil || report != expected {
+		t.Errorf("expected a dry run to report %+v, got %+v %v", expected, report, err)
+	}
+	if _, ok := fake.hashes[certKey("fanatics.com")]; ok || fields() != 7 {
+		t.Errorf("expected a dry run to write nothing, the hashes hold %d fields", fields())
+	}
+
+	report, err = hashDB.Migrate(MigrateOptions{KeepHash: true})
+	if expected := (MigrateReport{Migrated: 2, Reissued: 1, Expired: 1}); err != nil || report != expected {
+		t.Errorf("expected %+v, got %+v %v", expected, report, err)
+	}
+	if fields() != 7 || !fake.expires[certKey("legacy.org")].Equal(legacyExpiry) {
+		t.Errorf("expected the hashes kept and legacy.org to expire at %v, got %d fields and %v", legacyExpiry, fields(), fake.expires[certKey("legacy.org")])
+	}
+	serial := legacySerial()
+	keyDB := newFakeDBWithConfig(fake, Config{KeyLayout: PerDomainKeyLayout})
+	renewed, err := keyDB.storeCert(context.Background(), "fanatics.com", time.Now().Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = hashDB.Migrate(MigrateOptions{})
+	if expected := (MigrateReport{AlreadyMigrated: 2, Expired: 1, Removed: 3}); err != nil || report != expected {
+		t.Errorf("expected the second run to find both certs migrated, %+v, got %+v %v", expected, report, err)
+	}
+	if fields() != 0 || legacySerial() != serial {
+		t.Errorf("expected the hashes emptied and legacy.org's cert kept, got %d fields", fields())
+	}
+	if cert, err := keyDB.getCert(context.Background(), "fanatics.com"); err != nil || serialNumber(cert) != serialNumber(renewed) {
+		t.Errorf("expected the renewed cert of fanatics.com kept, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package CertificateService
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Metadata is what an operator attaches to a cert when creating it, such as the owner's email,
+the team or free-form tags, kept alongside the cert's expiry, returned when it is retrieved as
+JSON and listed with it. It belongs to the issuance like the SANs do, so a renewal stores the
+metadata it was sent, none if it was sent none.
+*/
+const (
+	// metadataPrefix starts the query parameters naming metadata, ?meta.owner=team-a.
+	metadataPrefix = "meta."
+	// maxMetadataKey is the longest a metadata key may be.
+	maxMetadataKey = 64
+	// maxMetadataSize bounds the metadata of a cert as stored, its JSON encoding, in bytes.
+	maxMetadataSize = 1024
+)
+
+/*
+requestMetadata returns the metadata a create attaches to its cert, given as query parameters
+named for the key after metadataPrefix, ?meta.owner=alice@example.com&meta.team=team-a, or in
+the JSON body, fromBody, {"metadata": {"owner": "alice@example.com"}}, or both. Metadata that
+checkMetadata rejects, or a key given twice, is answered 400 Bad Request and ok is false.
+*/
+func requestMetadata(w http.ResponseWriter, r *http.Request, fromBody map[string]string) (meta map[string]string, ok bool) {
+	fail := func(msg string) (map[string]string, bool) {
+		httpError(w, jsonMode(r), http.StatusBadRequest, CodeInvalidRequest, msg)
+		return nil, false
+	}
+	meta = make(map[string]string)
+	for name, values := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(name, metadataPrefix); ok {
+			if len(values) > 1 {
+				return fail(fmt.Sprintf("metadata %q is given more than once", key))
+			}
+			meta[key] = values[0]
+		}
+	}
+	for key, value := range fromBody {
+		if _, ok := meta[key]; ok {
+			return fail(fmt.Sprintf("metadata %q is given more than once", key))
+		}
+		meta[key] = value
+	}
+	if err := checkMetadata(meta); err != nil {
+		return fail(err.Error())
+	}
+	if len(meta) == 0 {
+		return nil, true
+	}
+	return meta, true
+}
+
+/*
+checkMetadata returns an error for metadata the service won't store: a key that is empty,
+longer than maxMetadataKey or not made of letters, digits, '-', '_' and '.', a value that isn't
+UTF-8, or metadata whose JSON encoding is larger than maxMetadataSize, which keeps the stored
+values small.
+*/
+func checkMetadata(meta map[string]string) error {
+	for key, value := range meta {
+		if key == "" || len(key) > maxMetadataKey {
+			return fmt.Errorf("invalid metadata key %q, expected 1 to %d characters", key, maxMetadataKey)
+		}
+		for _, c := range key {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return fmt.Errorf("invalid metadata key %q, expected letters, digits, '-', '_' and '.'", key)
+			}
+		}
+		if !utf8.ValidString(value) {
+			return fmt.Errorf("metadata %q isn't valid UTF-8", key)
+		}
+	}
+	if encoded, _ := json.Marshal(meta); len(encoded) > maxMetadataSize {
+		return fmt.Errorf("metadata of %d bytes is too large, at most %d are allowed", len(encoded), maxMetadataSize)
+	}
+	return nil
+}
+
+/*
+metadataFilter returns the metadata a listing is filtered on, given as query parameters named
+as a create names it, /certs?meta.owner=team-a, nil if it isn't filtered.
+*/
+func metadataFilter(r *http.Request) map[string]string {
+	var filter map[string]string
+	for name, values := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(name, metadataPrefix); ok {
+			if filter == nil {
+				filter = make(map[string]string)
+			}
+			filter[key] = values[0]
+		}
+	}
+	return filter
+}
+
+// matchesMetadata reports whether meta holds every key of filter with the value filter gives it.
+func matchesMetadata(meta map[string]string, filter map[string]string) bool {
+	for key, value := range filter {
+		if got, ok := meta[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMetadata checks metadata given to a create is stored with the cert, retrieved and listed with it, in both layouts.
+func TestMetadata(t *testing.T) {
+	for _, layout := range []KeyLayout{HashLayout, PerDomainKeyLayout} {
+		db := newFakeDBWithConfig(newFakeRedis(), Config{KeyLayout: layout})
+		r := httptest.NewRequest("POST", "/certcreate/fanatics.com?format=json&meta.owner=alice@example.com", strings.NewReader(`{"metadata": {"team": "team-a"}}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		expected := map[string]string{"owner": "alice@example.com", "team": "team-a"}
+		var created createResult
+		if err := json.NewDecoder(w.Body).Decode(&created); err != nil || w.Code != http.StatusCreated || !maps.Equal(created.Metadata, expected) {
+			t.Fatalf("%v: expected the create to report %v, got %d %+v %v", layout, expected, w.Code, created, err)
+		}
+		if _, err := db.createCert(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		w = httptest.NewRecorder()
+		db.httpHandler(w, newRequest("/cert/fanatics.com?format=json"))
+		var result retrieveResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil || !maps.Equal(result.Metadata, expected) {
+			t.Errorf("%v: expected the retrieve to report %v, got %+v %v", layout, expected, result, err)
+		}
+		_, certs, err := db.ListCertsPage(context.Background(), 0, 10)
+		for _, cert := range certs {
+			if cert.Domain == "fanatics.com" && !maps.Equal(cert.Metadata, expected) || cert.Domain == "example.com" && cert.Metadata != nil {
+				t.Errorf("%v: unexpected metadata listed %+v", layout, cert)
+			}
+		}
+		if err != nil || len(certs) != 2 {
+			t.Errorf("%v: expected 2 certs, got %+v %v", layout, certs, err)
+		}
+
+		// a renewal stores the metadata it is sent, none here
+		if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+			t.Fatal(err)
+		}
+		if _, meta, err := db.getRecord(context.Background(), "fanatics.com"); err != nil || meta != nil {
+			t.Errorf("%v: expected the renewal to store no metadata, got %v %v", layout, meta, err)
+		}
+	}
+}
+
+// TestMetadataFilter checks /certs lists only the certs with the metadata asked for, whole or paged.
+func TestMetadataFilter(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	for domain, owner := range map[string]string{"fanatics.com": "team-a", "example.com": "team-b", "example.net": "team-a"} {
+		if _, _, err := db.issue(context.Background(), domain, 0, nil, map[string]string{"owner": owner}, createOrRenew); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.createCert(context.Background(), "example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/certs?meta.owner=team-a"))
+	var certs map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&certs); err != nil || len(certs) != 2 || certs["fanatics.com"] == nil || certs["example.net"] == nil {
+		t.Errorf("expected the certs of team-a, got %v %v", certs, err)
+	}
+
+	w = httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/certs?cursor=0&count=100&meta.owner=team-b"))
+	var page listPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil || len(page.Certs) != 1 || page.Certs[0].Domain != "example.com" || page.Certs[0].Metadata["owner"] != "team-b" {
+		t.Errorf("expected the cert of team-b, got %+v %v", page, err)
+	}
+
+	w = httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/certs"))
+	if err := json.NewDecoder(w.Body).Decode(&certs); err != nil || len(certs) != 4 {
+		t.Errorf("expected every cert unfiltered, got %v %v", certs, err)
+	}
+}
+
+// TestMetadataRejected checks metadata the service won't store fails the create with 400 and nothing stored.
+func TestMetadataRejected(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	for _, tc := range []struct{ query, body, err string }{
+		{"?meta.owner%20name=alice", "", "invalid metadata key"},
+		{"?meta.=alice", "", "invalid metadata key"},
+		{"?meta." + strings.Repeat("k", maxMetadataKey+1) + "=alice", "", "invalid metadata key"},
+		{"?meta.owner=alice&meta.owner=bob", "", `metadata "owner" is given more than once`},
+		{"?meta.owner=alice", `{"metadata": {"owner": "bob"}}`, `metadata "owner" is given more than once`},
+		{"?meta.tags=" + strings.Repeat("x", maxMetadataSize), "", "too large"},
+		{"", `{"metadata": ["owner"]}`, "expected a JSON object"},
+	} {
+		r := httptest.NewRequest("POST", "/certcreate/fanatics.com"+tc.query, strings.NewReader(tc.body))
+		if tc.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tc.err) {
+			t.Errorf("%s %s: expected 400 %q, got %d %s", tc.query, tc.body, tc.err, w.Code, w.Body)
+		}
+	}
+	if n, err := db.Count(); n != 0 || err != nil {
+		t.Errorf("expected nothing stored, got %d %v", n, err)
+	}
+}
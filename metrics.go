@@ -0,0 +1,123 @@
+package CertificateService
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	//imported package, run go get github.com/prometheus/client_golang/prometheus
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+metrics are the prometheus metrics of a CertificateService, exposed at /metrics. They are
+registered on a registry of their own, so several services in one process don't collide.
+*/
+type metrics struct {
+	registry *prometheus.Registry
+	// certs issued by createCert
+	created prometheus.Counter
+	// create requests by result: ok or error
+	creates *prometheus.CounterVec
+	// retrieve requests by result: trusted, expired, revoked, not_found or error
+	retrieves *prometheus.CounterVec
+	// requests rejected for an invalid domain name
+	rejected prometheus.Counter
+	// failed calls to redis by operation: get or set
+	redisErrors *prometheus.CounterVec
+	// latency of calls to redis by operation
+	redisDuration *prometheus.HistogramVec
+	// latency of http requests by route
+	requestDuration *prometheus.HistogramVec
+	// http requests and RPCs whose handler panicked
+	panics prometheus.Counter
+	// certs expiring soon as of the last sweep, by kind: server or domain
+	expiring *prometheus.GaugeVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		created: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "certservice_certs_created_total",
+			Help: "Certificates issued, including renewals of the server certificate.",
+		}),
+		creates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "certservice_create_requests_total",
+			Help: "Create requests by result.",
+		}, []string{"result"}),
+		retrieves: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "certservice_retrieve_requests_total",
+			Help: "Retrieve requests by result.",
+		}, []string{"result"}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "certservice_invalid_domains_total",
+			Help: "Requests rejected for an invalid domain name.",
+		}),
+		redisErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "certservice_redis_errors_total",
+			Help: "Failed calls to redis by operation.",
+		}, []string{"op"}),
+		redisDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "certservice_redis_duration_seconds",
+			Help:    "Latency of calls to redis by operation.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"op"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "certservice_http_request_duration_seconds",
+			Help:    "Latency of http requests by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "certservice_panics_total",
+			Help: "HTTP requests and RPCs whose handler panicked.",
+		}),
+		expiring: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "certservice_certs_expiring",
+			Help: "Certificates expiring within the configured window as of the last sweep, the server's own or users' domains.",
+		}, []string{"kind"}),
+	}
+	m.registry.MustRegister(m.created, m.creates, m.retrieves, m.rejected, m.redisErrors, m.redisDuration, m.requestDuration, m.panics, m.expiring)
+	return m
+}
+
+/*
+observeRedis records the latency of a call to redis for op that started at start, and counts
+it as failed if err is anything other than the domain not being found.
+*/
+func (m *metrics) observeRedis(op string, start time.Time, err error) {
+	m.redisDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ErrDomainNotFound) {
+		m.redisErrors.WithLabelValues(op).Inc()
+	}
+}
+
+/*
+observeRedis records a call to redis for op that started at start, as metrics.observeRedis
+does, and logs it as a warning, with attrs such as the domain, if it took longer than
+Config.SlowRedisThreshold.
+*/
+func (db *dbConn) observeRedis(op string, start time.Time, err error, attrs ...any) {
+	db.metrics.observeRedis(op, start, err)
+	if took := time.Since(start); db.slowRedis > 0 && took > db.slowRedis {
+		db.logger.Warn("a redis call was slow", append([]any{"op", op, "took", took}, attrs...)...)
+	}
+}
+
+// handler serves the metrics in the prometheus text format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// timeRoute is a Middleware recording the latency of every request under route.
+func (m *metrics) timeRoute(route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func(start time.Time) {
+				m.requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+			}(time.Now())
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package CertificateService
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetrics checks requests are counted and exposed at /metrics, separately for each service.
+func TestMetrics(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	for _, path := range []string{"/certcreate/fanatics.com", "/cert/fanatics.com", "/cert/missing.com", "/cert/-invalid"} {
+		db.httpHandler(httptest.NewRecorder(), newRequest(path))
+	}
+	if _, err := db.storeCert(context.Background(), "expired.com", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	db.retrieve(context.Background(), "expired.com")
+
+	counts := map[string]float64{
+		"created":   testutil.ToFloat64(db.metrics.created),
+		"create ok": testutil.ToFloat64(db.metrics.creates.WithLabelValues("ok")),
+		"trusted":   testutil.ToFloat64(db.metrics.retrieves.WithLabelValues("trusted")),
+		"expired":   testutil.ToFloat64(db.metrics.retrieves.WithLabelValues("expired")),
+		"not found": testutil.ToFloat64(db.metrics.retrieves.WithLabelValues("not_found")),
+		"rejected":  testutil.ToFloat64(db.metrics.rejected),
+	}
+	expected := map[string]float64{"created": 2, "create ok": 1, "trusted": 1, "expired": 1, "not found": 1, "rejected": 1}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Errorf("expected %v %s, got %v", count, name, counts[name])
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `certservice_http_request_duration_seconds_count{route="retrieve"} 3`) {
+		t.Errorf("expected the request latencies at /metrics, got %s", body)
+	}
+
+	// a second service has metrics of its own
+	if other := newFakeDB(newFakeRedis()); testutil.ToFloat64(other.metrics.created) != 0 {
+		t.Error("expected the metrics of each service to be separate")
+	}
+}
+
+// TestSlowRedis checks calls to redis over the threshold are logged with their operation and domain.
+func TestSlowRedis(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	db := newFakeDBWithConfig(newFakeRedis(), Config{Logger: logger, SlowRedisThreshold: time.Nanosecond})
+	db.getCert(context.Background(), "fanatics.com")
+	if !strings.Contains(logs.String(), `level=WARN msg="a redis call was slow" op=get`) || !strings.Contains(logs.String(), "domain=fanatics.com") {
+		t.Errorf("expected the slow get logged, got %q", logs.String())
+	}
+
+	logs.Reset()
+	db = newFakeDBWithConfig(newFakeRedis(), Config{Logger: logger})
+	db.getCert(context.Background(), "fanatics.com")
+	db.PingRedis(context.Background())
+	if strings.Contains(logs.String(), "slow") {
+		t.Errorf("expected nothing logged without a threshold, got %q", logs.String())
+	}
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), SlowRedisThreshold: -time.Second}); err == nil {
+		t.Error("expected a negative threshold to be rejected")
+	}
+}
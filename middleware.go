@@ -0,0 +1,64 @@
+package CertificateService
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+/*
+Middleware wraps an http.Handler in another, to do something before or after every request it
+serves, such as logging it or checking it is allowed. Config.Middleware adds your own around the
+http API.
+*/
+type Middleware func(http.Handler) http.Handler
+
+// chain wraps h in middleware, the first outermost, so it sees each request first.
+func chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+/*
+middleware is the chain around the routes of the http API: the access log, then panic
+recovery, so a recovered request is logged with its 500, then the CORS policy, so preflights are
+answered before anything configured sees them, then compression, then identifying the actor
+for the audit log, and last Config.Middleware in order.
+*/
+func (db *dbConn) middleware(cfg Config) []Middleware {
+	builtin := []Middleware{newAccessLog(cfg, db.clientIPs).wrap, db.recoverPanics, newCORS(cfg.CORSOrigins).wrap, newCompression(cfg).wrap, db.identify}
+	return append(builtin, cfg.Middleware...)
+}
+
+/*
+recoverPanics returns next with a panic in any request recovered, logged with its stack and
+answered with 500 Internal Server Error if nothing was written yet, instead of the server
+dropping the connection. http.ErrAbortHandler, which an aborted export panics with to cut its
+response short, is passed on up to the server.
+*/
+func (db *dbConn) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			db.panicked(err, "method", r.Method, "path", r.URL.Path)
+			if rec.status == 0 {
+				http.Error(rec, "internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// panicked counts a recovered panic, err, and logs it at error level with attrs and its stack.
+func (db *dbConn) panicked(err any, attrs ...any) {
+	db.metrics.panics.Inc()
+	db.logger.Error("a request panicked", append(attrs, "err", err, "stack", string(debug.Stack()))...)
+}
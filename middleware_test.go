@@ -0,0 +1,96 @@
+package CertificateService
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMiddleware checks configured middleware wraps the http API in order, inside the CORS policy.
+func TestMiddleware(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	db := newFakeDBWithConfig(newFakeRedis(), Config{
+		Middleware:  []Middleware{tag("first"), tag("second")},
+		CORSOrigins: []string{"https://app.example.com"},
+	})
+
+	w := httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/certcreate/fanatics.com"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 through the middleware, got %d", w.Code)
+	}
+	if got := strings.Join(order, ","); got != "first,second" {
+		t.Errorf("expected the middleware to run first to last, got %s", got)
+	}
+
+	// a preflight is answered by the CORS policy before the middleware sees it
+	order = nil
+	r := httptest.NewRequest("OPTIONS", "/certcreate/fanatics.com", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	db.httpHandler(httptest.NewRecorder(), r)
+	if len(order) != 0 {
+		t.Errorf("expected the preflight to skip the middleware, got %v", order)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), Middleware: []Middleware{nil}}); err == nil {
+		t.Error("expected a nil middleware to be rejected")
+	}
+}
+
+// TestRecoverPanics checks a panicking request is logged, counted and answered 500, while an abort still aborts.
+func TestRecoverPanics(t *testing.T) {
+	var logs bytes.Buffer
+	panics := func(v interface{}) Middleware {
+		return func(http.Handler) http.Handler {
+			return http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic(v) })
+		}
+	}
+	db := newFakeDBWithConfig(newFakeRedis(), Config{
+		Logger:     slog.New(slog.NewTextHandler(&logs, nil)),
+		Middleware: []Middleware{panics("bad decode")},
+	})
+	w := httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert/fanatics.com"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for a panic, got %d", w.Code)
+	}
+	if !strings.Contains(logs.String(), "bad decode") || !strings.Contains(logs.String(), "stack=") {
+		t.Errorf("expected the panic logged with its stack, got %s", logs.String())
+	}
+	if n := testutil.ToFloat64(db.metrics.panics); n != 1 {
+		t.Errorf("expected 1 panic counted, got %v", n)
+	}
+
+	// a panic in a route, here from the store, is recovered the same way
+	fake := newFakeRedis()
+	fake.fail = func(cmd string) error { panic("short read") }
+	db = newFakeDB(fake)
+	w = httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert/fanatics.com"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for a panicking route, got %d", w.Code)
+	}
+
+	db = newFakeDBWithConfig(newFakeRedis(), Config{Middleware: []Middleware{panics(http.ErrAbortHandler)}})
+	defer func() {
+		if recover() != http.ErrAbortHandler {
+			t.Error("expected http.ErrAbortHandler to reach the server")
+		}
+	}()
+	db.httpHandler(httptest.NewRecorder(), newRequest("/cert/fanatics.com"))
+}
@@ -0,0 +1,64 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// clientCertSubject is the subject of the verified client cert of a connection, empty if it sent none.
+func clientCertSubject(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.String()
+}
+
+// rpcClientCertSubject is clientCertSubject for the connection of a gRPC call.
+func rpcClientCertSubject(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	return clientCertSubject(&info.State)
+}
+
+/*
+requireClientCert returns next refusing any request without a client cert verified against
+Config.ClientCAs with 403 Forbidden. Under RequireAndVerifyClientCert the handshake already
+refused it; under VerifyClientCertIfGiven only the routes wrapped in it need one. Without
+ClientCAs it is next.
+*/
+func (db *dbConn) requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	if db.clientCAs == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if clientCertSubject(r.TLS) == "" {
+			http.Error(w, "a verified client certificate is required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// setClientAuth makes config ask for client certs as configured, verified against clientCAs.
+func (db *dbConn) setClientAuth(config *tls.Config) {
+	if db.clientCAs == nil {
+		return
+	}
+	config.ClientCAs = db.clientCAs
+	config.ClientAuth = db.clientAuth
+}
+
+// validClientAuth reports whether mode verifies the client certs it accepts, as ClientCAs needs.
+func validClientAuth(mode tls.ClientAuthType) bool {
+	return mode == tls.RequireAndVerifyClientCert || mode == tls.VerifyClientCertIfGiven
+}
@@ -0,0 +1,120 @@
+package CertificateService
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCert issues a cert for name, by parent and its key, or self-signed for a CA if parent is nil.
+func newTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// TestClientCerts checks the mutating routes need a client cert verified against the CAs, whose subject is audited.
+func TestClientCerts(t *testing.T) {
+	ca, caKey := newTestCert(t, "test CA", nil, nil)
+	client, clientKey := newTestCert(t, "client", ca, caKey)
+	stranger, strangerKey := newTestCert(t, "stranger", nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	var audits bytes.Buffer
+	db := newFakeDBWithConfig(newFakeRedis(), Config{
+		HTTPS:       true,
+		ClientCAs:   pool,
+		ClientAuth:  tls.VerifyClientCertIfGiven,
+		AuditLogger: slog.New(slog.NewTextHandler(&audits, nil)),
+	})
+	db.renewCertServer(0)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", db.serverTLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: db.Handler()}
+	go server.Serve(ln)
+	defer server.Close()
+
+	send := func(method, path string, cert *x509.Certificate, key *ecdsa.PrivateKey) (int, error) {
+		config := &tls.Config{InsecureSkipVerify: true}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+		req, _ := http.NewRequest(method, "https://"+ln.Addr().String()+path, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if code, err := send("GET", "/cert/fanatics.com", nil, nil); err != nil || code != http.StatusOK {
+		t.Errorf("expected a retrieve without a client cert to be allowed, got %d %v", code, err)
+	}
+	if code, err := send("POST", "/certcreate/fanatics.com", nil, nil); err != nil || code != http.StatusForbidden {
+		t.Errorf("expected a create without a client cert to be 403, got %d %v", code, err)
+	}
+	// the client holds back a cert the server's CAs didn't issue, or the handshake fails if it is sent
+	if code, err := send("POST", "/certcreate/fanatics.com", stranger, strangerKey); err == nil && code != http.StatusForbidden {
+		t.Errorf("expected a client cert of another CA to be refused, got %d", code)
+	}
+	if code, err := send("POST", "/certcreate/fanatics.com", client, clientKey); err != nil || code != http.StatusCreated {
+		t.Errorf("expected a create with a verified client cert to be 201, got %d %v", code, err)
+	}
+	if !strings.Contains(audits.String(), "action=create domain=fanatics.com") || !strings.Contains(audits.String(), `client_cert="CN=client"`) {
+		t.Errorf("expected the create audited with the client cert, got %q", audits.String())
+	}
+
+	// required by default, every handshake needs one
+	db = newFakeDBWithConfig(newFakeRedis(), Config{HTTPS: true, ClientCAs: pool})
+	if db.serverTLSConfig().ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected client certs required by default, got %v", db.serverTLSConfig().ClientAuth)
+	}
+	for _, cfg := range []Config{
+		{ClientCAs: pool},
+		{HTTPS: true, ClientCAs: pool, ClientAuth: tls.RequestClientCert},
+		{HTTPS: true, ClientAuth: tls.RequireAndVerifyClientCert},
+	} {
+		cfg.Storage = NewMemoryStorage()
+		if _, err := NewCertificateServiceWithConfig(cfg); err == nil {
+			t.Errorf("expected client auth %v with CAs %v and HTTPS %v to be rejected", cfg.ClientAuth, cfg.ClientCAs != nil, cfg.HTTPS)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package CertificateService
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	//imported package, run go get golang.org/x/time/rate
+	"golang.org/x/time/rate"
+)
+
+/*
+rateLimiter is a token bucket per client IP: every client may make burst requests at once,
+refilled at perSecond requests a second. A nil rateLimiter doesn't limit anything.
+*/
+type rateLimiter struct {
+	perSecond rate.Limit
+	burst     int
+	// finds the client IP a request came from
+	ips *clientIPs
+	now func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+	pruned  time.Time
+}
+
+// rateClient is the bucket of a single client IP and when it was last used.
+type rateClient struct {
+	bucket   *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns a limiter allowing each client perSecond requests a second, or nil if perSecond is 0.
+func newRateLimiter(perSecond float64, burst int, ips *clientIPs) *rateLimiter {
+	if perSecond == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		perSecond: rate.Limit(perSecond),
+		burst:     burst,
+		ips:       ips,
+		now:       time.Now,
+		clients:   make(map[string]*rateClient),
+	}
+}
+
+/*
+limit wraps next so a client over its rate is answered 429 Too Many Requests, with a
+Retry-After header saying how many seconds until its next request would be allowed.
+*/
+func (l *rateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wait := l.reserve(l.ips.of(r)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// reserve takes a token from the bucket of ip, or returns how long until one is available.
+func (l *rateLimiter) reserve(ip string) time.Duration {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &rateClient{bucket: rate.NewLimiter(l.perSecond, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+	reservation := client.bucket.ReserveN(now, 1)
+	if !reservation.OK() {
+		return time.Duration(float64(time.Second) / float64(l.perSecond))
+	}
+	if wait := reservation.DelayFrom(now); wait > 0 {
+		reservation.CancelAt(now)
+		return wait
+	}
+	return 0
+}
+
+/*
+prune forgets, at most once a minute, the clients whose buckets have refilled since they were
+last seen, since a new bucket would be the same. l.mu must be held.
+*/
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	refill := time.Duration(float64(l.burst) / float64(l.perSecond) * float64(time.Second))
+	for ip, client := range l.clients {
+		if now.Sub(client.lastSeen) > refill {
+			delete(l.clients, ip)
+		}
+	}
+}
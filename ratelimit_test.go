@@ -0,0 +1,68 @@
+package CertificateService
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter checks each client IP gets its own bucket, refilled over time, and over limit requests are told when to retry.
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(1, 2, &clientIPs{})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	handler := limiter.limit(ok)
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/certcreate/fanatics.com", nil)
+		r.RemoteAddr = remoteAddr
+		// ignored unless X-Forwarded-For is trusted
+		r.Header.Set("X-Forwarded-For", "10.0.0.1")
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send("192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst was limited", i)
+		}
+	}
+	rec := send("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 retrying after 1 second, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := send("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("another client shouldn't share the limit, got %d", rec.Code)
+	}
+	now = now.Add(time.Second)
+	if rec := send("192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("expected a token to be refilled after a second, got %d", rec.Code)
+	}
+
+	var nilLimiter *rateLimiter
+	if nilLimiter.limit(ok) == nil {
+		t.Error("a nil limiter should pass requests through")
+	}
+}
+
+// TestCreateRateLimit checks the configured limits apply to create and retrieve separately.
+func TestCreateRateLimit(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{CreateRateLimit: 0.1, CreateRateBurst: 1})
+	codes := func(path string) []int {
+		var got []int
+		for i := 0; i < 3; i++ {
+			rec := httptest.NewRecorder()
+			db.httpHandler(rec, newRequest(path))
+			got = append(got, rec.Code)
+		}
+		return got
+	}
+	if got := codes("/certcreate/fanatics.com"); got[0] != http.StatusCreated || got[1] != http.StatusTooManyRequests || got[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the second and third create to be limited, got %v", got)
+	}
+	if got := codes("/cert/fanatics.com"); got[2] != http.StatusOK {
+		t.Errorf("retrieval shouldn't be limited, got %v", got)
+	}
+}
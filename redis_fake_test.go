@@ -0,0 +1,565 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+fakeRedis is an in-process stand-in for the handful of redis commands this package uses,
+so tests can exercise the service without a live redis server. Keys are hashes, which may
+carry an expiry like a real redis key. A sorted set is kept as a hash of each member's score,
+a list as a hash of each element by index, and a string as a hash of its value under "". fail, when set, is consulted before every command
+and can inject an error for it, or block to simulate a hung redis.
+*/
+type fakeRedis struct {
+	mu      sync.Mutex
+	hashes  map[string]map[string][]byte
+	expires map[string]time.Time
+	calls   map[string]int
+	fail    func(cmd string) error
+	// replica makes ROLE report a replica instead of the master
+	replica bool
+	// masters are the addresses a Sentinel reports, by master name
+	masters map[string]string
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		hashes:  make(map[string]map[string][]byte),
+		expires: make(map[string]time.Time),
+		calls:   make(map[string]int),
+		masters: make(map[string]string),
+	}
+}
+
+// newFakeDB returns a dbConn using the default configuration backed by fake.
+func newFakeDB(fake *fakeRedis) *dbConn {
+	return newFakeDBWithConfig(fake, Config{})
+}
+
+// newFakeDBWithConfig returns a dbConn using cfg backed by fake.
+func newFakeDBWithConfig(fake *fakeRedis, cfg Config) *dbConn {
+	// the namespace is applied to the fake's storage, as the constructor would to the default one
+	cfg.Storage = NewNamespacedRedisStorage(newFakePool(fake), cfg.KeyLayout, cfg.Namespace)
+	cfg.Namespace = ""
+	// requests aren't logged, the access log has a test of its own
+	cfg.DisableAccessLog = true
+	svc, err := NewCertificateServiceWithConfig(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return svc.(*dbConn)
+}
+
+// newFakeStorage returns the redis Storage, laid out as selected by layout, backed by fake.
+func newFakeStorage(fake *fakeRedis, layout KeyLayout) Storage {
+	return NewRedisStorage(newFakePool(fake), layout)
+}
+
+// newFakePool returns a redis pool whose connections are backed by fake.
+func newFakePool(fake *fakeRedis) *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) { return &fakeConn{f: fake}, nil },
+	}
+}
+
+// count returns how many times cmd has been issued.
+func (f *fakeRedis) count(cmd string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[cmd]
+}
+
+// set stores a raw hash field, bypassing the service.
+func (f *fakeRedis) set(key, field string, value []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hashes[key] == nil {
+		f.hashes[key] = make(map[string][]byte)
+	}
+	f.hashes[key][field] = value
+}
+
+// keys returns the sorted names of every live key.
+func (f *fakeRedis) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.hashes {
+		if f.live(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// live reports whether key exists, evicting it if it has expired. f.mu must be held.
+func (f *fakeRedis) live(key string) bool {
+	if deadline, ok := f.expires[key]; ok && !time.Now().Before(deadline) {
+		delete(f.hashes, key)
+		delete(f.expires, key)
+	}
+	_, ok := f.hashes[key]
+	return ok
+}
+
+// check counts cmd and reports the error injected for it, if any.
+func (f *fakeRedis) check(cmd string) error {
+	f.mu.Lock()
+	f.calls[cmd]++
+	fail := f.fail
+	f.mu.Unlock()
+	if fail != nil {
+		return fail(cmd)
+	}
+	return nil
+}
+
+func (f *fakeRedis) do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := f.check(cmd); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	arg := func(i int) string { return string(toBytes(args[i])) }
+	hash := func() map[string][]byte {
+		if !f.live(arg(0)) {
+			return nil
+		}
+		return f.hashes[arg(0)]
+	}
+
+	switch cmd {
+	case "PING":
+		return "PONG", nil
+	case "ROLE":
+		if f.replica {
+			return []interface{}{[]byte("slave"), []byte("127.0.0.1"), int64(6379), []byte("connected"), int64(0)}, nil
+		}
+		return []interface{}{[]byte("master"), int64(0), []interface{}{}}, nil
+	case "SENTINEL":
+		addr, ok := f.masters[arg(1)]
+		if !ok || arg(0) != "get-master-addr-by-name" {
+			return nil, nil
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		return []interface{}{[]byte(host), []byte(port)}, nil
+	case "SELECT":
+		// every database shares the one keyspace, but an index out of the default 16 fails as in redis
+		if n, err := strconv.Atoi(arg(0)); err != nil || n < 0 || n >= 16 {
+			return nil, redis.Error("ERR DB index is out of range")
+		}
+		return "OK", nil
+	case "SET":
+		f.hashes[arg(0)] = map[string][]byte{"": toBytes(args[1])}
+		delete(f.expires, arg(0))
+		if len(args) == 4 && arg(2) == "PX" {
+			ms, _ := strconv.ParseInt(arg(3), 10, 64)
+			f.expires[arg(0)] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "OK", nil
+	case "GET":
+		if h := hash(); h != nil {
+			return h[""], nil
+		}
+		return nil, nil
+	case "HMSET", "HSET":
+		h := hash()
+		if h == nil {
+			h = make(map[string][]byte)
+			f.hashes[arg(0)] = h
+		}
+		added := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := h[arg(i)]; !ok {
+				added++
+			}
+			h[arg(i)] = toBytes(args[i+1])
+		}
+		if cmd == "HMSET" {
+			return "OK", nil
+		}
+		return int64(added), nil
+	case "HGET":
+		v, ok := hash()[arg(1)]
+		if !ok {
+			return nil, nil
+		}
+		return v, nil
+	case "HMGET":
+		h := hash()
+		reply := make([]interface{}, 0, len(args)-1)
+		for i := 1; i < len(args); i++ {
+			if v, ok := h[arg(i)]; ok {
+				reply = append(reply, v)
+			} else {
+				reply = append(reply, nil)
+			}
+		}
+		return reply, nil
+	case "HDEL":
+		h := hash()
+		removed := 0
+		for i := 1; i < len(args); i++ {
+			if _, ok := h[arg(i)]; ok {
+				delete(h, arg(i))
+				removed++
+			}
+		}
+		if h != nil && len(h) == 0 {
+			delete(f.hashes, arg(0))
+		}
+		return int64(removed), nil
+	case "HLEN":
+		return int64(len(hash())), nil
+	case "HGETALL":
+		var reply []interface{}
+		for field, v := range hash() {
+			reply = append(reply, []byte(field), v)
+		}
+		return reply, nil
+	case "HSCAN":
+		// the cursor is an offset into the sorted fields, COUNT is honored exactly
+		h := hash()
+		fields := make([]string, 0, len(h))
+		for field := range h {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		cursor, count := scanArgs(args[1:])
+		var page []interface{}
+		for ; cursor < len(fields) && len(page) < count*2; cursor++ {
+			page = append(page, []byte(fields[cursor]), h[fields[cursor]])
+		}
+		if cursor >= len(fields) {
+			cursor = 0
+		}
+		return []interface{}{[]byte(strconv.Itoa(cursor)), page}, nil
+	case "SCAN":
+		// like HSCAN the cursor is an offset into the sorted keys, COUNT keys are examined per call
+		var keys []string
+		for key := range f.hashes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		cursor, count := scanArgs(args)
+		match := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if string(toBytes(args[i])) == "MATCH" {
+				match = string(toBytes(args[i+1]))
+			}
+		}
+		var page []interface{}
+		for examined := 0; cursor < len(keys) && examined < count; cursor, examined = cursor+1, examined+1 {
+			if ok, _ := path.Match(match, keys[cursor]); ok && f.live(keys[cursor]) {
+				page = append(page, []byte(keys[cursor]))
+			}
+		}
+		if cursor >= len(keys) {
+			cursor = 0
+		}
+		return []interface{}{[]byte(strconv.Itoa(cursor)), page}, nil
+	case "DEL":
+		removed := 0
+		for i := range args {
+			if f.live(arg(i)) {
+				delete(f.hashes, arg(i))
+				delete(f.expires, arg(i))
+				removed++
+			}
+		}
+		return int64(removed), nil
+	case "EXISTS":
+		if f.live(arg(0)) {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "ZADD":
+		h := hash()
+		if h == nil {
+			h = make(map[string][]byte)
+			f.hashes[arg(0)] = h
+		}
+		added := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := h[arg(i+1)]; !ok {
+				added++
+			}
+			h[arg(i+1)] = toBytes(args[i])
+		}
+		return int64(added), nil
+	case "ZSCORE":
+		v, ok := hash()[arg(1)]
+		if !ok {
+			return nil, nil
+		}
+		return v, nil
+	case "ZREMRANGEBYSCORE":
+		h := hash()
+		// only the -inf lower bound this package uses is supported
+		max, _ := strconv.ParseFloat(arg(2), 64)
+		removed := 0
+		for member, v := range h {
+			if score, _ := strconv.ParseFloat(string(v), 64); score <= max {
+				delete(h, member)
+				removed++
+			}
+		}
+		if h != nil && len(h) == 0 {
+			delete(f.hashes, arg(0))
+		}
+		return int64(removed), nil
+	case "LPUSH":
+		list := f.list(arg(0))
+		for i := 1; i < len(args); i++ {
+			list = append([][]byte{toBytes(args[i])}, list...)
+		}
+		f.setList(arg(0), list)
+		return int64(len(list)), nil
+	case "LTRIM":
+		start, stop := listRange(f.list(arg(0)), arg(1), arg(2))
+		f.setList(arg(0), f.list(arg(0))[start:stop])
+		return "OK", nil
+	case "LRANGE":
+		list := f.list(arg(0))
+		start, stop := listRange(list, arg(1), arg(2))
+		reply := make([]interface{}, 0, stop-start)
+		for _, v := range list[start:stop] {
+			reply = append(reply, v)
+		}
+		return reply, nil
+	case "PEXPIREAT":
+		if !f.live(arg(0)) {
+			return int64(0), nil
+		}
+		ms, _ := strconv.ParseInt(arg(1), 10, 64)
+		f.expires[arg(0)] = time.UnixMilli(ms)
+		return int64(1), nil
+	}
+	return nil, errors.New("fakeRedis: unsupported command " + cmd)
+}
+
+// list returns the elements of the list at key, in order. f.mu must be held.
+func (f *fakeRedis) list(key string) [][]byte {
+	if !f.live(key) {
+		return nil
+	}
+	h := f.hashes[key]
+	list := make([][]byte, len(h))
+	for i := range list {
+		list[i] = h[strconv.Itoa(i)]
+	}
+	return list
+}
+
+// setList replaces the list at key, deleting the key if it is empty. f.mu must be held.
+func (f *fakeRedis) setList(key string, list [][]byte) {
+	if len(list) == 0 {
+		delete(f.hashes, key)
+		return
+	}
+	h := make(map[string][]byte, len(list))
+	for i, v := range list {
+		h[strconv.Itoa(i)] = v
+	}
+	f.hashes[key] = h
+}
+
+// listRange converts the inclusive, possibly negative, start and stop of LRANGE or LTRIM into a slice range of list.
+func listRange(list [][]byte, start, stop string) (int, int) {
+	from, _ := strconv.Atoi(start)
+	to, _ := strconv.Atoi(stop)
+	if from < 0 {
+		from += len(list)
+	}
+	if to < 0 {
+		to += len(list)
+	}
+	from, to = max(from, 0), min(to+1, len(list))
+	if from >= to {
+		return 0, 0
+	}
+	return from, to
+}
+
+// scanArgs reads the cursor and COUNT option of a SCAN style command.
+func scanArgs(args []interface{}) (int, int) {
+	cursor, _ := strconv.Atoi(string(toBytes(args[0])))
+	count := 10
+	for i := 1; i+1 < len(args); i += 2 {
+		if string(toBytes(args[i])) == "COUNT" {
+			count, _ = strconv.Atoi(string(toBytes(args[i+1])))
+		}
+	}
+	return cursor, count
+}
+
+// toBytes converts a command argument the way redigo would write it on the wire.
+func toBytes(arg interface{}) []byte {
+	switch v := arg.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case int:
+		return []byte(strconv.Itoa(v))
+	case int64:
+		return []byte(strconv.FormatInt(v, 10))
+	}
+	panic("fakeRedis: unsupported argument type")
+}
+
+/*
+fakeConn is the redis.Conn handed out by the pool of a fake backed dbConn. It follows the
+redis protocol closely enough for pipelines and transactions: Send queues a command, Flush
+sends the queue, Receive runs the next sent command and reads its reply, and Do does all
+three. Like a real server, a sent command only blocks the reader of its reply.
+*/
+type fakeConn struct {
+	f       *fakeRedis
+	pending [][]interface{}
+	sent    [][]interface{}
+	// commands queued between MULTI and EXEC, nil outside a transaction
+	multi [][]interface{}
+	// set once a command is abandoned, as redigo closes the connection
+	broken atomic.Bool
+}
+
+var errFakeConnBroken = errors.New("fakeRedis: connection closed after an abandoned command")
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Err() error {
+	if c.broken.Load() {
+		return errFakeConnBroken
+	}
+	return nil
+}
+
+func (c *fakeConn) Send(cmd string, args ...interface{}) error {
+	if c.broken.Load() {
+		return errFakeConnBroken
+	}
+	c.pending = append(c.pending, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (c *fakeConn) Flush() error {
+	if c.broken.Load() {
+		return errFakeConnBroken
+	}
+	if len(c.pending) > 0 {
+		// every flush of pending commands is a round trip
+		c.f.check("(flush)")
+	}
+	c.sent = append(c.sent, c.pending...)
+	c.pending = nil
+	return nil
+}
+
+func (c *fakeConn) Receive() (interface{}, error) {
+	if c.broken.Load() {
+		return nil, errFakeConnBroken
+	}
+	if len(c.sent) == 0 {
+		return nil, errors.New("fakeRedis: nothing sent")
+	}
+	q := c.sent[0]
+	c.sent = c.sent[1:]
+
+	cmd := q[0].(string)
+	switch {
+	case cmd == "MULTI":
+		c.multi = [][]interface{}{}
+		return "OK", nil
+	case cmd == "EXEC":
+		defer func() { c.multi = nil }()
+		if err := c.f.check(cmd); err != nil {
+			return nil, err
+		}
+		var transaction []interface{}
+		for _, t := range c.multi {
+			reply, err := c.f.do(t[0].(string), t[1:]...)
+			if err != nil {
+				reply = redis.Error(err.Error())
+			}
+			transaction = append(transaction, reply)
+		}
+		return transaction, nil
+	case c.multi != nil:
+		c.multi = append(c.multi, q)
+		return "QUEUED", nil
+	}
+	return c.f.do(cmd, q[1:]...)
+}
+
+/*
+DoContext is Do, abandoned with ctx.Err() once ctx is done. Like redigo, the connection is
+broken afterwards, the abandoned command may still be running.
+*/
+func (c *fakeConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return c.withContext(ctx, func() (interface{}, error) { return c.Do(cmd, args...) })
+}
+
+// ReceiveContext is Receive, abandoned with ctx.Err() once ctx is done.
+func (c *fakeConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return c.withContext(ctx, c.Receive)
+}
+
+func (c *fakeConn) withContext(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		c.broken.Store(true)
+		return nil, err
+	}
+	var reply interface{}
+	var err error
+	done := make(chan struct{})
+	go func() {
+		reply, err = fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return reply, err
+	case <-ctx.Done():
+		c.broken.Store(true)
+		return nil, ctx.Err()
+	}
+}
+
+/*
+Do sends cmd and reads every outstanding reply, returning the last one. As with redigo, the
+first redis error among the earlier replies is returned alongside it. An empty cmd only
+flushes and reads the outstanding replies.
+*/
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.broken.Load() {
+		return nil, errFakeConnBroken
+	}
+	if cmd != "" {
+		c.Send(cmd, args...)
+	}
+	c.Flush()
+	var reply interface{}
+	var err error
+	for len(c.sent) > 0 {
+		v, e := c.Receive()
+		if _, ok := e.(redis.Error); (ok || len(c.sent) == 0) && err == nil {
+			err = e
+		}
+		reply = v
+	}
+	return reply, err
+}
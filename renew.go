@@ -0,0 +1,109 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+ErrDomainExists is returned by a create of a domain that already has a cert under
+Config.StrictCreate, which must be renewed instead. The http API answers it with 409 Conflict.
+*/
+var ErrDomainExists = errors.New("the domain already has a cert, renew it instead")
+
+// issueMode is whether an issuance may create the cert of a new domain, renew a stored one, or either.
+type issueMode int
+
+const (
+	// createOrRenew issues the cert whether or not the domain has one, as a create always has.
+	createOrRenew issueMode = iota
+
+	// createOnly fails with ErrDomainExists for a domain that has a cert.
+	createOnly
+
+	// renewOnly fails with ErrDomainNotFound for a domain without a cert.
+	renewOnly
+)
+
+// createMode is the issueMode of a create over http or gRPC, createOnly under Config.StrictCreate.
+func (db *dbConn) createMode() issueMode {
+	if db.strictCreate {
+		return createOnly
+	}
+	return createOrRenew
+}
+
+// modeOf is the issueMode of a request to domainHandler, a RENEW or a CREATE.
+func (db *dbConn) modeOf(getorset string) issueMode {
+	if getorset == "RENEW" {
+		return renewOnly
+	}
+	return db.createMode()
+}
+
+/*
+modeScope keeps the idempotent results of renews apart from those of creates, which are kept
+by domain alone as they always have been.
+*/
+func modeScope(mode issueMode) string {
+	if mode == renewOnly {
+		return "renew\x00"
+	}
+	return ""
+}
+
+// auditAction is how an issuance is audited, a create of a new domain or a renew of a stored one.
+func auditAction(created bool) string {
+	if created {
+		return "create"
+	}
+	return "renew"
+}
+
+/*
+checkMode fails an issuance of domainName that mode doesn't allow, looking up on the master
+whether the domain has a cert. It runs holding the domain's slot in db.creates, as the strict
+check of a batch does, so it can't race another create within the service, though services
+sharing a store can still race each other.
+*/
+func (db *dbConn) checkMode(ctx context.Context, domainName string, mode issueMode) error {
+	if mode == createOrRenew {
+		return nil
+	}
+	ctx, cancel := db.withTimeout(readMaster(ctx))
+	defer cancel()
+	start := time.Now()
+	_, err := db.store.Get(ctx, domainName)
+	db.observeRedis("get", start, err, "domain", domainName)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrDomainNotFound) {
+		db.logger.Error("reading a cert from redis failed", "domain", domainName, "err", err)
+		return err
+	}
+	switch {
+	case mode == createOnly && exists:
+		return ErrDomainExists
+	case mode == renewOnly && !exists:
+		return ErrDomainNotFound
+	}
+	return nil
+}
+
+/*
+Renew renews the cert of domain, which must already have one, and returns its new expiration
+date. Unlike CreateCert it never creates a domain, failing with ErrDomainNotFound instead. In
+the per-domain key layout redis evicts a cert when it expires, so an expired cert can't be
+renewed there, only created again.
+*/
+func (db *dbConn) Renew(domain string) (time.Time, error) {
+	domain, ok := db.checkDomain(domain)
+	if !ok {
+		return time.Time{}, errors.New("invalid domain name: " + domain)
+	}
+	cert, _, err := db.issue(context.Background(), domain, 0, nil, nil, renewOnly)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,69 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRenew checks a renew only extends a stored cert, and a strict create only creates one.
+func TestRenew(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	send := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest("POST", path, nil))
+		return rec
+	}
+
+	if rec := send("/certrenew/fanatics.com"); rec.Code != http.StatusNotFound || rec.Body.String() != "<h1>domain not found</h1>" {
+		t.Errorf("expected a renew of a new domain to be 404, got %d %q", rec.Code, rec.Body.String())
+	}
+	if _, err := db.Renew("fanatics.com"); !errors.Is(err, ErrDomainNotFound) {
+		t.Errorf("expected Renew of a new domain to be ErrDomainNotFound, got %v", err)
+	}
+	if n := len(db.GetAll()); n != 0 {
+		t.Fatalf("a renew mustn't create a domain, got %d certs", n)
+	}
+
+	send("/certcreate/fanatics.com")
+	if rec := send("/certrenew/fanatics.com"); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "<h1>OK, foo{fanatics.com} renewed") {
+		t.Errorf("expected the renew to succeed, got %d %q", rec.Code, rec.Body.String())
+	}
+	rec := send("/certrenew/fanatics.com?format=json&ttl=2h")
+	var result createResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK || result.Status != "renewed" {
+		t.Errorf("expected a JSON renew, got %d %+v %v", rec.Code, result, err)
+	}
+	if expires, err := db.Renew("fanatics.com"); err != nil || expires.Before(time.Now()) {
+		t.Errorf("expected Renew to extend the cert, got %v %v", expires, err)
+	}
+
+	// a strict create fails for a stored domain, alone or in a batch, but creates new ones
+	db = newFakeDBWithConfig(newFakeRedis(), Config{StrictCreate: true})
+	if rec := send("/certcreate/fanatics.com"); rec.Code != http.StatusCreated {
+		t.Fatalf("expected a strict create of a new domain to be 201, got %d", rec.Code)
+	}
+	if rec := send("/certcreate/fanatics.com"); rec.Code != http.StatusConflict {
+		t.Errorf("expected a strict create of a stored domain to be 409, got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = send("/certcreate/fanatics.com?format=json")
+	var apiErr apiError
+	if json.NewDecoder(rec.Body).Decode(&apiErr); rec.Code != http.StatusConflict || apiErr.Code != CodeAlreadyExists {
+		t.Errorf("expected a JSON 409 already_exists, got %d %+v", rec.Code, apiErr)
+	}
+	if rec := send("/certrenew/fanatics.com"); rec.Code != http.StatusOK {
+		t.Errorf("expected the renew to succeed under a strict create, got %d", rec.Code)
+	}
+	batch := db.createBatch(context.Background(), []string{"fanatics.com", "new.com"})
+	if batch[0].Code != CodeAlreadyExists || batch[1].Status != "OK" {
+		t.Errorf("expected the stored domain of the batch to fail, got %+v", batch)
+	}
+	if expires, err := db.CreateCert("fanatics.com"); err != nil || expires.IsZero() {
+		t.Errorf("expected CreateCert to renew under a strict create, got %v %v", expires, err)
+	}
+}
@@ -0,0 +1,118 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// revocation is the JSON response of /revoke and /isrevoked.
+type revocation struct {
+	Domain  string `json:"domain"`
+	Serial  string `json:"serial"`
+	Revoked bool   `json:"revoked"`
+}
+
+/*
+revoke revokes the current cert of domainName, so it is reported as not trusted until it
+expires. Renewing the domain issues a cert with a new serial, which is trusted again. The
+revocation is kept only as long as the cert is valid.
+*/
+func (db *dbConn) revoke(ctx context.Context, domainName string) (*x509.Certificate, error) {
+	cert, err := db.getCert(ctx, domainName)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	err = db.store.Revoke(ctx, serialNumber(cert), cert.NotAfter)
+	db.observeRedis("revoke", start, err, "domain", domainName)
+	if err != nil {
+		db.logger.Error("revoking a cert in redis failed", "domain", domainName, "err", err)
+		return nil, err
+	}
+	db.audit(ctx, "revoke", domainName, "serial", serialNumber(cert))
+	return cert, nil
+}
+
+// revokedSerials reports which of certs have been revoked, keyed by serial number.
+func (db *dbConn) revokedSerials(ctx context.Context, certs []*x509.Certificate) (map[string]bool, error) {
+	serials := make([]string, len(certs))
+	for i, cert := range certs {
+		serials[i] = serialNumber(cert)
+	}
+	return db.revokedIn(ctx, serials)
+}
+
+// revokedIn reports which of serials have been revoked, whether or not they are a stored cert's.
+func (db *dbConn) revokedIn(ctx context.Context, serials []string) (map[string]bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	revoked, err := db.store.Revoked(ctx, serials)
+	db.observeRedis("revoked", start, err, "serials", len(serials))
+	if err != nil {
+		db.logger.Error("reading revocations from redis failed", "serials", len(serials), "err", err)
+	}
+	return revoked, err
+}
+
+// revokeHandler revokes the cert of the domain in the path after /revoke/.
+func (db *dbConn) revokeHandler(w http.ResponseWriter, r *http.Request) {
+	db.revocationHandler(w, r, db.basePath+"/revoke/", func(ctx context.Context, domain string) (revocation, error) {
+		cert, err := db.revoke(ctx, domain)
+		if err != nil {
+			return revocation{}, err
+		}
+		return revocation{Domain: domain, Serial: serialNumber(cert), Revoked: true}, nil
+	})
+}
+
+/*
+isRevokedHandler reports whether the cert of the domain in the path after /isrevoked/ has
+been revoked. Only the domain's own cert is checked, not a wildcard covering it.
+*/
+func (db *dbConn) isRevokedHandler(w http.ResponseWriter, r *http.Request) {
+	db.revocationHandler(w, r, db.basePath+"/isrevoked/", func(ctx context.Context, domain string) (revocation, error) {
+		cert, err := db.getCert(ctx, domain)
+		if err != nil {
+			return revocation{}, err
+		}
+		revoked, err := db.revokedSerials(ctx, []*x509.Certificate{cert})
+		if err != nil {
+			return revocation{}, err
+		}
+		return revocation{Domain: domain, Serial: serialNumber(cert), Revoked: revoked[serialNumber(cert)]}, nil
+	})
+}
+
+/*
+revocationHandler validates the domain in the path after prefix, calls fn with its canonical
+form and writes the revocation fn returns as JSON. A domain without a cert is 404 Not Found.
+Errors are answered as JSON too, with an ErrorCode.
+*/
+func (db *dbConn) revocationHandler(w http.ResponseWriter, r *http.Request, prefix string, fn func(ctx context.Context, domain string) (revocation, error)) {
+	domain, ok := pathDomain(w, r, prefix, true)
+	if !ok {
+		return
+	}
+	if domain, ok = db.checkDomain(domain); !ok {
+		writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidDomain, Error: "invalid domain name: " + domain, Reasons: invalidReasons(strings.TrimPrefix(domain, wildcardPrefix))})
+		return
+	}
+	status, err := fn(r.Context(), domain)
+	if errors.Is(err, ErrDomainNotFound) {
+		writeJSONError(w, http.StatusNotFound, apiError{Code: CodeNotFound, Error: "no cert for " + domain})
+		return
+	} else if err != nil {
+		writeJSONError(w, errorStatus(err), apiError{Code: errorCode(err), Error: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,81 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRevoke revokes a cert through /revoke and checks it is no longer trusted, until it is renewed.
+func TestRevoke(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"key"}})
+	send := func(method string, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("X-API-Key", "key")
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, r)
+		return rec
+	}
+	status := func(path string) revocation {
+		rec := send("GET", path)
+		var status revocation
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: %d %s", path, rec.Code, rec.Body)
+		}
+		return status
+	}
+
+	cert, err := db.createCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.createCert(context.Background(), "*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if s := status("/isrevoked/fanatics.com"); s.Revoked || s.Serial != serialNumber(cert) {
+		t.Errorf("expected a cert that isn't revoked, got %+v", s)
+	}
+
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("POST", "/revoke/fanatics.com", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoking without a key to be refused, got %d", rec.Code)
+	}
+	if rec := send("GET", "/revoke/fanatics.com"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected revoking to require POST, got %d", rec.Code)
+	}
+	if rec := send("POST", "/revoke/missing.com"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 revoking a domain without a cert, got %d", rec.Code)
+	}
+	for _, path := range []string{"/revoke/FANATICS.com", "/revoke/*.example.com"} {
+		if rec := send("POST", path); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"revoked":true`) {
+			t.Errorf("%s: unexpected response %d %s", path, rec.Code, rec.Body)
+		}
+	}
+
+	if s := status("/isrevoked/fanatics.com"); !s.Revoked {
+		t.Errorf("expected the cert to be revoked, got %+v", s)
+	}
+	for _, domain := range []string{"fanatics.com", "www.example.com"} {
+		if body, _, trustedUntil, _ := db.retrieve(context.Background(), domain); !strings.Contains(body, " revoked, not trusted") || !trustedUntil.IsZero() {
+			t.Errorf("%s: expected a revoked cert not to be trusted, got %s", domain, body)
+		}
+	}
+	results := db.retrieveBatch(context.Background(), []string{"fanatics.com", "www.example.com"})
+	for _, result := range results {
+		if !strings.Contains(result.Status, " revoked, not trusted") {
+			t.Errorf("expected a revoked cert in the batch, got %s", result.Status)
+		}
+	}
+
+	// the renewed cert has a new serial, which isn't revoked
+	if _, err := db.createCert(context.Background(), "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if body, _, _, _ := db.retrieve(context.Background(), "fanatics.com"); !strings.Contains(body, " valid for ") {
+		t.Errorf("expected the renewed cert to be trusted, got %s", body)
+	}
+}
@@ -0,0 +1,101 @@
+package CertificateService
+
+import (
+	"html"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// usageRoute is a route the usage page lists, and the keys it requires, nil if none.
+type usageRoute struct {
+	method, path, does string
+	keys               apiKeys
+}
+
+/*
+rootHandler answers "/", and any path without a route of its own, with Config.RootPage, a
+redirect to Config.RootRedirect, an empty 200 under Config.DisableRootPage, or else the usage
+page.
+*/
+func (db *dbConn) rootHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case db.disableRootPage:
+	case db.rootRedirect != "":
+		http.Redirect(w, r, db.rootRedirect, http.StatusFound)
+	case db.rootPage != "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, db.rootPage)
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, db.usagePage(r))
+	}
+}
+
+/*
+usagePage lists the routes served, addressed at the host r was sent to, or the listen address
+when it names none, below the base path, and says which need an API key. The admin endpoints
+are only listed when an AdminToken enables them.
+*/
+func (db *dbConn) usagePage(r *http.Request) string {
+	scheme := "http"
+	if db.https || r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if host == "" {
+		host = db.listenAddr
+		if strings.HasPrefix(host, ":") {
+			host = "localhost" + host
+		}
+	}
+	base := html.EscapeString(scheme + "://" + host + db.basePath)
+	creates := "creates or renews the cert of a domain"
+	if db.strictCreate {
+		creates = "creates the cert of a domain that has none"
+	}
+
+	routes := []usageRoute{
+		{"POST", "/certcreate/{domain}", creates, db.createKeys},
+		{"POST", "/certrenew/{domain}", "renews the cert of a domain that has one", db.createKeys},
+		{"GET", "/cert/{domain}", "retrieves the cert of a domain", db.retrieveKeys},
+		{"POST", "/certcreate", "creates the certs of a batch of domains", db.createKeys},
+		{"GET", "/cert", "retrieves the certs of a batch of domains", db.retrieveKeys},
+		{"GET", "/certs", "lists the stored certs", db.retrieveKeys},
+		{"GET", "/count", "counts the stored certs", db.retrieveKeys},
+		{"GET", "/export", "exports every stored cert", db.createKeys},
+		{"POST", "/import", "imports exported certs", db.createKeys},
+		{"POST", "/revoke/{domain}", "revokes the cert of a domain", db.createKeys},
+		{"GET", "/isrevoked/{domain}", "reports whether the cert of a domain is revoked", db.retrieveKeys},
+		{"GET", "/certhistory/{domain}", "lists the past certs of a domain", db.retrieveKeys},
+		{"GET", "/status/{domain}", "reports whether the cert of a domain is good, revoked or unknown", db.retrieveKeys},
+		{"GET", "/validate/{domain}", "reports whether a domain name is valid", nil},
+		{"GET", "/healthz", "reports whether the service is alive", db.healthKeys},
+		{"GET", "/ready", "reports whether the service can serve certs", db.healthKeys},
+		{"GET", "/version", "reports the version of the service", nil},
+		{"GET", "/metrics", "serves the metrics of the service", nil},
+	}
+	var b strings.Builder
+	b.WriteString("<h1> server is live, Send a valid certification request: GET " + base + "/cert/{domain} to retrieve a cert, or POST " + base + "/certcreate/{domain} to create one </h1>\n<ul>\n")
+	keyed := false
+	for _, route := range routes {
+		b.WriteString("<li>" + route.method + " " + base + route.path + " " + route.does)
+		if route.keys != nil {
+			b.WriteString(" (API key required)")
+			keyed = true
+		}
+		b.WriteString("</li>\n")
+	}
+	if db.adminToken != "" {
+		b.WriteString("<li>GET or PUT " + base + "/admin/lifetime reads or changes the cert lifetime (admin token required)</li>\n")
+		b.WriteString("<li>POST " + base + "/flush deletes every stored cert (admin token required)</li>\n")
+	}
+	b.WriteString("</ul>\n")
+	if keyed {
+		b.WriteString("<p>API keys are sent as Authorization: Bearer {key}, or as X-API-Key: {key}.</p>\n")
+	}
+	if db.adminToken != "" {
+		b.WriteString("<p>The admin token is sent as Authorization: Bearer {token}.</p>\n")
+	}
+	return b.String()
+}
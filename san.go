@@ -0,0 +1,93 @@
+package CertificateService
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxSANs is the most subject alternative names a create may ask for, the limit public CAs apply too.
+const maxSANs = 100
+
+// createBody is the JSON body a create may send, with what it could also give as query parameters.
+type createBody struct {
+	SANs     []string          `json:"sans"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+/*
+readCreateBody reads the body of a create sent with a Content-Type of application/json, or
+returns an empty createBody for any other. A body that isn't a JSON object is answered 400 Bad
+Request and ok is false.
+*/
+func readCreateBody(w http.ResponseWriter, r *http.Request) (body createBody, ok bool) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return body, true
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpError(w, jsonMode(r), http.StatusBadRequest, CodeInvalidRequest, `expected a JSON object such as {"sans": ["www.example.com"]}: `+err.Error())
+		return body, false
+	}
+	return body, true
+}
+
+/*
+requestSANs returns the subject alternative names a create asks its cert to cover besides
+domainName, in their canonical form. They are given as san query parameters, repeated or
+comma separated, ?san=www.example.com,api.example.com, or in the JSON body, fromBody,
+{"sans": ["www.example.com"]}, or both. domainName stays the cert's only key, so retrieving
+one of the names finds nothing. A name that is invalid, or given twice, is answered 400 Bad
+Request, CodeInvalidDomain for an invalid name and CodeInvalidRequest for any other mistake
+in JSON mode, and ok is false.
+*/
+func (db *dbConn) requestSANs(w http.ResponseWriter, r *http.Request, domainName string, fromBody []string) (sans []string, ok bool) {
+	var names []string
+	for _, param := range r.URL.Query()["san"] {
+		for _, name := range strings.Split(param, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	names = append(names, fromBody...)
+	sans, code, err := db.checkSANs(domainName, names)
+	if err != nil {
+		httpError(w, jsonMode(r), http.StatusBadRequest, code, err.Error())
+		return nil, false
+	}
+	return sans, true
+}
+
+/*
+checkSANs returns the canonical form of names, to be added to the cert of domainName, or an
+error and its code for the first that the service doesn't accept as a domain,
+CodeInvalidDomain, or that repeats domainName or another of names, CodeInvalidRequest.
+*/
+func (db *dbConn) checkSANs(domainName string, names []string) ([]string, ErrorCode, error) {
+	if len(names) > maxSANs {
+		return nil, CodeInvalidRequest, fmt.Errorf("at most %d subject alternative names may be given, got %d", maxSANs, len(names))
+	}
+	seen := map[string]bool{canonicalDomain(domainName): true}
+	var sans []string
+	for _, name := range names {
+		san, ok := db.checkDomain(name)
+		if !ok {
+			return nil, CodeInvalidDomain, fmt.Errorf("invalid subject alternative name %q: %s", name, invalidReason(strings.TrimPrefix(san, wildcardPrefix)))
+		}
+		if seen[san] {
+			return nil, CodeInvalidRequest, fmt.Errorf("duplicate subject alternative name %q", name)
+		}
+		seen[san] = true
+		sans = append(sans, san)
+	}
+	return sans, "", nil
+}
+
+// alsoNames lists the names cert covers besides the domain it was issued for, for a retrieve's response.
+func alsoNames(cert *x509.Certificate) string {
+	if len(cert.DNSNames) <= 1 {
+		return ""
+	}
+	return ", also for " + strings.Join(cert.DNSNames[1:], ", ")
+}
@@ -0,0 +1,75 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSANs checks a create adds the requested names to the cert, which a retrieve reports.
+func TestSANs(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	r := httptest.NewRequest("POST", "/certcreate/fanatics.com?san=WWW.fanatics.com,api.fanatics.com", strings.NewReader(`{"sans": ["*.shop.fanatics.com"]}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w := httptest.NewRecorder()
+	db.httpHandler(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body)
+	}
+
+	// parsed back from the stored PEM
+	cert, err := db.getCert(context.Background(), "fanatics.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"fanatics.com", "www.fanatics.com", "api.fanatics.com", "*.shop.fanatics.com"}
+	if !reflect.DeepEqual(cert.DNSNames, expected) || cert.Subject.CommonName != "fanatics.com" {
+		t.Errorf("expected the cert to name %q, got %q %q", expected, cert.Subject.CommonName, cert.DNSNames)
+	}
+	if n, err := db.Count(); n != 1 || err != nil {
+		t.Errorf("expected only the domain to be stored, got %d %v", n, err)
+	}
+
+	w = httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert/fanatics.com?format=json"))
+	var result retrieveResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || !reflect.DeepEqual(result.SANs, expected) {
+		t.Errorf("expected the retrieve to list %q, got %+v %v", expected, result, err)
+	}
+	w = httptest.NewRecorder()
+	db.httpHandler(w, newRequest("/cert/fanatics.com"))
+	if !strings.Contains(w.Body.String(), "also for www.fanatics.com, api.fanatics.com, *.shop.fanatics.com") {
+		t.Errorf("expected the retrieve to list the names, got %s", w.Body)
+	}
+}
+
+// TestSANsRejected checks an invalid or repeated name fails the create with 400 and nothing stored.
+func TestSANsRejected(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	for _, tc := range []struct{ query, body, err string }{
+		{"?san=bad_name.com", "", `invalid subject alternative name "bad_name.com"`},
+		{"?san=www.fanatics.com,WWW.fanatics.com", "", `duplicate subject alternative name "WWW.fanatics.com"`},
+		{"?san=Fanatics.com", "", `duplicate subject alternative name "Fanatics.com"`},
+		{"?san=www.fanatics.com", `{"sans": ["www.fanatics.com"]}`, "duplicate subject alternative name"},
+		{"?san=", "", "invalid subject alternative name"},
+		{"", `{"sans": "www.fanatics.com"}`, "expected a JSON object"},
+	} {
+		r := httptest.NewRequest("POST", "/certcreate/fanatics.com"+tc.query, strings.NewReader(tc.body))
+		if tc.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		db.httpHandler(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tc.err) {
+			t.Errorf("%s %s: expected 400 %q, got %d %s", tc.query, tc.body, tc.err, w.Code, w.Body)
+		}
+	}
+	if n, err := db.Count(); n != 0 || err != nil {
+		t.Errorf("expected nothing stored, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,82 @@
+package CertificateService
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+dialMaster connects to the current master named master, as reported by the first of the
+sentinels that answers. The connection is checked to really be the master, since a Sentinel
+may not have noticed a failover yet.
+*/
+func dialMaster(sentinels []string, master string, dial func(addr string) (redis.Conn, error)) (redis.Conn, error) {
+	addr, err := masterAddr(sentinels, master, dial)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMaster(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// masterAddr asks each of the sentinels in turn for the address of master.
+func masterAddr(sentinels []string, master string, dial func(addr string) (redis.Conn, error)) (string, error) {
+	var errs []error
+	for _, sentinel := range sentinels {
+		addr, err := askSentinel(sentinel, master, dial)
+		if err == nil {
+			return addr, nil
+		}
+		errs = append(errs, fmt.Errorf("sentinel %s: %w", sentinel, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+func askSentinel(sentinel string, master string, dial func(addr string) (redis.Conn, error)) (string, error) {
+	c, err := dial(sentinel)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	// the reply is the host and port of the master, or nil if the sentinel doesn't know it
+	hostPort, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", master))
+	if errors.Is(err, redis.ErrNil) {
+		return "", fmt.Errorf("unknown master %q", master)
+	} else if err != nil {
+		return "", err
+	}
+	if len(hostPort) != 2 {
+		return "", fmt.Errorf("unexpected master address %q", hostPort)
+	}
+	return net.JoinHostPort(hostPort[0], hostPort[1]), nil
+}
+
+// checkMaster returns an error unless c is connected to a master, using the ROLE command.
+func checkMaster(c redis.Conn) error {
+	reply, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(reply) == 0 {
+		return errors.New("empty reply to ROLE")
+	}
+	role, err := redis.String(reply[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != "master" {
+		return fmt.Errorf("redis is a %s, not the master", role)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package CertificateService
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// TestSentinelFailover checks the master is found through the Sentinels, and followed after a failover.
+func TestSentinelFailover(t *testing.T) {
+	sentinel, first, second := newFakeRedis(), newFakeRedis(), newFakeRedis()
+	second.replica = true
+	sentinel.masters["certs"] = "10.0.0.1:6379"
+	nodes := map[string]*fakeRedis{"sentinel:26379": sentinel, "10.0.0.1:6379": first, "10.0.0.2:6379": second}
+	dial := func(addr string) (redis.Conn, error) {
+		if f, ok := nodes[addr]; ok {
+			return &fakeConn{f: f}, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+	// the first Sentinel is down, the second answers
+	sentinels := []string{"down:26379", "sentinel:26379"}
+
+	c, err := dialMaster(sentinels, "certs", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.(*fakeConn).f != first {
+		t.Fatal("expected to connect to the first master")
+	}
+
+	// the master fails over to the replica
+	first.replica, second.replica = true, false
+	sentinel.masters["certs"] = "10.0.0.2:6379"
+	if err := checkMaster(c); err == nil {
+		t.Error("expected the connection to the old master to fail its check")
+	}
+	if c, err = dialMaster(sentinels, "certs", dial); err != nil || c.(*fakeConn).f != second {
+		t.Errorf("expected to connect to the new master, got %v", err)
+	}
+
+	if _, err := dialMaster(sentinels, "unknown", dial); err == nil {
+		t.Error("expected an unknown master to fail")
+	}
+}
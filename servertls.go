@@ -0,0 +1,45 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+)
+
+/*
+loadServerCert reads the server certificate and key back from the store and makes them the
+certificate presented to new TLS connections. Connections already open keep the old one.
+*/
+func (db *dbConn) loadServerCert() error {
+	ctx, cancel := db.withTimeout(context.Background())
+	defer cancel()
+	rec, err := db.store.Get(ctx, db.serverDomain)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(rec.CertPEM, rec.KeyPEM)
+	if err != nil {
+		return err
+	}
+	db.serverCert.Store(&cert)
+	return nil
+}
+
+/*
+serverTLSConfig is the TLS configuration of the https server. The certificate is looked up
+for every handshake, so a renewed server certificate is used as soon as it is loaded. Client
+certs are asked for as Config.ClientCAs configures.
+*/
+func (db *dbConn) serverTLSConfig() *tls.Config {
+	config := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert := db.serverCert.Load()
+			if cert == nil {
+				return nil, errors.New("the server certificate hasn't been issued yet")
+			}
+			return cert, nil
+		},
+	}
+	db.setClientAuth(config)
+	return config
+}
@@ -0,0 +1,49 @@
+package CertificateService
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+// TestServeHTTPS checks the API is served with the server certificate, and a renewal is picked up without a restart.
+func TestServeHTTPS(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{HTTPS: true})
+	// a failed handshake until the server certificate has been issued
+	if _, err := db.serverTLSConfig().GetCertificate(nil); err == nil {
+		t.Error("expected no certificate before the server certificate is issued")
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", db.serverTLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(db.httpHandler)}
+	go server.Serve(ln)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		// the server certificate is self-signed
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	serial := func() string {
+		resp, err := client.Get("https://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		cert := resp.TLS.PeerCertificates[0]
+		if cert.Subject.CommonName != "certserver.fan" {
+			t.Errorf("expected the server certificate, got %s", cert.Subject.CommonName)
+		}
+		return cert.SerialNumber.String()
+	}
+
+	db.renewCertServer(0)
+	first := serial()
+	db.renewCertServer(0)
+	if serial() == first {
+		t.Error("expected the renewed server certificate to be served")
+	}
+}
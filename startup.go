@@ -0,0 +1,53 @@
+package CertificateService
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+WaitForRedis pings redis until it answers, for startup code to block on until the service
+can serve. The delay between pings starts at Config.RedisPollInterval and doubles after every
+failure up to RedisPollMax. It returns nil once redis answers, or an error wrapping ctx.Err()
+if ctx is done first.
+*/
+func (db *dbConn) WaitForRedis(ctx context.Context) error {
+	delay := db.pollInterval
+	for attempt := 1; ; attempt++ {
+		if db.PingRedis(ctx) {
+			return nil
+		}
+		db.logger.Warn("redis isn't answering yet", "attempt", attempt, "retry_in", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("waiting for redis: %w", ctx.Err())
+		case <-timer.C:
+		}
+		delay = min(delay*2, db.pollMax)
+	}
+}
+
+/*
+waitAtStartup waits up to Config.StartupTimeout for redis before a server is opened, giving
+up early if the service is closed meanwhile. It returns straight away without a timeout.
+*/
+func (db *dbConn) waitAtStartup() error {
+	if db.startupTimeout == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), db.startupTimeout)
+	defer cancel()
+	if !db.onClose(cancel) {
+		return ErrServiceClosed
+	}
+	if err := db.WaitForRedis(ctx); err != nil {
+		if db.store.closed.Load() {
+			return ErrServiceClosed
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWaitForRedis checks WaitForRedis returns once redis answers, or with ctx's error if it never does.
+func TestWaitForRedis(t *testing.T) {
+	fake := newFakeRedis()
+	var pings atomic.Int32
+	fake.fail = func(cmd string) error {
+		if cmd == "PING" && pings.Add(1) <= 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	db := newFakeDBWithConfig(fake, Config{RedisPollInterval: time.Millisecond, RedisPollMax: 2 * time.Millisecond})
+	if err := db.WaitForRedis(context.Background()); err != nil || pings.Load() != 4 {
+		t.Errorf("expected redis to answer the fourth ping, got %d pings %v", pings.Load(), err)
+	}
+
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.WaitForRedis(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), RedisPollInterval: time.Second, RedisPollMax: time.Millisecond}); err == nil {
+		t.Error("expected a poll maximum below the interval to be rejected")
+	}
+}
+
+// TestStartupTimeout checks opening a server waits for redis, and gives up when the service is closed.
+func TestStartupTimeout(t *testing.T) {
+	fake := newFakeRedis()
+	fake.fail = func(cmd string) error { return errors.New("connection refused") }
+	db := newFakeDBWithConfig(fake, Config{StartupTimeout: time.Minute, RedisPollInterval: time.Millisecond})
+	done := make(chan error)
+	go func() { done <- db.OpenGRPCServer("127.0.0.1:0") }()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the server to wait for redis, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	db.Close()
+	if err := <-done; !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("expected ErrServiceClosed, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package CertificateService
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The statuses /status answers with, as OCSP names them.
+const (
+	statusGood    = "good"
+	statusRevoked = "revoked"
+	statusUnknown = "unknown"
+)
+
+/*
+certStatus is the JSON response of /status. NextUpdate is when the status should be checked
+again, and is left out when it shouldn't be relied on at all.
+*/
+type certStatus struct {
+	Domain     string     `json:"domain"`
+	Serial     string     `json:"serial,omitempty"`
+	Status     string     `json:"status"`
+	ThisUpdate time.Time  `json:"this_update"`
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+}
+
+/*
+statusHandler answers /status/{domain}, optionally ?serial={serial}, with the status of the
+domain's cert in the manner of an OCSP responder: good while it is unexpired and unrevoked,
+revoked once it has been, and unknown for a domain without a cert, an expired cert, or a serial
+that isn't the domain's current cert and was never revoked. The domain is looked up as a
+retrieve would be, wildcards included.
+
+The response can be cached until NextUpdate, as Cache-Control says. A good cert is checked
+again once half its remaining validity has passed, so a revocation is seen well before it
+expires, and a revoked one needn't be until it expires. An unknown status isn't cached. Errors
+are answered as JSON, with an ErrorCode.
+*/
+func (db *dbConn) statusHandler(w http.ResponseWriter, r *http.Request) {
+	domain, ok := pathDomain(w, r, db.basePath+"/status/", true)
+	if !ok {
+		return
+	}
+	if domain, ok = db.checkDomain(domain); !ok {
+		writeJSONError(w, http.StatusBadRequest, apiError{Code: CodeInvalidDomain, Error: "invalid domain name: " + domain, Reasons: invalidReasons(strings.TrimPrefix(domain, wildcardPrefix))})
+		return
+	}
+	serial := strings.ToLower(r.URL.Query().Get("serial"))
+	now := db.now()
+	result := certStatus{Domain: domain, Serial: serial, Status: statusUnknown, ThisUpdate: now}
+	var nextUpdate time.Time
+
+	cert, _, _, revoked, err := db.lookup(r.Context(), domain)
+	switch {
+	case errors.Is(err, ErrDomainNotFound):
+	case err != nil:
+		writeJSONError(w, errorStatus(err), apiError{Code: errorCode(err), Error: err.Error()})
+		return
+	case serial != "" && serial != serialNumber(cert):
+		// an earlier cert of the domain, or none of it, which is only known if it was revoked
+		revokedSerials, err := db.revokedIn(r.Context(), []string{serial})
+		if err != nil {
+			writeJSONError(w, errorStatus(err), apiError{Code: errorCode(err), Error: err.Error()})
+			return
+		}
+		if revokedSerials[serial] {
+			result.Status = statusRevoked
+		}
+	case !cert.NotAfter.After(now):
+		result.Serial = serialNumber(cert)
+	case revoked:
+		result.Serial, result.Status, nextUpdate = serialNumber(cert), statusRevoked, cert.NotAfter
+	default:
+		result.Serial, result.Status = serialNumber(cert), statusGood
+		nextUpdate = now.Add(cert.NotAfter.Sub(now) / 2).Truncate(time.Second)
+	}
+
+	if maxAge := int(nextUpdate.Sub(now) / time.Second); maxAge > 0 {
+		result.NextUpdate = &nextUpdate
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(maxAge))
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
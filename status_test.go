@@ -0,0 +1,78 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStatus checks /status answers good, revoked or unknown, cacheable for as long as the answer holds.
+func TestStatus(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	db.now = func() time.Time { return now }
+	ctx := context.Background()
+	status := func(path string) (certStatus, string) {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %s", path, rec.Code, rec.Body)
+		}
+		var result certStatus
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result, rec.Header().Get("Cache-Control")
+	}
+
+	cert, err := db.storeCert(ctx, "fanatics.com", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := serialNumber(cert)
+	result, cache := status("/status/fanatics.com")
+	if result.Status != statusGood || result.Serial != first || result.NextUpdate == nil || !result.NextUpdate.Equal(now.Add(time.Minute*30)) || cache != "max-age=1800" {
+		t.Errorf("expected good until half the validity has passed, got %+v %q", result, cache)
+	}
+	if result, _ := status("/status/fanatics.com?serial=" + first); result.Status != statusGood {
+		t.Errorf("expected the current serial good, got %+v", result)
+	}
+
+	if _, err := db.revoke(ctx, "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	result, cache = status("/status/fanatics.com")
+	if result.Status != statusRevoked || !result.NextUpdate.Equal(cert.NotAfter) || cache != "max-age=3600" {
+		t.Errorf("expected revoked until it expires, got %+v %q", result, cache)
+	}
+
+	// once renewed the old serial stays revoked, and one never issued is unknown
+	if _, err := db.storeCert(ctx, "fanatics.com", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := status("/status/fanatics.com?serial=" + first); result.Status != statusRevoked {
+		t.Errorf("expected the old serial revoked, got %+v", result)
+	}
+	if result, cache := status("/status/fanatics.com?serial=abc123"); result.Status != statusUnknown || result.NextUpdate != nil || cache != "no-store" {
+		t.Errorf("expected an unknown serial unknown and uncached, got %+v %q", result, cache)
+	}
+
+	if result, cache := status("/status/missing.com"); result.Status != statusUnknown || cache != "no-store" {
+		t.Errorf("expected a domain without a cert unknown, got %+v %q", result, cache)
+	}
+	if _, err := db.storeCert(ctx, "expired.com", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := status("/status/expired.com"); result.Status != statusUnknown {
+		t.Errorf("expected an expired cert unknown, got %+v", result)
+	}
+
+	rec := httptest.NewRecorder()
+	db.httpHandler(rec, httptest.NewRequest("GET", "/status/-invalid", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid domain rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,99 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDomainNotFound is returned when no cert is stored for a domain.
+var ErrDomainNotFound = errors.New("domain not found")
+
+/*
+ErrBackendBusy is returned when no connection to the backend frees up before the call's
+context is done, because every connection the pool allows is in use. The http API answers it
+with 503 Service Unavailable, so clients can back off and retry.
+*/
+var ErrBackendBusy = errors.New("backend busy")
+
+/*
+ErrReplicaUnreachable is returned by Ping when the master answers but the read replica
+doesn't, so lookups fail while creates still go through. /ready reports it apart from a redis
+that is down.
+*/
+var ErrReplicaUnreachable = errors.New("read replica can't be reached")
+
+// Record is everything stored for a domain's cert.
+type Record struct {
+	// Expires is when the cert expires, its NotAfter.
+	Expires time.Time
+	// IssuedAt is when the cert was issued, its NotBefore. Zero for certs stored before it was kept.
+	IssuedAt time.Time
+	// CertPEM and KeyPEM are the PEM encoded certificate and private key.
+	CertPEM []byte
+	KeyPEM  []byte
+	// Metadata is what the create that issued the cert attached to it, nil if it attached nothing.
+	Metadata map[string]string
+}
+
+/*
+Storage is where a CertificateService keeps its certs. The cert logic and the http handler
+only talk to a Storage, so the redis backed store from NewRedisStorage can be swapped for
+the in-memory one from NewMemoryStorage, for example to run tests without redis.
+
+Every call takes a context, and implementations should give up once it is done. They must
+be safe for concurrent use.
+*/
+type Storage interface {
+	/*
+		Set stores rec for domain, replacing any previous record, and reports whether there
+		was none, so a create can be told from a renewal.
+	*/
+	Set(ctx context.Context, domain string, rec Record) (bool, error)
+	// Get returns the record stored for domain, or an error matching ErrDomainNotFound with errors.Is.
+	Get(ctx context.Context, domain string) (Record, error)
+	/*
+		SetMany stores every record in recs, keyed by domain, in as few round trips as the store
+		allows. The first map returned holds the domains that had no record before, as Set
+		reports, the second the error of each domain that couldn't be stored.
+	*/
+	SetMany(ctx context.Context, recs map[string]Record) (map[string]bool, map[string]error)
+	// GetMany returns the records stored for domains, keyed by domain. Missing domains are left out.
+	GetMany(ctx context.Context, domains []string) (map[string]Record, error)
+	// Delete removes the record stored for domain and reports whether there was one.
+	Delete(ctx context.Context, domain string) (bool, error)
+	// List returns every stored domain paired with its expiration date.
+	List(ctx context.Context) (map[string]time.Time, error)
+	// Flush deletes every stored record and returns how many domains were removed.
+	Flush(ctx context.Context) (int, error)
+	// Count returns the number of stored domains, without reading them.
+	Count(ctx context.Context) (int, error)
+	/*
+		Scan returns a page of roughly count stored domains starting at cursor, and the cursor
+		of the next page. A walk starts at cursor 0 and is complete when 0 is returned.
+	*/
+	Scan(ctx context.Context, cursor int, count int) (int, []CertInfo, error)
+	// Ping reports whether the store can be reached.
+	Ping(ctx context.Context) error
+	/*
+		Revoke records the cert with serial as revoked. The entry is only needed until the cert
+		expires, at expires, and may be dropped after that.
+	*/
+	Revoke(ctx context.Context, serial string, expires time.Time) error
+	// Revoked reports which of serials have been revoked, keyed by serial. Others are left out.
+	Revoked(ctx context.Context, serials []string) (map[string]bool, error)
+	/*
+		AddHistory records entry as the latest issuance for domain, keeping only the depth
+		most recent. The history is kept apart from the record, and outlives its deletion.
+	*/
+	AddHistory(ctx context.Context, domain string, entry HistoryEntry, depth int) error
+	// History returns the issuances recorded for domain, the latest first.
+	History(ctx context.Context, domain string) ([]HistoryEntry, error)
+	/*
+		SaveIdempotent saves result as the response to the create made with the idempotency
+		key, until expires, so a retry of it can be replayed instead of creating again.
+	*/
+	SaveIdempotent(ctx context.Context, key string, result IdempotentResult, expires time.Time) error
+	// LoadIdempotent returns the result saved for key, and false if there is none or it has expired.
+	LoadIdempotent(ctx context.Context, key string) (IdempotentResult, bool, error)
+}
@@ -0,0 +1,195 @@
+package CertificateService
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+memoryStorage is a Storage kept in a map. It is lost when the process exits, so it is
+meant for tests and single process experiments. Like the redis hash layout, expired certs
+are kept until they are replaced or deleted. Nothing blocks, so contexts are ignored.
+*/
+type memoryStorage struct {
+	mu      sync.RWMutex
+	records map[string]Record
+	// the expiry of every revoked serial's cert
+	revoked map[string]time.Time
+	// the recent issuances of every domain, the latest first
+	history map[string][]HistoryEntry
+	// the results saved by idempotency key, with when they expire
+	idempotent map[string]savedResult
+}
+
+type savedResult struct {
+	result  IdempotentResult
+	expires time.Time
+}
+
+// NewMemoryStorage returns an empty in-memory Storage.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{records: make(map[string]Record), revoked: make(map[string]time.Time), history: make(map[string][]HistoryEntry), idempotent: make(map[string]savedResult)}
+}
+
+func (m *memoryStorage) Set(ctx context.Context, domain string, rec Record) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, replaced := m.records[domain]
+	m.records[domain] = rec
+	return !replaced, nil
+}
+
+func (m *memoryStorage) SetMany(ctx context.Context, recs map[string]Record) (map[string]bool, map[string]error) {
+	created := make(map[string]bool)
+	for domain, rec := range recs {
+		if ok, _ := m.Set(ctx, domain, rec); ok {
+			created[domain] = true
+		}
+	}
+	return created, nil
+}
+
+func (m *memoryStorage) Get(ctx context.Context, domain string) (Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rec, ok := m.records[domain]
+	if !ok {
+		return Record{}, ErrDomainNotFound
+	}
+	return rec, nil
+}
+
+func (m *memoryStorage) GetMany(ctx context.Context, domains []string) (map[string]Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	recs := make(map[string]Record, len(domains))
+	for _, domain := range domains {
+		if rec, ok := m.records[domain]; ok {
+			recs[domain] = rec
+		}
+	}
+	return recs, nil
+}
+
+func (m *memoryStorage) Delete(ctx context.Context, domain string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.records[domain]
+	delete(m.records, domain)
+	return ok, nil
+}
+
+func (m *memoryStorage) List(ctx context.Context) (map[string]time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	certs := make(map[string]time.Time, len(m.records))
+	for domain, rec := range m.records {
+		certs[domain] = rec.Expires
+	}
+	return certs, nil
+}
+
+func (m *memoryStorage) Count(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.records), nil
+}
+
+// Scan treats the cursor as an offset into the sorted domains.
+func (m *memoryStorage) Scan(ctx context.Context, cursor int, count int) (int, []CertInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	domains := make([]string, 0, len(m.records))
+	for domain := range m.records {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	var page []CertInfo
+	for ; cursor < len(domains) && len(page) < count; cursor++ {
+		rec := m.records[domains[cursor]]
+		page = append(page, CertInfo{Domain: domains[cursor], Expires: rec.Expires, IssuedAt: rec.IssuedAt, Metadata: rec.Metadata})
+	}
+	if cursor >= len(domains) {
+		cursor = 0
+	}
+	return cursor, page, nil
+}
+
+func (m *memoryStorage) Flush(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := len(m.records)
+	m.records = make(map[string]Record)
+	return removed, nil
+}
+
+func (m *memoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Revoke drops the revocations of certs that have expired while it holds the lock.
+func (m *memoryStorage) Revoke(ctx context.Context, serial string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for revoked, until := range m.revoked {
+		if !until.After(now) {
+			delete(m.revoked, revoked)
+		}
+	}
+	m.revoked[serial] = expires
+	return nil
+}
+
+func (m *memoryStorage) Revoked(ctx context.Context, serials []string) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	revoked := make(map[string]bool)
+	for _, serial := range serials {
+		if _, ok := m.revoked[serial]; ok {
+			revoked[serial] = true
+		}
+	}
+	return revoked, nil
+}
+
+func (m *memoryStorage) AddHistory(ctx context.Context, domain string, entry HistoryEntry, depth int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	history := append([]HistoryEntry{entry}, m.history[domain]...)
+	m.history[domain] = history[:min(len(history), depth)]
+	return nil
+}
+
+func (m *memoryStorage) History(ctx context.Context, domain string) ([]HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]HistoryEntry(nil), m.history[domain]...), nil
+}
+
+// SaveIdempotent drops the results that have expired while it holds the lock.
+func (m *memoryStorage) SaveIdempotent(ctx context.Context, key string, result IdempotentResult, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for saved, entry := range m.idempotent {
+		if !entry.expires.After(now) {
+			delete(m.idempotent, saved)
+		}
+	}
+	m.idempotent[key] = savedResult{result: result, expires: expires}
+	return nil
+}
+
+func (m *memoryStorage) LoadIdempotent(ctx context.Context, key string) (IdempotentResult, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.idempotent[key]
+	if !ok || !entry.expires.After(time.Now()) {
+		return IdempotentResult{}, false, nil
+	}
+	return entry.result, true, nil
+}
@@ -0,0 +1,733 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	//imported pagckage, run go get github.com/gomodule/redigo/redis
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+redisStorage is the Storage kept in redis, laid out as selected by layout. Every key is
+prefixed by namespace, which is empty or ends in a ':'. Lookups, listing and counting are read
+from readPool, which is pool itself unless reads go to a replica.
+*/
+type redisStorage struct {
+	pool      *redis.Pool
+	readPool  *redis.Pool
+	layout    KeyLayout
+	namespace string
+}
+
+/*
+NewRedisStorage returns a Storage that keeps its certs in the redis server behind pool,
+laid out as selected by layout. Every command is sent with redis.DoContext, so it is
+abandoned, and the connection closed, as soon as the context is done.
+*/
+func NewRedisStorage(pool *redis.Pool, layout KeyLayout) Storage {
+	return NewNamespacedRedisStorage(pool, layout, "")
+}
+
+/*
+NewNamespacedRedisStorage returns a redis Storage like NewRedisStorage whose keys are all
+prefixed by namespace, "tenantA:Domain" rather than "Domain" for namespace "tenantA", so
+several services can share one redis without seeing each other's certs. An empty namespace
+is the same as NewRedisStorage.
+*/
+func NewNamespacedRedisStorage(pool *redis.Pool, layout KeyLayout, namespace string) Storage {
+	return NewReplicatedRedisStorage(pool, nil, layout, namespace)
+}
+
+/*
+NewReplicatedRedisStorage returns a redis Storage like NewNamespacedRedisStorage that reads
+certs, revocations and history from readPool, a read replica, and only writes to pool, the
+master, taking load off the master. A replica lags its master, so a cert may briefly be missing
+or stale when read straight after it was written. Idempotent results are always read from the
+master, as a replay must see the create it replays, and so is anything read with a context
+from readMaster. A nil readPool reads from pool.
+*/
+func NewReplicatedRedisStorage(pool *redis.Pool, readPool *redis.Pool, layout KeyLayout, namespace string) Storage {
+	if namespace != "" {
+		namespace += ":"
+	}
+	if readPool == nil {
+		readPool = pool
+	}
+	return &redisStorage{pool: pool, readPool: readPool, layout: layout, namespace: namespace}
+}
+
+// Close closes the pools, releasing their connections.
+func (s *redisStorage) Close() error {
+	err := s.pool.Close()
+	if s.readPool != s.pool {
+		err = errors.Join(err, s.readPool.Close())
+	}
+	return err
+}
+
+/*
+conn returns a connection from the pool, waiting for one to free up if the pool waits. If the
+context is done first, or the pool does not wait and is exhausted, the error matches
+ErrBackendBusy.
+*/
+func (s *redisStorage) conn(ctx context.Context) (redis.Conn, error) {
+	return poolConn(ctx, s.pool)
+}
+
+// readConn returns a connection to read from, from the read pool unless ctx is from readMaster, as conn does.
+func (s *redisStorage) readConn(ctx context.Context) (redis.Conn, error) {
+	if master, _ := ctx.Value(masterReadKey{}).(bool); master {
+		return s.conn(ctx)
+	}
+	return poolConn(ctx, s.readPool)
+}
+
+type masterReadKey struct{}
+
+/*
+readMaster returns ctx marked to be read from the master rather than a read replica, for the
+checks that gate a write, which a replica lagging behind would answer from before the latest
+writes.
+*/
+func readMaster(ctx context.Context) context.Context {
+	return context.WithValue(ctx, masterReadKey{}, true)
+}
+
+// poolConn returns a connection from pool, for conn and readConn.
+func poolConn(ctx context.Context, pool *redis.Pool) (redis.Conn, error) {
+	conn, err := pool.GetContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, redis.ErrPoolExhausted) {
+		return nil, fmt.Errorf("%w: no redis connection free: %v", ErrBackendBusy, err)
+	}
+	return conn, err
+}
+
+// key returns name prefixed by the storage's namespace.
+func (s *redisStorage) key(name string) string {
+	return s.namespace + name
+}
+
+// newConfiguredStorage is the redis Storage configured by cfg, used when cfg.Storage isn't set.
+func newConfiguredStorage(cfg Config) Storage {
+	var readPool *redis.Pool
+	if cfg.RedisReadAddr != "" {
+		readPool = newReadPool(cfg)
+	}
+	return NewReplicatedRedisStorage(newPool(cfg), readPool, cfg.KeyLayout, cfg.Namespace)
+}
+
+/*
+The newPool' function is used to maintain a system of connections to a redis server.
+
+'newPool' uses the imported redigo package to talk to the redis database. Make sure
+this package is imported before using.
+
+Redis must be started before using any functions in this package. If you have docker, redis is simple
+to use:
+
+docker run --name some-redis -d -p 6379:6379 redis redis-server --appendonly yes
+
+This docker command will ensure that redis start on port 6379 (-p 6379:6379) and will persisit data between sessions.
+
+The pool is sized by cfg. Dialing is retried as configured by cfg.DialAttempts and
+cfg.DialRetryBase, and connections idle for longer than cfg.IdleTestThreshold are checked
+before they are reused. When cfg names Sentinels, the current master is looked up through
+them before every dial instead, and every connection is checked to still be the master.
+Connections use TLS when cfg.UseTLS is set. When cfg.Dial is set it dials
+every connection instead, TLS and all.
+*/
+
+func newPool(cfg Config) *redis.Pool {
+	options := dialOptions(cfg)
+	dialAddr := func(addr string) (redis.Conn, error) {
+		return redis.Dial("tcp", addr, options...)
+	}
+	dial := func() (redis.Conn, error) {
+		// by default, redis starts on port 6379. If you have it started on a diff 192.168.99.100
+		return dialAddr("localhost:6379")
+	}
+	check := testOnBorrow(cfg.IdleTestThreshold)
+	if cfg.Dial != nil {
+		dial = cfg.Dial
+	}
+	if len(cfg.SentinelAddrs) > 0 {
+		dial = func() (redis.Conn, error) {
+			return dialMaster(cfg.SentinelAddrs, cfg.SentinelMaster, dialAddr)
+		}
+		check = func(c redis.Conn, lastUsed time.Time) error { return checkMaster(c) }
+	}
+	return poolFor(cfg, dial, check)
+}
+
+/*
+newReadPool is the pool of connections to the read replica at cfg.RedisReadAddr. It is sized,
+dialed and checked like newPool's, but never through Sentinel: the replica is dialed directly.
+*/
+func newReadPool(cfg Config) *redis.Pool {
+	options := dialOptions(cfg)
+	dial := func() (redis.Conn, error) {
+		return redis.Dial("tcp", cfg.RedisReadAddr, options...)
+	}
+	return poolFor(cfg, dial, testOnBorrow(cfg.IdleTestThreshold))
+}
+
+// poolFor returns a pool sized by cfg whose connections are dialed by dial and checked by check on borrow.
+func poolFor(cfg Config, dial func() (redis.Conn, error), check func(redis.Conn, time.Time) error) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     cfg.MaxIdle,
+		MaxActive:   cfg.MaxActive, // max number of connections
+		IdleTimeout: cfg.IdleTimeout,
+		Wait:        !cfg.NoWait,
+		Dial: func() (redis.Conn, error) {
+			return dialWithRetry(selectDatabase(dial, cfg.RedisDatabase), cfg.DialAttempts, cfg.DialRetryBase)
+		},
+		TestOnBorrow: check,
+	}
+}
+
+/*
+selectDatabase returns dial with every connection it dials switched to the numbered redis
+database. A failed SELECT fails the dial, so the service never carries on in database 0. The
+default database 0 is left alone, as redis starts every connection in it.
+*/
+func selectDatabase(dial func() (redis.Conn, error), db int) func() (redis.Conn, error) {
+	if db == 0 {
+		return dial
+	}
+	return func() (redis.Conn, error) {
+		c, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.Do("SELECT", db); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("selecting redis database %d: %w", db, err)
+		}
+		return c, nil
+	}
+}
+
+/*
+dialOptions returns the options for dialing redis with cfg. With TLS the server certificate
+is verified against cfg.TLSConfig, or the system roots, unless cfg.TLSSkipVerify is set.
+*/
+func dialOptions(cfg Config) []redis.DialOption {
+	if !cfg.UseTLS {
+		return nil
+	}
+	return []redis.DialOption{
+		redis.DialUseTLS(true),
+		redis.DialTLSConfig(cfg.TLSConfig),
+		redis.DialTLSSkipVerify(cfg.TLSSkipVerify),
+	}
+}
+
+/*
+testOnBorrow returns the pool's health check: a connection used within threshold is assumed
+alive, an older one is PINGed and the pool discards it if that fails.
+*/
+func testOnBorrow(threshold time.Duration) func(c redis.Conn, lastUsed time.Time) error {
+	return func(c redis.Conn, lastUsed time.Time) error {
+		if time.Since(lastUsed) < threshold {
+			return nil
+		}
+		_, err := c.Do("PING")
+		return err
+	}
+}
+
+/*
+dialWithRetry calls dial up to attempts times, waiting base before the first retry and
+doubling the wait after every further failure. The error of the last attempt is returned
+if none succeed, so a redis that is down for good fails the pool's Get in bounded time.
+*/
+func dialWithRetry(dial func() (redis.Conn, error), attempts int, base time.Duration) (redis.Conn, error) {
+	delay := base
+	for attempt := 1; ; attempt++ {
+		c, err := dial()
+		if err == nil || attempt >= attempts {
+			return c, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+/*
+Set stores the PEM encoded cert and key, plus the expiration date, in a single transaction
+so they never disagree. In the hash layout the "Domain" hash is kept as a cheap index of
+every domain and its expiry for listing.
+the expiration date time string are rather large. We're encoding it here as byte slice
+to help protect against parsing errors or modifying the time in unwanted ways.
+*/
+func (s *redisStorage) Set(ctx context.Context, domain string, rec Record) (bool, error) {
+	/*
+		Use a pooled connection to redis and close the
+		connection when the function exits.
+	*/
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	s.queueSet(conn, domain, rec)
+	replies, err := exec(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+	return s.setCreated(replies)
+}
+
+// setCreated reads from the replies to the commands queueSet queued whether the domain was new.
+func (s *redisStorage) setCreated(replies []interface{}) (bool, error) {
+	// the HSET of the "Domain" hash adds a field for a new domain, the DEL of a cert key finds none
+	if s.layout == PerDomainKeyLayout {
+		deleted, err := redis.Int(replies[0], nil)
+		return deleted == 0, err
+	}
+	added, err := redis.Int(replies[2], nil)
+	return added == 1, err
+}
+
+// queueSet queues the commands storing rec for domain on conn and returns how many it queued.
+func (s *redisStorage) queueSet(conn redis.Conn, domain string, rec Record) int {
+	if s.layout == PerDomainKeyLayout {
+		return s.queueStoreKey(conn, domain, rec)
+	}
+	conn.Send("HSET", s.key("Certificate"), domain, rec.CertPEM)
+	conn.Send("HSET", s.key("PrivateKey"), domain, rec.KeyPEM)
+	conn.Send("HSET", s.key("Domain"), domain, encode(rec.Expires, rec.IssuedAt, rec.Metadata))
+	return 3
+}
+
+/*
+SetMany stores each record in a transaction of its own, as Set does, but pipelines every
+transaction so the whole batch takes a single round trip.
+*/
+func (s *redisStorage) SetMany(ctx context.Context, recs map[string]Record) (map[string]bool, map[string]error) {
+	created := make(map[string]bool)
+	errs := make(map[string]error)
+	failAll := func(err error) (map[string]bool, map[string]error) {
+		for domain := range recs {
+			errs[domain] = err
+		}
+		return nil, errs
+	}
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return failAll(err)
+	}
+	defer conn.Close()
+
+	domains := make([]string, 0, len(recs))
+	queued := make([]int, 0, len(recs))
+	for domain, rec := range recs {
+		conn.Send("MULTI")
+		domains = append(domains, domain)
+		queued = append(queued, s.queueSet(conn, domain, rec))
+		conn.Send("EXEC")
+	}
+	if err := conn.Flush(); err != nil {
+		return failAll(err)
+	}
+
+	for i, domain := range domains {
+		// MULTI and every queued command reply OK or QUEUED, then EXEC replies with their results
+		for j := 0; j <= queued[i]; j++ {
+			if _, err := redis.ReceiveContext(conn, ctx); err != nil && errs[domain] == nil {
+				errs[domain] = err
+			}
+		}
+		replies, err := redis.Values(redis.ReceiveContext(conn, ctx))
+		if err != nil && errs[domain] == nil {
+			errs[domain] = err
+		}
+		for _, reply := range replies {
+			if err, ok := reply.(redis.Error); ok && errs[domain] == nil {
+				errs[domain] = err
+			}
+		}
+		if errs[domain] == nil {
+			if created[domain], err = s.setCreated(replies); err != nil {
+				errs[domain] = err
+			}
+		}
+	}
+	return created, errs
+}
+
+/*
+Get reads everything stored for domain in one round trip. In the per-domain key layout
+redis evicts a cert when it expires, so an expired cert is reported as ErrDomainNotFound
+just like a domain that never existed.
+*/
+func (s *redisStorage) Get(ctx context.Context, domain string) (Record, error) {
+	recs, err := s.GetMany(ctx, []string{domain})
+	if err != nil {
+		return Record{}, err
+	}
+	rec, ok := recs[domain]
+	if !ok {
+		return Record{}, ErrDomainNotFound
+	}
+	return rec, nil
+}
+
+// GetMany pipelines the reads of every domain, so the whole batch takes a single round trip.
+func (s *redisStorage) GetMany(ctx context.Context, domains []string) (map[string]Record, error) {
+	conn, err := s.readConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	for _, domain := range domains {
+		if s.layout == PerDomainKeyLayout {
+			conn.Send("HMGET", s.key(certKey(domain)), "cert", "key", "expires")
+		} else {
+			conn.Send("HGET", s.key("Certificate"), domain)
+			conn.Send("HGET", s.key("PrivateKey"), domain)
+			conn.Send("HGET", s.key("Domain"), domain)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	recs := make(map[string]Record, len(domains))
+	for _, domain := range domains {
+		var fields [][]byte
+		if s.layout == PerDomainKeyLayout {
+			if fields, err = redis.ByteSlices(redis.ReceiveContext(conn, ctx)); err != nil {
+				return nil, err
+			}
+		} else {
+			// every reply must be read, even once a field is known to be missing
+			for i := 0; i < 3; i++ {
+				field, err := redis.Bytes(redis.ReceiveContext(conn, ctx))
+				if err != nil && !errors.Is(err, redis.ErrNil) {
+					return nil, err
+				}
+				fields = append(fields, field)
+			}
+		}
+		/*
+			a missing field means a missing domain. A domain stored before X.509 issuance only
+			has an expiry, it isn't a cert.
+		*/
+		missing := len(fields) != 3
+		for _, field := range fields {
+			missing = missing || field == nil
+		}
+		if !missing {
+			expires, issuedAt, meta, err := decode(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", domain, err)
+			}
+			recs[domain] = Record{CertPEM: fields[0], KeyPEM: fields[1], Expires: expires, IssuedAt: issuedAt, Metadata: meta}
+		}
+	}
+	return recs, nil
+}
+
+func (s *redisStorage) Delete(ctx context.Context, domain string) (bool, error) {
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if s.layout == PerDomainKeyLayout {
+		removed, err := redis.Int(redis.DoContext(conn, ctx, "DEL", s.key(certKey(domain))))
+		return removed > 0, err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("HDEL", s.key("Domain"), domain)
+	conn.Send("HDEL", s.key("Certificate"), domain)
+	conn.Send("HDEL", s.key("PrivateKey"), domain)
+	replies, err := exec(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+	removed, err := redis.Int(replies[0], nil)
+	return removed > 0, err
+}
+
+/*
+List reads every domain with its expiration date, walking them with Scan a page at a time
+rather than with HGETALL, whose single reply would hold the whole store.
+*/
+func (s *redisStorage) List(ctx context.Context) (map[string]time.Time, error) {
+	certs := make(map[string]time.Time)
+	cursor := 0
+	for {
+		var page []CertInfo
+		var err error
+		if cursor, page, err = s.Scan(ctx, cursor, defaultScanCount); err != nil {
+			return nil, err
+		}
+		for _, cert := range page {
+			certs[cert.Domain] = cert.Expires
+		}
+		if cursor == 0 {
+			return certs, nil
+		}
+	}
+}
+
+/*
+Flush deletes the hashes holding the certs, reading how many domains they held in the same
+transaction, or in the per-domain key layout every cert key. No other key is touched.
+*/
+func (s *redisStorage) Flush(ctx context.Context) (int, error) {
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if s.layout == PerDomainKeyLayout {
+		return s.flushCertKeys(ctx, conn)
+	}
+
+	conn.Send("MULTI")
+	conn.Send("HLEN", s.key("Domain"))
+	conn.Send("DEL", s.key("Domain"), s.key("Certificate"), s.key("PrivateKey"))
+	replies, err := exec(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(replies[0], nil)
+}
+
+/*
+Count returns the number of stored certs. In the hash layout that is a single HLEN, in the
+per-domain key layout the cert keys have to be counted with SCAN.
+*/
+func (s *redisStorage) Count(ctx context.Context) (int, error) {
+	conn, err := s.readConn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if s.layout == PerDomainKeyLayout {
+		return s.countCertKeys(ctx, conn)
+	}
+	return redis.Int(redis.DoContext(conn, ctx, "HLEN", s.key("Domain")))
+}
+
+/*
+Scan reads one page of stored certs. In the hash layout the page comes from HSCAN, in the
+per-domain key layout from SCAN followed by a pipelined read of each key's expiry.
+*/
+func (s *redisStorage) Scan(ctx context.Context, cursor int, count int) (int, []CertInfo, error) {
+	conn, err := s.readConn(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer conn.Close()
+
+	if s.layout == PerDomainKeyLayout {
+		return s.scanCertKeys(ctx, conn, cursor, count)
+	}
+
+	reply, err := redis.Values(redis.DoContext(conn, ctx, "HSCAN", s.key("Domain"), cursor, "COUNT", count))
+	if err != nil {
+		return 0, nil, err
+	}
+	if cursor, err = redis.Int(reply[0], nil); err != nil {
+		return 0, nil, err
+	}
+	fields, err := redis.ByteSlices(reply[1], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	// HSCAN replies with the fields and values interleaved: domain, expiration, ...
+	page := make([]CertInfo, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		expires, issuedAt, meta, err := decode(fields[i+1])
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", fields[i], err)
+		}
+		page = append(page, CertInfo{Domain: string(fields[i]), Expires: expires, IssuedAt: issuedAt, Metadata: meta})
+	}
+	return cursor, page, nil
+}
+
+/*
+Ping checks redis is alive. The reply would be "PONG", but an error will be thrown if
+"PONG" isn't recived. With a read replica both it and the master must answer, a replica
+that doesn't fails with ErrReplicaUnreachable.
+*/
+func (s *redisStorage) Ping(ctx context.Context) error {
+	if err := ping(ctx, s.pool); err != nil {
+		return err
+	}
+	if s.readPool != s.pool {
+		if err := ping(ctx, s.readPool); err != nil {
+			return fmt.Errorf("%w: %v", ErrReplicaUnreachable, err)
+		}
+	}
+	return nil
+}
+
+// ping PINGs redis over a connection from pool.
+func ping(ctx context.Context, pool *redis.Pool) error {
+	conn, err := poolConn(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = redis.DoContext(conn, ctx, "PING")
+	return err
+}
+
+/*
+Revoke adds serial to the "Revoked" sorted set, scored by when its cert expires, and drops
+the serials whose certs have since expired so the set doesn't grow forever.
+*/
+func (s *redisStorage) Revoke(ctx context.Context, serial string, expires time.Time) error {
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("ZREMRANGEBYSCORE", s.key("Revoked"), "-inf", time.Now().UnixMilli())
+	conn.Send("ZADD", s.key("Revoked"), expires.UnixMilli(), serial)
+	_, err = exec(ctx, conn)
+	return err
+}
+
+// Revoked pipelines a ZSCORE of every serial, so the whole batch takes a single round trip.
+func (s *redisStorage) Revoked(ctx context.Context, serials []string) (map[string]bool, error) {
+	conn, err := s.readConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	for _, serial := range serials {
+		conn.Send("ZSCORE", s.key("Revoked"), serial)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	revoked := make(map[string]bool)
+	for _, serial := range serials {
+		score, err := redis.ReceiveContext(conn, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if score != nil {
+			revoked[serial] = true
+		}
+	}
+	return revoked, nil
+}
+
+/*
+AddHistory pushes entry, as JSON, onto the domain's "History:{domain}" list and trims it to
+depth in one transaction.
+*/
+func (s *redisStorage) AddHistory(ctx context.Context, domain string, entry HistoryEntry, depth int) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("LPUSH", s.key(historyKeyPrefix+domain), data)
+	conn.Send("LTRIM", s.key(historyKeyPrefix+domain), 0, depth-1)
+	_, err = exec(ctx, conn)
+	return err
+}
+
+// History reads the domain's whole history list, which AddHistory keeps short.
+func (s *redisStorage) History(ctx context.Context, domain string) ([]HistoryEntry, error) {
+	conn, err := s.readConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entries, err := redis.ByteSlices(redis.DoContext(conn, ctx, "LRANGE", s.key(historyKeyPrefix+domain), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	history := make([]HistoryEntry, len(entries))
+	for i, entry := range entries {
+		if err := json.Unmarshal(entry, &history[i]); err != nil {
+			return nil, fmt.Errorf("%s: corrupt history entry: %w", domain, err)
+		}
+	}
+	return history, nil
+}
+
+/*
+SaveIdempotent SETs result, as JSON, at "Idempotency:{key}" with a PX expiry, so redis drops
+it once expired.
+*/
+func (s *redisStorage) SaveIdempotent(ctx context.Context, key string, result IdempotentResult, expires time.Time) error {
+	ttl := time.Until(expires).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = redis.DoContext(conn, ctx, "SET", s.key(idempotencyKeyPrefix+key), data, "PX", ttl)
+	return err
+}
+
+func (s *redisStorage) LoadIdempotent(ctx context.Context, key string) (IdempotentResult, bool, error) {
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return IdempotentResult{}, false, err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(redis.DoContext(conn, ctx, "GET", s.key(idempotencyKeyPrefix+key)))
+	if errors.Is(err, redis.ErrNil) {
+		return IdempotentResult{}, false, nil
+	} else if err != nil {
+		return IdempotentResult{}, false, err
+	}
+	var result IdempotentResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return IdempotentResult{}, false, fmt.Errorf("corrupt idempotent result: %w", err)
+	}
+	return result, true, nil
+}
+
+// exec runs the transaction queued on conn since MULTI and returns an error if any command in it failed.
+func exec(ctx context.Context, conn redis.Conn) ([]interface{}, error) {
+	replies, err := redis.Values(redis.DoContext(conn, ctx, "EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
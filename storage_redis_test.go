@@ -0,0 +1,345 @@
+package CertificateService
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// TestDialWithRetry checks a transient dial failure is retried, and a permanent one gives up.
+func TestDialWithRetry(t *testing.T) {
+	refused := errors.New("connection refused")
+	dials := 0
+	flaky := func() (redis.Conn, error) {
+		dials++
+		if dials < 3 {
+			return nil, refused
+		}
+		return &fakeConn{f: newFakeRedis()}, nil
+	}
+	start := time.Now()
+	if c, err := dialWithRetry(flaky, 3, time.Millisecond*10); err != nil || c == nil {
+		t.Fatalf("expected the third attempt to connect, got %v", err)
+	}
+	// waits of 10ms then 20ms
+	if elapsed := time.Since(start); elapsed < time.Millisecond*30 {
+		t.Errorf("expected a backoff between attempts, took %v", elapsed)
+	}
+
+	dials = 0
+	down := func() (redis.Conn, error) {
+		dials++
+		return nil, refused
+	}
+	if _, err := dialWithRetry(down, 4, time.Millisecond); err != refused {
+		t.Errorf("expected the last dial error, got %v", err)
+	}
+	if dials != 4 {
+		t.Errorf("expected 4 attempts, got %d", dials)
+	}
+}
+
+// TestSelectDatabase checks connections SELECT the configured database, and fail to dial if they can't.
+func TestSelectDatabase(t *testing.T) {
+	fake := newFakeRedis()
+	dial := func() (redis.Conn, error) { return &fakeConn{f: fake}, nil }
+
+	if _, err := selectDatabase(dial, 0)(); err != nil || fake.count("SELECT") != 0 {
+		t.Errorf("expected database 0 to be left alone, got %v after %d SELECTs", err, fake.count("SELECT"))
+	}
+	if _, err := selectDatabase(dial, 3)(); err != nil || fake.count("SELECT") != 1 {
+		t.Errorf("expected database 3 to be selected, got %v after %d SELECTs", err, fake.count("SELECT"))
+	}
+	if _, err := selectDatabase(dial, 99)(); err == nil || !strings.Contains(err.Error(), "selecting redis database 99") {
+		t.Errorf("expected the SELECT error, got %v", err)
+	}
+	if _, err := NewCertificateServiceWithConfig(Config{RedisDatabase: -1}); err == nil {
+		t.Error("expected a negative database to be rejected")
+	}
+}
+
+// TestOnBorrow checks only connections idle past the threshold are PINGed, and dead ones rejected.
+func TestOnBorrow(t *testing.T) {
+	fake := newFakeRedis()
+	check := testOnBorrow(time.Minute)
+	conn := &fakeConn{f: fake}
+
+	if err := check(conn, time.Now()); err != nil || fake.count("PING") != 0 {
+		t.Errorf("a recently used connection shouldn't be checked, got %v", err)
+	}
+	if err := check(conn, time.Now().Add(-time.Hour)); err != nil || fake.count("PING") != 1 {
+		t.Errorf("an idle connection should be PINGed, got %v", err)
+	}
+
+	// redis restarted while the connection sat in the pool
+	fake.fail = func(cmd string) error { return errors.New("EOF") }
+	if err := check(conn, time.Now().Add(-time.Hour)); err == nil {
+		t.Error("expected a dead connection to be rejected")
+	}
+}
+
+/*
+TestPoolBusy checks a request that can't get a redis connection before its deadline, with every
+connection in use, is answered 503 backend busy, and straight away when the pool doesn't wait.
+*/
+func TestPoolBusy(t *testing.T) {
+	for _, wait := range []bool{true, false} {
+		pool := newFakePool(newFakeRedis())
+		pool.MaxActive, pool.Wait = 1, wait
+		held := pool.Get()
+		db, err := NewCertificateServiceWithConfig(Config{
+			Storage:          NewRedisStorage(pool, HashLayout),
+			RedisTimeout:     time.Millisecond * 20,
+			DisableAccessLog: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, path := range []string{"/cert/fanatics.com", "/certcreate/fanatics.com", "/count"} {
+			w := httptest.NewRecorder()
+			db.(*dbConn).httpHandler(w, newRequest(path))
+			if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "backend busy") {
+				t.Errorf("wait %v: expected %s answered 503 backend busy, got %d %s", wait, path, w.Code, w.Body)
+			}
+		}
+
+		// once the connection is back the request goes through
+		held.Close()
+		w := httptest.NewRecorder()
+		db.(*dbConn).httpHandler(w, newRequest("/certcreate/fanatics.com"))
+		if w.Code != http.StatusCreated {
+			t.Errorf("wait %v: expected 201 with a free connection, got %d %s", wait, w.Code, w.Body)
+		}
+	}
+}
+
+/*
+TestReadReplica checks lookups are read from the replica while creates are written to the
+master, and that the service is only healthy while both answer.
+*/
+func TestReadReplica(t *testing.T) {
+	master, replica := newFakeRedis(), newFakeRedis()
+	db, err := NewCertificateServiceWithConfig(Config{
+		Storage:          NewReplicatedRedisStorage(newFakePool(master), newFakePool(replica), HashLayout, ""),
+		DisableAccessLog: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateCert("fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if master.count("HSET") == 0 || replica.count("HSET") != 0 {
+		t.Errorf("expected the create written to the master only, got %d and %d HSETs", master.count("HSET"), replica.count("HSET"))
+	}
+	// the replica hasn't caught up yet
+	if _, found, err := db.GetCert("fanatics.com"); found || err != nil {
+		t.Errorf("expected the lookup read from the replica, got %v %v", found, err)
+	}
+	for key, fields := range master.hashes {
+		for field, value := range fields {
+			replica.set(key, field, value)
+		}
+	}
+	if _, found, err := db.GetCert("fanatics.com"); !found || err != nil {
+		t.Errorf("expected the replicated cert found, got %v %v", found, err)
+	}
+
+	if !db.PingRedis(context.Background()) {
+		t.Error("expected healthy with both up")
+	}
+	replica.fail = func(cmd string) error { return errors.New("connection refused") }
+	if db.PingRedis(context.Background()) {
+		t.Error("expected degraded with the replica down")
+	}
+	if reason := db.(*dbConn).unready(context.Background()); reason != "the redis read replica can't be reached" {
+		t.Errorf("expected /ready to blame the replica, got %q", reason)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), RedisReadAddr: "replica:6379"}); err == nil {
+		t.Error("expected a read replica to be rejected for a custom Storage")
+	}
+}
+
+// TestReadReplicaCap checks the cap is checked against the master, not a replica that hasn't caught up.
+func TestReadReplicaCap(t *testing.T) {
+	master, replica := newFakeRedis(), newFakeRedis()
+	db, err := NewCertificateServiceWithConfig(Config{
+		Storage:          NewReplicatedRedisStorage(newFakePool(master), newFakePool(replica), HashLayout, ""),
+		MaxDomains:       1,
+		DisableAccessLog: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateCert("fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateCert("example.com"); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("expected ErrStoreFull with the replica behind, got %v", err)
+	}
+	if replica.count("HLEN") != 0 || replica.count("HGET") != 0 {
+		t.Errorf("expected the cap checked on the master, got %d HLENs and %d HGETs on the replica", replica.count("HLEN"), replica.count("HGET"))
+	}
+}
+
+/*
+TestReadReplicaStrict checks the existence checks of strict creates and renewals, single and
+batched, are read from the master, not a replica that hasn't caught up.
+*/
+func TestReadReplicaStrict(t *testing.T) {
+	master, replica := newFakeRedis(), newFakeRedis()
+	service, err := NewCertificateServiceWithConfig(Config{
+		Storage:          NewReplicatedRedisStorage(newFakePool(master), newFakePool(replica), HashLayout, ""),
+		StrictCreate:     true,
+		DisableAccessLog: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := service.(*dbConn)
+	if _, err := db.CreateCert("fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.issue(context.Background(), "fanatics.com", 0, nil, nil, createOnly); !errors.Is(err, ErrDomainExists) {
+		t.Errorf("expected ErrDomainExists with the replica behind, got %v", err)
+	}
+	if _, err := db.Renew("fanatics.com"); err != nil {
+		t.Errorf("expected the renewal to find the cert, got %v", err)
+	}
+	if results := db.createBatch(context.Background(), []string{"fanatics.com"}); results[0].Code != CodeAlreadyExists {
+		t.Errorf("expected the batch to find the cert, got %+v", results)
+	}
+	if n := replica.count("HGET"); n != 0 {
+		t.Errorf("expected nothing read from the replica, got %d HGETs", n)
+	}
+}
+
+/*
+TestDialTLS stands up a TLS listener answering PING like redis, and checks the dial path
+verifies its certificate by default, trusts it through TLSConfig, and can skip verification.
+*/
+func TestDialTLS(t *testing.T) {
+	_, certPEM, keyPEM, err := generateCert("localhost", nil, time.Now(), time.Now().Add(time.Hour), ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pair}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// every command is a PING, sent as an array of one bulk string: *1 $4 PING
+				for i := 0; ; i++ {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+					if i%3 == 2 {
+						io.WriteString(conn, "+PONG\r\n")
+					}
+				}
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	configs := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"verified by default", Config{UseTLS: true}, false},
+		{"trusted root", Config{UseTLS: true, TLSConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}, true},
+		{"skip verify", Config{UseTLS: true, TLSSkipVerify: true}, true},
+	}
+	for _, c := range configs {
+		conn, err := redis.Dial("tcp", ln.Addr().String(), dialOptions(c.cfg)...)
+		if err == nil {
+			_, err = conn.Do("PING")
+			conn.Close()
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected success %v, got %v", c.name, c.ok, err)
+		}
+	}
+}
+
+/*
+TestConfigDial checks the default storage dials through Config.Dial, so the error handling of
+createCert and getCert can be driven by a double of redis.Conn: a nil reply, a failed command,
+a malformed expiry and a failed dial.
+*/
+func TestConfigDial(t *testing.T) {
+	fake := newFakeRedis()
+	svc, err := NewCertificateServiceWithConfig(Config{
+		Dial:             func() (redis.Conn, error) { return &fakeConn{f: fake}, nil },
+		DisableAccessLog: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := svc.(*dbConn)
+	ctx := context.Background()
+
+	if _, err := db.getCert(ctx, "fanatics.com"); !errors.Is(err, ErrDomainNotFound) {
+		t.Errorf("expected a nil reply to be ErrDomainNotFound, got %v", err)
+	}
+	if _, err := db.createCert(ctx, "fanatics.com"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.count("HSET") == 0 {
+		t.Error("expected the cert written through the dialed connection")
+	}
+	if _, err := db.getCert(ctx, "fanatics.com"); err != nil {
+		t.Errorf("expected the cert read back, got %v", err)
+	}
+
+	fake.set("Domain", "fanatics.com", []byte{expiryV3, 'x'})
+	if _, err := db.getCert(ctx, "fanatics.com"); !errors.Is(err, errCorruptExpiry) {
+		t.Errorf("expected a malformed expiry to be errCorruptExpiry, got %v", err)
+	}
+
+	fake.fail = func(cmd string) error { return errors.New("connection reset") }
+	if _, err := db.createCert(ctx, "fanatics.com"); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("expected the failed command reported, got %v", err)
+	}
+	fake.fail = nil
+
+	svc, _ = NewCertificateServiceWithConfig(Config{
+		Dial:             func() (redis.Conn, error) { return nil, errors.New("connection refused") },
+		DialRetryBase:    time.Millisecond,
+		DisableAccessLog: true,
+	})
+	if _, err := svc.(*dbConn).getCert(ctx, "fanatics.com"); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the failed dial reported, got %v", err)
+	}
+
+	if _, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage(), Dial: func() (redis.Conn, error) { return nil, nil }}); err == nil {
+		t.Error("expected a dial func to be rejected for a custom Storage")
+	}
+}
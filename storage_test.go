@@ -0,0 +1,226 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStorage runs the same checks against every Storage implementation.
+func TestStorage(t *testing.T) {
+	stores := map[string]func() Storage{
+		"memory":               NewMemoryStorage,
+		"redis hash":           func() Storage { return newFakeStorage(newFakeRedis(), HashLayout) },
+		"redis per-domain key": func() Storage { return newFakeStorage(newFakeRedis(), PerDomainKeyLayout) },
+		"redis read replica": func() Storage {
+			fake := newFakeRedis()
+			return NewReplicatedRedisStorage(newFakePool(fake), newFakePool(fake), HashLayout, "")
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			ctx := context.Background()
+			if err := store.Ping(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get(ctx, "fanatics.com"); !errors.Is(err, ErrDomainNotFound) {
+				t.Fatalf("expected ErrDomainNotFound, got %v", err)
+			}
+
+			expires := time.Now().Add(time.Hour).Truncate(time.Second)
+			issuedAt := expires.Add(-time.Hour)
+			rec := Record{Expires: expires, IssuedAt: issuedAt, CertPEM: []byte("cert"), KeyPEM: []byte("key")}
+			for _, domain := range []string{"a.com", "b.com", "c.com", "fanatics.com"} {
+				if created, err := store.Set(ctx, domain, rec); !created || err != nil {
+					t.Fatalf("expected %s to be created, got %v %v", domain, created, err)
+				}
+			}
+			if created, err := store.Set(ctx, "fanatics.com", rec); created || err != nil {
+				t.Errorf("expected fanatics.com to be replaced, got %v %v", created, err)
+			}
+			got, err := store.Get(ctx, "fanatics.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Expires.Equal(expires) || !got.IssuedAt.Equal(issuedAt) || string(got.CertPEM) != "cert" || string(got.KeyPEM) != "key" {
+				t.Errorf("unexpected record %+v", got)
+			}
+
+			recs, err := store.GetMany(ctx, []string{"a.com", "missing.com", "b.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := recs["missing.com"]; len(recs) != 2 || ok {
+				t.Errorf("expected the two stored domains, got %d records", len(recs))
+			}
+
+			var scanned []string
+			cursor := 0
+			for {
+				var page []CertInfo
+				if cursor, page, err = store.Scan(ctx, cursor, 3); err != nil {
+					t.Fatal(err)
+				}
+				for _, cert := range page {
+					scanned = append(scanned, cert.Domain)
+					if !cert.Expires.Equal(expires) || !cert.IssuedAt.Equal(issuedAt) {
+						t.Errorf("expected %s to expire at %v, issued %v, got %+v", cert.Domain, expires, issuedAt, cert)
+					}
+				}
+				if cursor == 0 {
+					break
+				}
+			}
+			if len(scanned) != 4 {
+				t.Errorf("expected to scan 4 domains, got %v", scanned)
+			}
+
+			if ok, err := store.Delete(ctx, "fanatics.com"); !ok || err != nil {
+				t.Errorf("expected fanatics.com to be deleted, got %v %v", ok, err)
+			}
+			if ok, _ := store.Delete(ctx, "fanatics.com"); ok {
+				t.Errorf("deleting a missing domain should report false")
+			}
+			certs, err := store.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != 3 || !certs["a.com"].Equal(expires) {
+				t.Errorf("unexpected certs %v", certs)
+			}
+			if n, err := store.Count(ctx); n != 3 || err != nil {
+				t.Errorf("expected 3 certs counted, got %d %v", n, err)
+			}
+
+			if err := store.Revoke(ctx, "1a", time.Now().Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			// revoking another cert drops the revocation of the cert that has expired
+			if err := store.Revoke(ctx, "2b", expires); err != nil {
+				t.Fatal(err)
+			}
+			revoked, err := store.Revoked(ctx, []string{"1a", "2b", "3c"})
+			if err != nil || len(revoked) != 1 || !revoked["2b"] {
+				t.Errorf("expected only 2b to be revoked, got %v %v", revoked, err)
+			}
+
+			for i := 0; i < 3; i++ {
+				entry := HistoryEntry{Serial: strconv.Itoa(i), IssuedAt: expires.Add(time.Duration(i) * time.Minute), ExpiresAt: expires}
+				if err := store.AddHistory(ctx, "a.com", entry, 2); err != nil {
+					t.Fatal(err)
+				}
+			}
+			history, err := store.History(ctx, "a.com")
+			if err != nil || len(history) != 2 || history[0].Serial != "2" || history[1].Serial != "1" || !history[0].ExpiresAt.Equal(expires) {
+				t.Errorf("expected the latest 2 issuances, got %v %v", history, err)
+			}
+			if history, err := store.History(ctx, "b.com"); err != nil || len(history) != 0 {
+				t.Errorf("expected no history, got %v %v", history, err)
+			}
+
+			result := IdempotentResult{Status: 201, Body: "OK"}
+			if err := store.SaveIdempotent(ctx, "key", result, time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SaveIdempotent(ctx, "gone", result, time.Now().Add(time.Millisecond)); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond * 5)
+			if got, ok, err := store.LoadIdempotent(ctx, "key"); !ok || err != nil || got != result {
+				t.Errorf("expected the saved result, got %v %v %v", got, ok, err)
+			}
+			if _, ok, err := store.LoadIdempotent(ctx, "gone"); ok || err != nil {
+				t.Errorf("expected an expired result to be gone, got %v %v", ok, err)
+			}
+
+			if n, err := store.Flush(ctx); n != 3 || err != nil {
+				t.Errorf("expected 3 certs flushed, got %d %v", n, err)
+			}
+			if n, err := store.Count(ctx); n != 0 || err != nil {
+				t.Errorf("expected no certs after a flush, got %d %v", n, err)
+			}
+			if history, err := store.History(ctx, "a.com"); err != nil || len(history) != 2 {
+				t.Errorf("expected the history to outlive a flush, got %v %v", history, err)
+			}
+		})
+	}
+}
+
+// TestMemoryStorageService checks the service works end to end over the in-memory Storage.
+func TestMemoryStorageService(t *testing.T) {
+	svc, err := NewCertificateServiceWithConfig(Config{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := svc.(*dbConn)
+	if !db.PingRedis(context.Background()) {
+		t.Fatal("expected the memory storage to be alive")
+	}
+
+	responses := []struct{ path, want string }{
+		{"/certcreate/fanatics.com", "<h1>OK, foo{fanatics.com} created"},
+		{"/cert/fanatics.com", "<h1>foo{fanatics.com} valid for"},
+		{"/cert/missing.com", "<h1>This domain doesn't exist: missing.com. Submit a cert request to /certcreate/{domain}</h1>"},
+	}
+	for _, r := range responses {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, newRequest(r.path))
+		if !strings.HasPrefix(rec.Body.String(), r.want) {
+			t.Errorf("%s: expected %s, got %s", r.path, r.want, rec.Body.String())
+		}
+	}
+	if names := db.GetAll(); len(names) != 1 || names[0] != "fanatics.com" {
+		t.Errorf("unexpected domains %v", names)
+	}
+}
+
+// TestNamespace checks services in different namespaces share a redis without seeing each other's certs.
+func TestNamespace(t *testing.T) {
+	for _, layout := range []KeyLayout{HashLayout, PerDomainKeyLayout} {
+		fake := newFakeRedis()
+		tenantA := newFakeDBWithConfig(fake, Config{KeyLayout: layout, Namespace: "tenantA"})
+		tenantB := newFakeDBWithConfig(fake, Config{KeyLayout: layout, Namespace: "tenantB"})
+		shared := newFakeDBWithConfig(fake, Config{KeyLayout: layout})
+
+		if _, err := tenantA.createCert(context.Background(), "fanatics.com"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := shared.createCert(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tenantA.getCert(context.Background(), "fanatics.com"); err != nil {
+			t.Errorf("layout %d: tenantA can't read its own cert: %v", layout, err)
+		}
+		for _, db := range []*dbConn{tenantB, shared} {
+			if _, err := db.getCert(context.Background(), "fanatics.com"); !errors.Is(err, ErrDomainNotFound) {
+				t.Errorf("layout %d: tenantA's cert leaked into another namespace: %v", layout, err)
+			}
+		}
+		if all := tenantA.GetAll(); len(all) != 1 || all[0] != "fanatics.com" {
+			t.Errorf("layout %d: expected tenantA to list only its cert, got %v", layout, all)
+		}
+		if n, err := shared.Count(); n != 1 || err != nil {
+			t.Errorf("layout %d: expected the unprefixed keys to hold 1 cert, got %d %v", layout, n, err)
+		}
+	}
+
+	fake := newFakeRedis()
+	for _, layout := range []KeyLayout{HashLayout, PerDomainKeyLayout} {
+		if _, err := newFakeDBWithConfig(fake, Config{KeyLayout: layout, Namespace: "tenantA"}).createCert(context.Background(), "fanatics.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"tenantA:Domain", "tenantA:Certificate", "tenantA:PrivateKey", "tenantA:cert:fanatics.com"} {
+		if len(fake.hashes[key]) == 0 {
+			t.Errorf("expected the cert under the %s key", key)
+		}
+	}
+	if _, err := NewCertificateServiceWithConfig(Config{Namespace: "tenantA", Storage: NewMemoryStorage()}); err == nil {
+		t.Error("a namespace with a custom Storage should be rejected")
+	}
+}
@@ -0,0 +1,65 @@
+package CertificateService
+
+import (
+	"context"
+	"time"
+)
+
+// CertInfo is a stored domain and the expiration date of its certificate.
+type CertInfo struct {
+	Domain  string
+	Expires time.Time
+	// IssuedAt is when the cert was issued, zero if it was stored before that was kept.
+	IssuedAt time.Time
+	// Metadata is what the create that issued the cert attached to it, nil if it attached nothing.
+	Metadata map[string]string
+}
+
+/*
+StreamCertificates walks every stored domain with Storage.Scan, HSCAN for redis, and emits them one at a time, so
+huge stores can be processed without building the whole listing in memory. The certificate
+channel is closed when the walk completes or stops. At most one error is sent on the error
+channel before it is closed: a redis failure, or ctx.Err() if the context is cancelled.
+*/
+func (db *dbConn) StreamCertificates(ctx context.Context) (<-chan CertInfo, <-chan error) {
+	certs := make(chan CertInfo)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(certs)
+
+		cursor := 0
+		for {
+			var page []CertInfo
+			var err error
+			if cursor, page, err = db.scanPage(ctx, cursor); err != nil {
+				errc <- err
+				return
+			}
+			for _, cert := range page {
+				if ctx.Err() != nil {
+					errc <- ctx.Err()
+					return
+				}
+				select {
+				case certs <- cert:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			}
+			// a cursor of 0 means the walk is complete
+			if cursor == 0 {
+				return
+			}
+		}
+	}()
+	return certs, errc
+}
+
+// scanPage reads one page of Config.ScanCount certs, bounded by the redis timeout.
+func (db *dbConn) scanPage(ctx context.Context, cursor int) (int, []CertInfo, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	return db.store.Scan(ctx, cursor, db.scanCount)
+}
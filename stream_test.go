@@ -0,0 +1,58 @@
+package CertificateService
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestStreamCertificates checks every stored domain is emitted across several HSCAN pages.
+func TestStreamCertificates(t *testing.T) {
+	fake := newFakeRedis()
+	expires := time.Now().Add(time.Minute).Truncate(time.Second)
+	for i := 0; i < defaultScanCount*2+5; i++ {
+		fake.set("Domain", fmt.Sprintf("domain%d.com", i), encode(expires, time.Time{}, nil))
+	}
+
+	certs, errc := newFakeDB(fake).StreamCertificates(context.Background())
+	seen := make(map[string]bool)
+	for cert := range certs {
+		if !cert.Expires.Equal(expires) {
+			t.Errorf("%s: expected expiry %v, got %v", cert.Domain, expires, cert.Expires)
+		}
+		seen[cert.Domain] = true
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != defaultScanCount*2+5 {
+		t.Errorf("expected %d domains, received %d", defaultScanCount*2+5, len(seen))
+	}
+	if fake.count("HSCAN") != 3 {
+		t.Errorf("expected 3 HSCAN pages, got %d", fake.count("HSCAN"))
+	}
+}
+
+// TestStreamCertificatesCancel checks cancelling the context stops emission.
+func TestStreamCertificatesCancel(t *testing.T) {
+	fake := newFakeRedis()
+	for i := 0; i < 50; i++ {
+		fake.set("Domain", fmt.Sprintf("domain%d.com", i), encode(time.Now(), time.Time{}, nil))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	certs, errc := newFakeDB(fake).StreamCertificates(ctx)
+	<-certs
+	cancel()
+	received := 1
+	for range certs {
+		received++
+	}
+	if received > 2 {
+		t.Errorf("expected emission to stop after cancelling, received %d", received)
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
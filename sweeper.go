@@ -0,0 +1,142 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+sweepExpired deletes every cert that expired more than grace ago, walking the store a page at
+a time so the sweep never holds the whole listing in memory or a connection between pages.
+It returns the number of certs deleted, including on error. The same walk counts the certs
+expiring within the expiring window, setting the expiring gauge once it is complete, so the
+gauge keeps the last complete count when a sweep fails and a scrape never reads the store.
+Each cert is deleted by sweepCert, so one renewed since its page was read is kept.
+*/
+func (db *dbConn) sweepExpired(ctx context.Context, grace time.Duration) (int, error) {
+	now := db.now()
+	cutoff := now.Add(-grace)
+	expiringBy := now.Add(db.expiringWindow)
+	deleted := 0
+	expiring := map[string]int{"server": 0, "domain": 0}
+	cursor := 0
+	for {
+		var page []CertInfo
+		var err error
+		if cursor, page, err = db.scanPage(ctx, cursor); err != nil {
+			return deleted, err
+		}
+		for _, cert := range page {
+			if !cert.Expires.Before(now) && cert.Expires.Before(expiringBy) {
+				expiring[db.certKind(cert.Domain)]++
+			}
+			if !cert.Expires.Before(cutoff) {
+				continue
+			}
+			ok, err := db.sweepCert(ctx, cert.Domain, cutoff)
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				deleted++
+			}
+		}
+		// a cursor of 0 means the walk is complete
+		if cursor == 0 {
+			for kind, n := range expiring {
+				db.metrics.expiring.WithLabelValues(kind).Set(float64(n))
+			}
+			return deleted, nil
+		}
+	}
+}
+
+/*
+sweepCert deletes the cert of domainName if it still expired before cutoff, read again from the
+master, holding the domain's slot in db.creates so no create or renewal writes a cert between
+the check and the delete.
+*/
+func (db *dbConn) sweepCert(ctx context.Context, domainName string, cutoff time.Time) (bool, error) {
+	release := db.creates.hold(domainName)
+	defer release()
+	getCtx, cancel := db.withTimeout(readMaster(ctx))
+	start := time.Now()
+	rec, err := db.store.Get(getCtx, domainName)
+	cancel()
+	db.observeRedis("get", start, err, "domain", domainName)
+	switch {
+	case errors.Is(err, ErrDomainNotFound):
+		// deleted since, or stored before X.509 issuance with only its expiry, swept all the same
+	case err != nil:
+		return false, err
+	case !rec.Expires.Before(cutoff):
+		// renewed since
+		return false, nil
+	}
+	return db.deleteCert(ctx, domainName)
+}
+
+// certKind is how the expiring gauge labels domainName: server for the service's own cert, else domain.
+func (db *dbConn) certKind(domainName string) string {
+	if domainName == db.serverDomain {
+		return "server"
+	}
+	return "domain"
+}
+
+// deleteCert removes the cert stored for domainName, bounded by the redis timeout.
+func (db *dbConn) deleteCert(ctx context.Context, domainName string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	ok, err := db.store.Delete(ctx, domainName)
+	db.observeRedis("delete", start, err, "domain", domainName)
+	if ok {
+		db.audit(ctx, "delete", domainName)
+	}
+	return ok, err
+}
+
+/*
+startSweeper sweeps certs that expired more than grace ago every interval, in the background,
+until the returned stop func is called. Requests aren't held up by a sweep, it borrows a
+pooled connection per call like any request would.
+*/
+func (db *dbConn) startSweeper(interval time.Duration, grace time.Duration) (stop func()) {
+	return runEvery(interval, func(ctx context.Context) {
+		deleted, err := db.sweepExpired(ctx, grace)
+		if err != nil && ctx.Err() == nil {
+			db.logger.Error("sweeping expired certs failed", "deleted", deleted, "err", err)
+		} else if deleted > 0 {
+			db.logger.Info("swept expired certs", "deleted", deleted)
+		}
+	})
+}
+
+/*
+runEvery calls fn every interval in a goroutine of its own until the returned stop func is
+called. The context passed to fn is cancelled on stop, and stop waits for a running call to
+return.
+*/
+func runEvery(interval time.Duration, fn func(ctx context.Context)) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			fn(ctx)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
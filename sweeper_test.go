@@ -0,0 +1,113 @@
+package CertificateService
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestSweepExpired checks only certs expired for longer than the grace period are deleted.
+func TestSweepExpired(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	now := time.Now()
+	certs := map[string]time.Time{
+		"valid.com":   now.Add(time.Minute),
+		"recent.com":  now.Add(-time.Minute),
+		"old.com":     now.Add(-time.Hour * 2),
+		"ancient.com": now.Add(-time.Hour * 24),
+	}
+	for domain, expires := range certs {
+		if _, err := db.storeCert(context.Background(), domain, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := db.sweepExpired(context.Background(), time.Hour)
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected 2 certs swept, got %d %v", deleted, err)
+	}
+	left, err := db.ListCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, valid := left["valid.com"]
+	_, recent := left["recent.com"]
+	if !valid || !recent || len(left) != 2 {
+		t.Errorf("expected valid.com and recent.com, within the grace period, to be kept, got %v", left)
+	}
+}
+
+// TestSweepRenewed checks a cert renewed while a sweep waits to delete it is kept.
+func TestSweepRenewed(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	if _, err := db.storeCert(context.Background(), "fanatics.com", time.Now().Add(-time.Hour*2)); err != nil {
+		t.Fatal(err)
+	}
+	running, release := make(chan struct{}), make(chan struct{})
+	go db.creates.do("fanatics.com", 0, nil, nil, createOrRenew, func() (*x509.Certificate, bool, error) {
+		close(running)
+		<-release
+		cert, err := db.storeCert(context.Background(), "fanatics.com", time.Now().Add(time.Hour))
+		return cert, false, err
+	})
+	<-running
+	time.AfterFunc(time.Millisecond*20, func() { close(release) })
+	if deleted, err := db.sweepExpired(context.Background(), time.Hour); err != nil || deleted != 0 {
+		t.Errorf("expected the renewed cert kept, got %d swept %v", deleted, err)
+	}
+	if _, err := db.getCert(context.Background(), "fanatics.com"); err != nil {
+		t.Errorf("expected the renewed cert stored, got %v", err)
+	}
+}
+
+// TestSweeper checks the background sweeper deletes expired certs until it is stopped.
+func TestSweeper(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	if _, err := db.storeCert(context.Background(), "old.com", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	stop := db.startSweeper(time.Millisecond*5, time.Minute)
+	for deadline := time.Now().Add(time.Second * 5); len(db.GetAll()) != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the sweeper didn't delete the expired cert")
+		}
+	}
+	stop()
+
+	scans := fake.count("HSCAN")
+	time.Sleep(time.Millisecond * 20)
+	if fake.count("HSCAN") != scans {
+		t.Error("the sweeper kept running after it was stopped")
+	}
+}
+
+// TestSweepExpiring checks a sweep counts the certs expiring within the window, the server's apart.
+func TestSweepExpiring(t *testing.T) {
+	db := newFakeDBWithConfig(newFakeRedis(), Config{ExpiringWindow: time.Minute * 5})
+	now := time.Now()
+	certs := map[string]time.Time{
+		"soon.com":      now.Add(time.Minute),
+		"sooner.com":    now.Add(time.Second * 10),
+		"later.com":     now.Add(time.Hour),
+		"expired.com":   now.Add(-time.Minute),
+		db.serverDomain: now.Add(time.Minute * 2),
+	}
+	for domain, expires := range certs {
+		if _, err := db.storeCert(context.Background(), domain, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.sweepExpired(context.Background(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.ToFloat64(db.metrics.expiring.WithLabelValues("domain")); n != 2 {
+		t.Errorf("expected 2 domains expiring, got %v", n)
+	}
+	if n := testutil.ToFloat64(db.metrics.expiring.WithLabelValues("server")); n != 1 {
+		t.Errorf("expected the server cert expiring, got %v", n)
+	}
+}
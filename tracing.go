@@ -0,0 +1,57 @@
+package CertificateService
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	//imported package, run go get go.opentelemetry.io/otel
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans of this package.
+const tracerName = "CertificateService"
+
+// startSpan starts a span named name, a child of the span in ctx, carrying attrs.
+func (db *dbConn) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return db.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed with err unless err is nil or only reports a missing domain.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrDomainNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// cacheResult is the outcome of a lookup that returned err: hit, miss, or error if the store failed.
+func cacheResult(err error) attribute.KeyValue {
+	result := "hit"
+	if errors.Is(err, ErrDomainNotFound) {
+		result = "miss"
+	} else if err != nil {
+		result = "error"
+	}
+	return attribute.String("cache.result", result)
+}
+
+/*
+traceRoute is a Middleware tracing every request to pattern in a span named for route, which
+continues the trace the request's headers carry, if any.
+*/
+func (db *dbConn) traceRoute(pattern string, route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := db.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := db.tracer.Start(ctx, "HTTP "+route, trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attribute.String("http.request.method", r.Method), attribute.String("http.route", pattern)))
+			defer span.End()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package CertificateService
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+/*
+TestTracing sends requests carrying a W3C traceparent header and checks their spans join that
+trace, with the store calls as children carrying the domain, operation and cache result.
+*/
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	db := newFakeDBWithConfig(newFakeRedis(), Config{TracerProvider: provider})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	send := func(path string) {
+		r := newRequest(path)
+		r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		db.httpHandler(httptest.NewRecorder(), r)
+	}
+	send("/certcreate/fanatics.com")
+	send("/cert/fanatics.com")
+	send("/cert/missing.com")
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %s isn't part of the request's trace", span.Name())
+		}
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	if len(spans["HTTP create"]) != 1 || len(spans["createCert"]) != 1 || len(spans["HTTP retrieve"]) != 2 {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	if parent := spans["createCert"][0].Parent().SpanID(); parent != spans["HTTP create"][0].SpanContext().SpanID() {
+		t.Errorf("expected createCert to be a child of the request span")
+	}
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+		m := make(map[attribute.Key]string)
+		for _, kv := range span.Attributes() {
+			m[kv.Key] = kv.Value.Emit()
+		}
+		return m
+	}
+	if a := attrs(spans["HTTP create"][0]); a["domain"] != "fanatics.com" || a["operation"] != "create" {
+		t.Errorf("unexpected request attributes %v", a)
+	}
+	var results []string
+	for _, span := range spans["getCert"] {
+		a := attrs(span)
+		if a["operation"] != "retrieve" {
+			t.Errorf("unexpected getCert attributes %v", a)
+		}
+		results = append(results, a["domain"]+" "+a["cache.result"])
+	}
+	// missing.com isn't a subdomain, so there is no wildcard lookup
+	if len(results) != 2 || results[0] != "fanatics.com hit" || results[1] != "missing.com miss" {
+		t.Errorf("unexpected cache results %v", results)
+	}
+}
@@ -0,0 +1,242 @@
+package CertificateService
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	//imported package, run go get golang.org/x/net/idna
+	"golang.org/x/net/idna"
+)
+
+// maxDomainLength is the longest domain name DNS allows, in bytes.
+const maxDomainLength = 253
+
+/*
+validDomainPattern matches the domains a cert can be created for.
+
+Valid domains are any number of labels separated by a '.', where each label is 1-63
+alphanumeric or '-' characters that doesn't start or end with a '-', followed by a final
+'.' and a top level domain of 2 or more letters.
+Examples:
+Valid: Fanatics.com
+Valid: sub.Fanatics.co.uk
+Invalid: Fanatics (no extension)
+Invalid: -Fanatics.com (label starts with a '-')
+*/
+var validDomainPattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}$`)
+
+/*
+IsValidDomain reports whether the service would accept domainName, so callers can check
+their input before sending it. IP addresses are rejected outright, whatever the pattern
+would make of them.
+*/
+func IsValidDomain(domainName string) bool {
+	return !isIPAddress(domainName) && len(domainName) <= maxDomainLength && validDomainPattern.MatchString(domainName)
+}
+
+// errIPAddress is why an IP address submitted as a domain name is rejected.
+const errIPAddress = "IP addresses are not valid domains"
+
+/*
+isIPAddress reports whether domainName is an IPv4 or IPv6 address, including the bracketed
+form of IPv6 used in URLs, [::1], and an IPv6 address with a zone, fe80::1%eth0.
+*/
+func isIPAddress(domainName string) bool {
+	host := strings.TrimSuffix(strings.TrimPrefix(domainName, "["), "]")
+	if i := strings.LastIndex(host, "%"); i >= 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+	return net.ParseIP(host) != nil
+}
+
+/*
+canonicalDomain returns the form domainName is stored under: lowercase, without the trailing
+dot of a fully qualified name. "Fanatics.COM." and "fanatics.com" are the same domain.
+
+Internationalized domains are converted to their ASCII punycode form, so "München.de" and
+"xn--mnchen-3ya.de" are the same domain too. A name idna can't convert is returned lowercased
+as is and left for the validator to reject.
+*/
+func canonicalDomain(domainName string) string {
+	domainName = strings.TrimSuffix(strings.ToLower(domainName), ".")
+	// the * of a wildcard isn't a valid label to idna, convert only the domain it covers
+	if strings.HasPrefix(domainName, wildcardPrefix) {
+		return wildcardPrefix + canonicalDomain(strings.TrimPrefix(domainName, wildcardPrefix))
+	}
+	if ascii, err := idna.Lookup.ToASCII(domainName); err == nil {
+		return ascii
+	}
+	return domainName
+}
+
+// wildcardPrefix marks a wildcard cert, which covers every subdomain one label below it.
+const wildcardPrefix = "*."
+
+/*
+wildcardFor returns the wildcard cert that would cover domainName, *.example.com for
+sub.example.com. As in X.509, a wildcard only stands in for a single label, so there is no
+wildcard for a wildcard or for a domain directly under a top level domain.
+*/
+func wildcardFor(domainName string) (string, bool) {
+	if strings.HasPrefix(domainName, wildcardPrefix) {
+		return "", false
+	}
+	i := strings.Index(domainName, ".")
+	if i < 0 || !strings.Contains(domainName[i+1:], ".") {
+		return "", false
+	}
+	return wildcardPrefix + domainName[i+1:], true
+}
+
+// maxLabelLength is the longest label DNS allows, in bytes.
+const maxLabelLength = 63
+
+/*
+ValidationError is returned by ValidateDomain for a domain the service rejects, listing every
+reason it is rejected, so a client can fix them all at once.
+*/
+type ValidationError struct {
+	Domain  string
+	Reasons []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid domain name " + e.Domain + ": " + strings.Join(e.Reasons, "; ")
+}
+
+/*
+ValidateDomain returns nil if the service would accept domainName, as IsValidDomain reports,
+or else a *ValidationError explaining why not.
+*/
+func ValidateDomain(domainName string) error {
+	if reasons := invalidReasons(domainName); len(reasons) > 0 {
+		return &ValidationError{Domain: domainName, Reasons: reasons}
+	}
+	return nil
+}
+
+// invalidReason explains why IsValidDomain rejects domainName in one line, or returns "" if it doesn't.
+func invalidReason(domainName string) string {
+	return strings.Join(invalidReasons(domainName), "; ")
+}
+
+/*
+invalidReasons lists every reason IsValidDomain rejects domainName, or returns nil if it
+doesn't. The checks mirror validDomainPattern, the pattern itself having the final say. An
+empty domain or an IP address is reported as that alone, as nothing else about it matters.
+*/
+func invalidReasons(domainName string) []string {
+	if IsValidDomain(domainName) {
+		return nil
+	}
+	if domainName == "" {
+		return []string{"the domain name is empty"}
+	}
+	if isIPAddress(domainName) {
+		return []string{errIPAddress}
+	}
+	var reasons []string
+	if len(domainName) > maxDomainLength {
+		reasons = append(reasons, fmt.Sprintf("the domain name is %d bytes, longer than the %d DNS allows", len(domainName), maxDomainLength))
+	}
+	labels := strings.Split(domainName, ".")
+	hasTLD := len(labels) > 1
+	tld := labels[len(labels)-1]
+	if hasTLD {
+		labels = labels[:len(labels)-1]
+	} else {
+		// the only label is checked as any other
+		reasons = append(reasons, "the domain name has no top level domain, such as .com")
+	}
+	empty := false
+	for _, label := range labels {
+		if label == "" {
+			empty = true
+			continue
+		}
+		reasons = append(reasons, labelReasons(label)...)
+	}
+	if empty {
+		reasons = append(reasons, "the domain name has an empty label, a leading '.' or '..'")
+	}
+	if hasTLD && (len(tld) < 2 || len(tld) > maxLabelLength || strings.IndexFunc(tld, func(c rune) bool { return !isLetter(c) }) >= 0) {
+		reasons = append(reasons, fmt.Sprintf("the top level domain %q isn't 2 to %d letters", tld, maxLabelLength))
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "the domain name is invalid")
+	}
+	return reasons
+}
+
+// labelReasons lists every reason label, one below the top level domain, is invalid.
+func labelReasons(label string) []string {
+	var reasons []string
+	if len(label) > maxLabelLength {
+		reasons = append(reasons, fmt.Sprintf("label %q is longer than %d characters", label, maxLabelLength))
+	}
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		reasons = append(reasons, fmt.Sprintf("label %q starts or ends with a '-'", label))
+	}
+	var illegal []string
+	seen := make(map[rune]bool)
+	for _, c := range label {
+		if !isLetter(c) && !isDigit(c) && c != '-' && !seen[c] {
+			seen[c] = true
+			illegal = append(illegal, fmt.Sprintf("%q", c))
+		}
+	}
+	if len(illegal) > 0 {
+		reasons = append(reasons, fmt.Sprintf("label %q contains %s, only letters, digits and '-' are allowed", label, strings.Join(illegal, ", ")))
+	}
+	return reasons
+}
+
+func isLetter(c rune) bool { return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') }
+
+func isDigit(c rune) bool { return '0' <= c && c <= '9' }
+
+/*
+validation is the JSON response of /validate. Reasons lists every reason the domain is invalid,
+and Reason is them all in one line, as it was before they were listed. Code is
+CodeInvalidDomain for an invalid domain, or CodeInvalidRequest when it can't be decoded.
+*/
+type validation struct {
+	Domain  string    `json:"domain"`
+	Valid   bool      `json:"valid"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Reasons []string  `json:"reasons,omitempty"`
+}
+
+/*
+validateHandler reports whether a cert could be created for the domain in the path after
+/validate/, without creating one or touching redis: 200 {"valid":true}, or 400
+{"valid":false,"reasons":[...]} explaining why not. The domain is normalized first, as a create
+would, and reported in its canonical form.
+*/
+func (db *dbConn) validateHandler(w http.ResponseWriter, r *http.Request) {
+	result := validation{}
+	domain, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), db.basePath+"/validate/"))
+	if err != nil {
+		result.Code, result.Reasons = CodeInvalidRequest, []string{"the domain name isn't URL encoded correctly"}
+	} else {
+		result.Domain = canonicalDomain(domain)
+		// a wildcard is valid when the domain it covers is, as for a create
+		result.Reasons = invalidReasons(strings.TrimPrefix(result.Domain, wildcardPrefix))
+		result.Valid = len(result.Reasons) == 0
+		if !result.Valid {
+			result.Code = CodeInvalidDomain
+		}
+	}
+	result.Reason = strings.Join(result.Reasons, "; ")
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Valid {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
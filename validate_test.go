@@ -0,0 +1,268 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestIsValidDomain covers the domain validator, including the multi-label domains it used to reject.
+func TestIsValidDomain(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+	tests := []struct {
+		domain string
+		valid  bool
+	}{
+		{"Fanatics.com", true},
+		{"fanatics.co.uk", true},
+		{"sub.example.co.uk", true},
+		{"a.b.c.d.example.com", true},
+		{"my-site.com", true},
+		{"x.io", true},
+		{"123.example.com", true},
+		{label63 + ".com", true},
+		{"Fanatics", false},
+		{"", false},
+		{".com", false},
+		{"example.c", false},
+		{"example.c0m", false},
+		{"-example.com", false},
+		{"example-.com", false},
+		{"sub.-example.com", false},
+		{"example..com", false},
+		{"example.com.", false},
+		{"exa_mple.com", false},
+		{"exa|mple.com", false},
+		{label63 + "a.com", false},
+		{strings.Repeat(label63+".", 3) + strings.Repeat("a", 57) + ".com", true},
+		{strings.Repeat(label63+".", 3) + strings.Repeat("a", 58) + ".com", false},
+		{"192.168.0.1", false},
+	}
+	for _, test := range tests {
+		if got := IsValidDomain(test.domain); got != test.valid {
+			t.Errorf("IsValidDomain(%q) = %v, expected %v", test.domain, got, test.valid)
+		}
+	}
+}
+
+/*
+TestCanonicalDomain checks case and trailing dot variants of a domain are stored under one
+key and found by each other, and that routes match regardless of case.
+*/
+func TestCanonicalDomain(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+
+	send := func(path string) string {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest(path))
+		return w.Body.String()
+	}
+
+	if body := send("/CERTCREATE/Fanatics.COM."); !strings.HasPrefix(body, "<h1>OK, foo{fanatics.com} created") {
+		t.Fatalf("unexpected create response %s", body)
+	}
+	if _, err := db.getCert(context.Background(), "fanatics.com"); err != nil {
+		t.Errorf("expected the cert to be stored as fanatics.com: %v", err)
+	}
+	for _, path := range []string{"/cert/fanatics.com", "/Cert/FANATICS.com", "/cert/fanatics.com."} {
+		if body := send(path); !strings.HasPrefix(body, "<h1>foo{fanatics.com} valid for") {
+			t.Errorf("%s: unexpected response %s", path, body)
+		}
+	}
+}
+
+// TestCanonicalIDN checks internationalized domains are stored as punycode and found by either form.
+func TestCanonicalIDN(t *testing.T) {
+	tests := []struct {
+		unicode, ascii string
+	}{
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"München.DE", "xn--mnchen-3ya.de"},
+		{"例え.jp", "xn--r8jz45g.jp"},
+		{"bücher.example.com", "xn--bcher-kva.example.com"},
+	}
+	for _, test := range tests {
+		if got := canonicalDomain(test.unicode); got != test.ascii {
+			t.Errorf("canonicalDomain(%q) = %q, expected %q", test.unicode, got, test.ascii)
+		}
+		if got := canonicalDomain(test.ascii); got != test.ascii {
+			t.Errorf("canonicalDomain(%q) = %q, expected it unchanged", test.ascii, got)
+		}
+		if !IsValidDomain(test.ascii) {
+			t.Errorf("expected %q to be valid", test.ascii)
+		}
+	}
+
+	db := newFakeDB(newFakeRedis())
+	send := func(path string) string {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest(path))
+		return w.Body.String()
+	}
+	if body := send("/certcreate/" + url.PathEscape("münchen.de")); !strings.HasPrefix(body, "<h1>OK, foo{xn--mnchen-3ya.de} created") {
+		t.Fatalf("unexpected create response %s", body)
+	}
+	if _, err := db.getCert(context.Background(), "xn--mnchen-3ya.de"); err != nil {
+		t.Errorf("expected the punycode form to be stored: %v", err)
+	}
+	for _, domain := range []string{url.PathEscape("münchen.de"), "xn--mnchen-3ya.de"} {
+		if body := send("/cert/" + domain); !strings.HasPrefix(body, "<h1>foo{xn--mnchen-3ya.de} valid for") {
+			t.Errorf("%s: unexpected response %s", domain, body)
+		}
+	}
+	if body := send("/certcreate/" + url.PathEscape("-münchen.de")); !strings.HasPrefix(body, "<h1>Invalid domain name") {
+		t.Errorf("expected an invalid IDN to be rejected, got %s", body)
+	}
+}
+
+// TestDomainPath checks the domain in the path is URL-decoded, and one longer than DNS allows is a bad request.
+func TestDomainPath(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	// 253 bytes, the longest name DNS allows
+	longest := strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 57) + ".com"
+	requests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/certcreate/" + longest, http.StatusCreated, "<h1>OK, foo{" + longest + "} created"},
+		{"/cert/" + longest, http.StatusOK, "<h1>foo{" + longest + "} valid for"},
+		{"/certcreate/a" + longest, http.StatusBadRequest, "domain name too long\n"},
+		{"/cert/" + strings.Repeat("a", 10000) + ".com", http.StatusBadRequest, "domain name too long\n"},
+		{"/certcreate/fanatics%2Ecom", http.StatusCreated, "<h1>OK, foo{fanatics.com} created"},
+		{"/cert/fanatics.com", http.StatusOK, "<h1>foo{fanatics.com} valid for"},
+		{"/cert/fanatics%2Fcom", http.StatusOK, "<h1>Invalid domain name: fanatics/com</h1>"},
+	}
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		db.httpHandler(rec, newRequest(req.path))
+		if rec.Code != req.code || !strings.HasPrefix(rec.Body.String(), req.body) {
+			t.Errorf("%.40s: expected %d %q, got %d %q", req.path, req.code, req.body, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// TestInvalidReason checks every domain the validator rejects is explained, and only those.
+func TestInvalidReason(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+	for _, domain := range []string{"Fanatics.com", "sub.example.co.uk", "my-site.com", label63 + ".com"} {
+		if reason := invalidReason(domain); reason != "" {
+			t.Errorf("expected %q to be valid, got %q", domain, reason)
+		}
+	}
+	tests := []struct {
+		domain, reason string
+	}{
+		{"", "the domain name is empty"},
+		{strings.Repeat(label63+".", 4) + "com", "longer than the 253 DNS allows"},
+		{"192.168.0.1", errIPAddress},
+		{"2001:db8::1", errIPAddress},
+		{"Fanatics", "no top level domain"},
+		{".com", "empty label"},
+		{"example..com", "empty label"},
+		{label63 + "a.com", "longer than 63 characters"},
+		{"-example.com", `label "-example" starts or ends with a '-'`},
+		{"sub.example-.com", `label "example-" starts or ends with a '-'`},
+		{"exa_mple.com", `contains '_'`},
+		{"example.c", `top level domain "c" isn't 2 to 63 letters`},
+		{"example.c0m", `top level domain "c0m"`},
+		{"example.com.", `top level domain ""`},
+	}
+	for _, test := range tests {
+		if reason := invalidReason(test.domain); !strings.Contains(reason, test.reason) {
+			t.Errorf("invalidReason(%.20q) = %q, expected it to contain %q", test.domain, reason, test.reason)
+		}
+	}
+}
+
+// TestValidateDomain checks every problem with a domain is listed, each on its own.
+func TestValidateDomain(t *testing.T) {
+	if err := ValidateDomain("fanatics.com"); err != nil {
+		t.Errorf("expected fanatics.com to be valid, got %v", err)
+	}
+	long := strings.Repeat("a", 64)
+	domain := long + "." + strings.Repeat("a.", 95) + "-b|c_d-..x1"
+	err := ValidateDomain(domain)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Domain != domain {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	expected := []string{
+		"the domain name is 266 bytes, longer than the 253 DNS allows",
+		`label "` + long + `" is longer than 63 characters`,
+		`label "-b|c_d-" starts or ends with a '-'`,
+		`label "-b|c_d-" contains '|', '_', only letters, digits and '-' are allowed`,
+		"the domain name has an empty label, a leading '.' or '..'",
+		`the top level domain "x1" isn't 2 to 63 letters`,
+	}
+	if !reflect.DeepEqual(verr.Reasons, expected) {
+		t.Errorf("unexpected reasons\n%q\nexpected\n%q", verr.Reasons, expected)
+	}
+	if verr := ValidateDomain("Fanatics").(*ValidationError); len(verr.Reasons) != 1 || verr.Reasons[0] != "the domain name has no top level domain, such as .com" {
+		t.Errorf("expected only the missing top level domain, got %q", verr.Reasons)
+	}
+}
+
+// TestValidateEndpoint checks /validate answers from the validator alone, without redis.
+func TestValidateEndpoint(t *testing.T) {
+	fake := newFakeRedis()
+	db := newFakeDB(fake)
+	fake.fail = func(cmd string) error { return errors.New("redis called for " + cmd) }
+
+	tests := []struct {
+		path   string
+		code   int
+		result validation
+	}{
+		{"/validate/Fanatics.COM.", http.StatusOK, validation{Domain: "fanatics.com", Valid: true}},
+		{"/validate/" + url.PathEscape("münchen.de"), http.StatusOK, validation{Domain: "xn--mnchen-3ya.de", Valid: true}},
+		{"/validate/*.example.com", http.StatusOK, validation{Domain: "*.example.com", Valid: true}},
+		{"/validate/fanatics", http.StatusBadRequest, validation{Domain: "fanatics", Code: CodeInvalidDomain, Reason: "the domain name has no top level domain, such as .com", Reasons: []string{"the domain name has no top level domain, such as .com"}}},
+		{"/validate/-exa_mple.c", http.StatusBadRequest, validation{Domain: "-exa_mple.c", Code: CodeInvalidDomain, Reason: `label "-exa_mple" starts or ends with a '-'; label "-exa_mple" contains '_', only letters, digits and '-' are allowed; the top level domain "c" isn't 2 to 63 letters`, Reasons: []string{
+			`label "-exa_mple" starts or ends with a '-'`,
+			`label "-exa_mple" contains '_', only letters, digits and '-' are allowed`,
+			`the top level domain "c" isn't 2 to 63 letters`,
+		}}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, httptest.NewRequest("GET", test.path, nil))
+		var result validation
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != test.code || !reflect.DeepEqual(result, test.result) {
+			t.Errorf("%s: expected %d %+v, got %d %s", test.path, test.code, test.result, w.Code, w.Body)
+		}
+	}
+}
+
+// TestIPAddresses checks IPv4 and IPv6 addresses are rejected as IP addresses, not by the pattern alone.
+func TestIPAddresses(t *testing.T) {
+	for _, ip := range []string{"192.168.0.1", "0.0.0.0", "::1", "2001:db8::1", "[2001:db8::1]", "fe80::1%eth0", "::ffff:192.0.2.1", "2001:DB8:0:0:0:0:0:1"} {
+		if !isIPAddress(ip) || IsValidDomain(ip) || invalidReason(ip) != errIPAddress {
+			t.Errorf("expected %q to be rejected as an IP address, got %q", ip, invalidReason(ip))
+		}
+	}
+	for _, domain := range []string{"fanatics.com", "192.168.0.1.example.com", "1.2.3.com", "[fanatics.com]"} {
+		if isIPAddress(domain) {
+			t.Errorf("expected %q not to be an IP address", domain)
+		}
+	}
+
+	db := newFakeDB(newFakeRedis())
+	for _, ip := range []string{"192.168.0.1", url.PathEscape("[2001:db8::1]")} {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest("/certcreate/"+ip))
+		if !strings.HasPrefix(w.Body.String(), "<h1>"+errIPAddress) {
+			t.Errorf("%s: expected the IP address to be rejected, got %s", ip, w.Body)
+		}
+	}
+	if n := len(db.GetAll()); n != 0 {
+		t.Errorf("expected no certs created, got %d", n)
+	}
+}
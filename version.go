@@ -0,0 +1,50 @@
+package CertificateService
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+/*
+Version and Commit identify the build, as reported by /version. They are meant to be set when
+building, with -ldflags "-X <import path>.Version=1.4.0 -X <import path>.Commit=$(git rev-parse HEAD)".
+Left unset, the commit is read from the VCS information go build stamps into the binary.
+*/
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// buildInfo is the body of /version.
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	// Encoding is the version of the encoding stored values are written in.
+	Encoding int `json:"encoding"`
+}
+
+// commit returns Commit, or the VCS revision stamped into the binary if it isn't set.
+func commit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+/*
+versionHandler reports which build is serving, and which encoding it writes values in, as
+JSON, {"version":"1.4.0","commit":"3dfec02...","encoding":4}, to tell instances apart during a
+rolling deploy or a migration. Like /metrics it needs no key.
+*/
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildInfo{Version: Version, Commit: commit(), Encoding: int(expiryVersion)})
+}
@@ -0,0 +1,24 @@
+package CertificateService
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestVersion checks /version reports the build and the encoding values are written in, without a key.
+func TestVersion(t *testing.T) {
+	defer func(version, commit string) { Version, Commit = version, commit }(Version, Commit)
+	Version, Commit = "1.4.0", "3dfec02"
+	db := newFakeDBWithConfig(newFakeRedis(), Config{APIKeys: []string{"secret"}, APIKeyRetrieve: true, APIKeyHealth: true})
+
+	w := httptest.NewRecorder()
+	db.httpHandler(w, httptest.NewRequest("GET", "/version", nil))
+	var got buildInfo
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || w.Code != 200 {
+		t.Fatalf("expected a JSON 200, got %d %v", w.Code, err)
+	}
+	if got != (buildInfo{Version: "1.4.0", Commit: "3dfec02", Encoding: 4}) {
+		t.Errorf("unexpected build info %+v", got)
+	}
+}
@@ -0,0 +1,117 @@
+package CertificateService
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds a single POST to the expiry webhook.
+const webhookTimeout = time.Second * 10
+
+/*
+expiryNotice is the JSON body POSTed to the expiry webhook for a cert about to expire, with its
+metadata, such as its owner, if it has any.
+*/
+type expiryNotice struct {
+	Domain    string            `json:"domain"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+/*
+expiryWebhook POSTs a notice to url for every cert within threshold of expiring. notified
+remembers the expiry each domain was last notified for, so a cert is notified once per expiry
+window and again only once it is renewed. It is only used by the notifier's goroutine.
+*/
+type expiryWebhook struct {
+	url       string
+	threshold time.Duration
+	// whether a notice the webhook didn't accept with a 2xx is sent again on the next pass
+	retry    bool
+	client   *http.Client
+	notified map[string]time.Time
+}
+
+// newExpiryWebhook returns the webhook configured by cfg, or nil if there is none.
+func newExpiryWebhook(cfg Config) *expiryWebhook {
+	if cfg.ExpiryWebhookURL == "" {
+		return nil
+	}
+	return &expiryWebhook{
+		url:       cfg.ExpiryWebhookURL,
+		threshold: cfg.ExpiryWebhookThreshold,
+		retry:     cfg.ExpiryWebhookRetry,
+		client:    &http.Client{Timeout: webhookTimeout},
+		notified:  make(map[string]time.Time),
+	}
+}
+
+/*
+notifyExpiring walks every stored cert and notifies the webhook of those expiring within its
+threshold that it hasn't been notified of yet. Certs that have already expired are left to
+the sweeper. It returns the number of notices the webhook accepted.
+*/
+func (db *dbConn) notifyExpiring(ctx context.Context) (int, error) {
+	hook := db.webhook
+	now := db.now()
+	sent := 0
+	certs, errc := db.StreamCertificates(ctx)
+	for cert := range certs {
+		remaining := cert.Expires.Sub(now)
+		if remaining <= 0 || remaining > hook.threshold || hook.notified[cert.Domain].Equal(cert.Expires) {
+			continue
+		}
+		err := hook.post(ctx, expiryNotice{Domain: cert.Domain, ExpiresAt: cert.Expires, Metadata: cert.Metadata})
+		if err != nil {
+			db.logger.Error("notifying the expiry webhook failed", "domain", cert.Domain, "err", err)
+			if hook.retry {
+				continue
+			}
+		} else {
+			sent++
+		}
+		hook.notified[cert.Domain] = cert.Expires
+	}
+	// forget the certs that have expired, they can't be notified again until they are renewed
+	for domain, expires := range hook.notified {
+		if !expires.After(now) {
+			delete(hook.notified, domain)
+		}
+	}
+	return sent, <-errc
+}
+
+// post sends notice to the webhook, failing unless it answers with a 2xx.
+func (hook *expiryWebhook) post(ctx context.Context, notice expiryNotice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := hook.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// startNotifier notifies the expiry webhook of expiring certs every interval, until the returned stop func is called.
+func (db *dbConn) startNotifier(interval time.Duration) (stop func()) {
+	return runEvery(interval, func(ctx context.Context) {
+		if _, err := db.notifyExpiring(ctx); err != nil && ctx.Err() == nil {
+			db.logger.Error("checking for expiring certs failed", "err", err)
+		}
+	})
+}
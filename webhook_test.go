@@ -0,0 +1,78 @@
+package CertificateService
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+TestExpiryWebhook checks only certs within the threshold of expiring are notified, once per
+expiry, with their metadata, and that a rejected notice is only sent again when retrying is
+configured.
+*/
+func TestExpiryWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var notices []expiryNotice
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notice expiryNotice
+		if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+			t.Errorf("undecodable notice: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		notices = append(notices, notice)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	received := func() []expiryNotice {
+		mu.Lock()
+		defer mu.Unlock()
+		got := notices
+		notices = nil
+		return got
+	}
+
+	for _, retry := range []bool{false, true} {
+		db := newFakeDBWithConfig(newFakeRedis(), Config{ExpiryWebhookURL: server.URL, ExpiryWebhookThreshold: time.Minute * 5, ExpiryWebhookRetry: retry})
+		now := time.Now().Truncate(time.Second)
+		db.now = func() time.Time { return now }
+		for domain, expires := range map[string]time.Time{
+			"soon.com":    now.Add(time.Minute),
+			"later.com":   now.Add(time.Hour),
+			"expired.com": now.Add(-time.Minute),
+		} {
+			if _, _, err := db.issueCert(context.Background(), domain, nil, map[string]string{"owner": "team-a"}, expires); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if sent, err := db.notifyExpiring(context.Background()); sent != 1 || err != nil {
+			t.Fatalf("expected 1 notice, sent %d %v", sent, err)
+		}
+		if got := received(); len(got) != 1 || got[0].Domain != "soon.com" || !got[0].ExpiresAt.Equal(now.Add(time.Minute)) || got[0].Metadata["owner"] != "team-a" {
+			t.Errorf("expected a notice for soon.com, got %+v", got)
+		}
+		db.notifyExpiring(context.Background())
+		if got := received(); len(got) != 0 {
+			t.Errorf("expected soon.com to be notified once, got %+v", got)
+		}
+
+		// renewing opens a new expiry window
+		if _, err := db.storeCert(context.Background(), "soon.com", now.Add(time.Minute*2)); err != nil {
+			t.Fatal(err)
+		}
+		status = http.StatusInternalServerError
+		db.notifyExpiring(context.Background())
+		status = http.StatusOK
+		db.notifyExpiring(context.Background())
+		if got := received(); (retry && len(got) != 2) || (!retry && len(got) != 1) {
+			t.Errorf("retry %v: unexpected notices %+v", retry, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package CertificateService
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWildcardFor checks which wildcard cert covers a domain.
+func TestWildcardFor(t *testing.T) {
+	tests := map[string]string{
+		"sub.example.com":   "*.example.com",
+		"a.b.example.co.uk": "*.b.example.co.uk",
+		"example.com":       "",
+		"*.example.com":     "",
+	}
+	for domain, expected := range tests {
+		if got, _ := wildcardFor(domain); got != expected {
+			t.Errorf("wildcardFor(%q) = %q, expected %q", domain, got, expected)
+		}
+	}
+}
+
+// TestWildcardCert creates a wildcard cert and checks subdomains fall back to it after exact matches.
+func TestWildcardCert(t *testing.T) {
+	db := newFakeDB(newFakeRedis())
+	send := func(path string) string {
+		w := httptest.NewRecorder()
+		db.httpHandler(w, newRequest(path))
+		return w.Body.String()
+	}
+
+	for _, invalid := range []string{"*.com", "*.-example.com", "**.example.com", "*example.com"} {
+		if body := send("/certcreate/" + invalid); !strings.HasPrefix(body, "<h1>Invalid domain name") {
+			t.Errorf("%s: expected the wildcard to be rejected, got %s", invalid, body)
+		}
+	}
+	if body := send("/certcreate/*.Example.com"); !strings.HasPrefix(body, "<h1>OK, foo{*.example.com} created") {
+		t.Fatalf("unexpected create response %s", body)
+	}
+	cert, err := db.getCert(context.Background(), "*.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("www.example.com"); err != nil {
+		t.Errorf("the wildcard cert doesn't cover its subdomains: %v", err)
+	}
+
+	if body := send("/cert/www.example.com"); !strings.HasPrefix(body, "<h1>foo{www.example.com} covered by *.example.com valid for") {
+		t.Errorf("expected the wildcard to match, got %s", body)
+	}
+	if body := send("/cert/*.example.com"); !strings.HasPrefix(body, "<h1>foo{*.example.com} valid for") {
+		t.Errorf("expected the wildcard itself to be retrievable, got %s", body)
+	}
+	for _, uncovered := range []string{"example.com", "a.www.example.com"} {
+		if body := send("/cert/" + uncovered); !strings.HasPrefix(body, "<h1>This domain doesn't exist") {
+			t.Errorf("%s: expected no match, got %s", uncovered, body)
+		}
+	}
+
+	// an exact match takes precedence, even an expired one
+	if _, err := db.storeCert(context.Background(), "www.example.com", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if body := send("/cert/www.example.com"); !strings.HasPrefix(body, "<h1>foo{www.example.com} expired 1m") {
+		t.Errorf("expected the exact match to win, got %s", body)
+	}
+}